@@ -0,0 +1,307 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	restful "github.com/emicklei/go-restful/v3"
+	"github.com/pkg/errors"
+
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+)
+
+// maxUploadSize limits the size of uploaded chart archives. Unpacked they may
+// be up to maxUnpackRatio times as large.
+var maxUploadSize int64 = 20 << 20
+
+const maxUnpackRatio = 10
+
+var (
+	errUploadTooLarge = errors.New("upload is too large")
+	errInvalidArchive = errors.New("invalid chart archive")
+)
+
+// result of uploading a chart archive
+type archiveUpload struct {
+	Target  string `json:"target" description:"chart or package"`
+	Chart   string `json:"chart" description:"name of the chart in the workspace"`
+	Name    string `json:"name" description:"name of the chart in its Chart.yaml"`
+	Version string `json:"version"`
+	Package string `json:"package,omitempty" description:"file name of the stored package"`
+}
+
+// uploadArchive stores an uploaded chart, a gzipped tar archive as made by
+// helm package or a zip of the chart directory, either unpacked as a workspace
+// chart or as a package. chartName defaults to the name of the chart.
+func uploadArchive(data []byte, target string, chartName string, overwrite bool) (*archiveUpload, error) {
+	if target == "" {
+		target = "chart"
+	}
+	if target != "chart" && target != "package" {
+		return nil, errors.Errorf("unknown target %q", target)
+	}
+	files, err := archiveFiles(data)
+	if err != nil {
+		return nil, err
+	}
+	ch, err := loader.LoadFiles(files)
+	if err != nil {
+		return nil, invalidArchive(err)
+	}
+	if err := ch.Validate(); err != nil {
+		return nil, invalidArchive(err)
+	}
+	if chartName == "" {
+		chartName = ch.Metadata.Name
+	}
+	if err := validName(chartName); err != nil {
+		return nil, err
+	}
+	upload := &archiveUpload{
+		Target:  target,
+		Chart:   chartName,
+		Name:    ch.Metadata.Name,
+		Version: ch.Metadata.Version,
+	}
+
+	if target == "chart" {
+		return upload, unpackChartFiles(chartName, files, overwrite, "upload "+ch.Metadata.Name+"-"+ch.Metadata.Version)
+	}
+	upload.Package = fmt.Sprintf("%s-%s.tgz", ch.Metadata.Name, ch.Metadata.Version)
+	if err := validName(upload.Package); err != nil {
+		return nil, err
+	}
+	if _, err := packageWorkspace.stat(chartName, upload.Package); err == nil && !overwrite {
+		return nil, errors.Wrapf(errAlreadyExists, "package %q", upload.Package)
+	}
+	if isZip(data) {
+		// store the package the way helm package would have made it
+		if data, err = packFiles(ch.Metadata.Name, files); err != nil {
+			return nil, err
+		}
+	}
+	if err := packageWorkspace.writeFile(data, chartName, upload.Package); err != nil {
+		return nil, err
+	}
+	return upload, nil
+}
+
+// readUpload reads an uploaded file, the "file" part of a multipart form or
+// else the whole request body, up to maxUploadSize bytes.
+func readUpload(req *restful.Request, resp *restful.Response) ([]byte, error) {
+	body := http.MaxBytesReader(resp.ResponseWriter, req.Request.Body, maxUploadSize)
+	defer body.Close()
+	var r io.Reader = body
+	contentType, params, _ := mime.ParseMediaType(req.HeaderParameter("Content-Type"))
+	if contentType == "multipart/form-data" {
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				return nil, invalidArchive(errors.New(`the form has no "file" part`))
+			}
+			if err != nil {
+				return nil, uploadError(err)
+			}
+			if part.FormName() == "file" {
+				r = part
+				break
+			}
+		}
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, uploadError(err)
+	}
+	return data, nil
+}
+
+// uploadError tells an upload over maxUploadSize apart from other read errors.
+func uploadError(err error) error {
+	// http.MaxBytesReader has no error type to check for
+	if strings.Contains(err.Error(), "request body too large") {
+		return errors.Wrapf(errUploadTooLarge, "the limit is %d bytes", maxUploadSize)
+	}
+	return err
+}
+
+// archiveFiles returns the files of a chart archive relative to the chart,
+// refusing archives that unpack to more than maxUnpackRatio times their size.
+// Small archives may unpack to maxUploadSize, tar headers alone are larger
+// than a few compressed files.
+func archiveFiles(data []byte) ([]*loader.BufferedFile, error) {
+	limit := int64(len(data)) * maxUnpackRatio
+	if limit < maxUploadSize {
+		limit = maxUploadSize
+	}
+	if !isZip(data) {
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, invalidArchive(errors.New("expected a gzipped tar or a zip archive"))
+		}
+		n, err := io.Copy(ioutil.Discard, io.LimitReader(gz, limit+1))
+		if err != nil {
+			return nil, invalidArchive(err)
+		}
+		if n > limit {
+			return nil, errors.Wrap(errUploadTooLarge, "archive unpacks to too much data")
+		}
+		return loader.LoadArchiveFiles(bytes.NewReader(data))
+	}
+
+	r, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, invalidArchive(err)
+	}
+	var files []*loader.BufferedFile
+	var total int64
+	for _, f := range r.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		content, err := ioutil.ReadAll(io.LimitReader(rc, limit-total+1))
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+		if total += int64(len(content)); total > limit {
+			return nil, errors.Wrap(errUploadTooLarge, "archive unpacks to too much data")
+		}
+		name, err := cleanPath(strings.TrimPrefix(f.Name, "./"))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, &loader.BufferedFile{Name: name, Data: content})
+	}
+	return chartRootFiles(files)
+}
+
+// chartRootFiles makes the paths of files from a zip relative to the chart,
+// which is either at the top of the zip or the only directory in it.
+func chartRootFiles(files []*loader.BufferedFile) ([]*loader.BufferedFile, error) {
+	root := ""
+	for _, f := range files {
+		if f.Name == "Chart.yaml" {
+			return files, nil
+		}
+		if path.Base(f.Name) == "Chart.yaml" && strings.Count(f.Name, "/") == 1 {
+			root = path.Dir(f.Name) + "/"
+		}
+	}
+	if root == "" {
+		return nil, invalidArchive(errors.New("Chart.yaml file is missing"))
+	}
+	rooted := make([]*loader.BufferedFile, 0, len(files))
+	for _, f := range files {
+		if !strings.HasPrefix(f.Name, root) {
+			return nil, invalidArchive(errors.Errorf("%s is outside of the chart directory %s", f.Name, root))
+		}
+		rooted = append(rooted, &loader.BufferedFile{Name: strings.TrimPrefix(f.Name, root), Data: f.Data})
+	}
+	return rooted, nil
+}
+
+// packFiles makes a chart archive of files, all put below a directory named
+// after the chart like helm package does.
+func packFiles(chartName string, files []*loader.BufferedFile) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := writeChartArchive(&buf, chartName, files); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeChartArchive writes files as a gzipped tar of a chart directory to w.
+func writeChartArchive(w io.Writer, chartName string, files []*loader.BufferedFile) error {
+	gz := gzip.NewWriter(w)
+	gz.Header.Extra = []byte("+aHR0cHM6Ly95b3V0dS5iZS96OVV6MWljandyTQo=") // the header helm package sets
+	gz.Header.Comment = "Helm"
+	tw := tar.NewWriter(gz)
+	now := time.Now()
+	for _, f := range files {
+		err := tw.WriteHeader(&tar.Header{
+			Name:    path.Join(chartName, f.Name),
+			Mode:    0644,
+			Size:    int64(len(f.Data)),
+			ModTime: now,
+		})
+		if err != nil {
+			return err
+		}
+		if _, err := tw.Write(f.Data); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// loadWorkspaceChart loads a workspace chart with the files helm package would
+// take, leaving out those matched by .helmignore.
+func loadWorkspaceChart(chartName string) (*chart.Chart, []*loader.BufferedFile, error) {
+	dir, done, err := checkoutChart(chartName)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer done(false)
+	if _, err := chartWorkspace.stat(chartName); err != nil {
+		return nil, nil, err
+	}
+	ch, err := loader.LoadDir(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	files := make([]*loader.BufferedFile, 0, len(ch.Raw))
+	for _, f := range ch.Raw {
+		files = append(files, &loader.BufferedFile{Name: f.Name, Data: f.Data})
+	}
+	return ch, files, nil
+}
+
+// unpackChartFiles writes the files of a chart into the workspace as
+// chartName, verbatim so comments and formatting stay.
+func unpackChartFiles(chartName string, files []*loader.BufferedFile, overwrite bool, action string) error {
+	if err := validName(chartName); err != nil {
+		return err
+	}
+	return changeChart(chartName, action, func() error {
+		return replaceTree(chartWorkspace, chartName, files, overwrite)
+	})
+}
+
+// packageContentType is the media type of a file in the package workspace.
+func packageContentType(name string) string {
+	switch {
+	case strings.HasSuffix(name, ".tgz"), strings.HasSuffix(name, ".tar.gz"):
+		return "application/gzip"
+	case strings.HasSuffix(name, ".prov"):
+		return "application/pgp-signature"
+	}
+	return restful.MIME_OCTET
+}
+
+func invalidArchive(err error) error {
+	return fmt.Errorf("%w: %v", errInvalidArchive, err)
+}
+
+func isZip(data []byte) bool {
+	return bytes.HasPrefix(data, []byte("PK\x03\x04"))
+}
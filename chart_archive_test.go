@@ -0,0 +1,188 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	restful "github.com/emicklei/go-restful/v3"
+	"github.com/pkg/errors"
+
+	"helm.sh/helm/v3/pkg/chart/loader"
+)
+
+// zipArchive packs files into a zip, in the order given as name, content pairs.
+func zipArchive(t *testing.T, files ...string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for i := 0; i+1 < len(files); i += 2 {
+		w, err := zw.Create(files[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(files[i+1])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+const testChartYAML = "apiVersion: v2\nname: mychart\nversion: 0.1.0\n"
+
+func TestArchiveFiles(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  []byte
+		files []string
+	}{
+		{"tar", chartArchive(t, map[string]string{"mychart/Chart.yaml": testChartYAML, "mychart/values.yaml": "a: 1\n"}), []string{"Chart.yaml", "values.yaml"}},
+		{"zip of the chart", zipArchive(t, "Chart.yaml", testChartYAML, "./templates/a.yaml", "kind: A\n"), []string{"Chart.yaml", "templates/a.yaml"}},
+		{"zip of the directory", zipArchive(t, "mychart/Chart.yaml", testChartYAML, "mychart/templates/a.yaml", "kind: A\n"), []string{"Chart.yaml", "templates/a.yaml"}},
+	}
+	for _, tt := range tests {
+		files, err := archiveFiles(tt.data)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		names := map[string]bool{}
+		for _, f := range files {
+			names[f.Name] = true
+		}
+		for _, name := range tt.files {
+			if !names[name] {
+				t.Errorf("%s: %s missing from %v", tt.name, name, names)
+			}
+		}
+		if len(names) != len(tt.files) {
+			t.Errorf("%s: files %v, want %v", tt.name, names, tt.files)
+		}
+	}
+}
+
+func TestArchiveFilesZipSlip(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		err  error
+	}{
+		{"zip ..", zipArchive(t, "Chart.yaml", testChartYAML, "../evil.yaml", "x"), errPathOutsideWorkspace},
+		{"zip nested ..", zipArchive(t, "Chart.yaml", testChartYAML, "templates/../../evil.yaml", "x"), errPathOutsideWorkspace},
+		{"zip absolute", zipArchive(t, "Chart.yaml", testChartYAML, "/etc/cron.d/evil", "x"), errPathOutsideWorkspace},
+		{"zip backslash", zipArchive(t, "Chart.yaml", testChartYAML, `..\evil.yaml`, "x"), errInvalidPath},
+		{"zip outside the chart directory", zipArchive(t, "mychart/Chart.yaml", testChartYAML, "other/evil.yaml", "x"), errInvalidArchive},
+		{"zip without Chart.yaml", zipArchive(t, "values.yaml", "a: 1\n"), errInvalidArchive},
+		{"tar ..", chartArchive(t, map[string]string{"mychart/Chart.yaml": testChartYAML, "mychart/../../evil.yaml": "x"}), nil},
+		{"not an archive", []byte("Chart.yaml"), errInvalidArchive},
+	}
+	for _, tt := range tests {
+		files, err := archiveFiles(tt.data)
+		if err == nil {
+			t.Errorf("%s: accepted, files %d", tt.name, len(files))
+			continue
+		}
+		if tt.err != nil && !errors.Is(err, tt.err) {
+			t.Errorf("%s: %v, want %v", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestArchiveFilesLimits(t *testing.T) {
+	limit := maxUploadSize
+	maxUploadSize = 4 << 10
+	defer func() { maxUploadSize = limit }()
+
+	// zeros compress far better than maxUnpackRatio
+	bomb := strings.Repeat("\x00", 1<<20)
+	for name, data := range map[string][]byte{
+		"tar": chartArchive(t, map[string]string{"mychart/Chart.yaml": testChartYAML, "mychart/bomb": bomb}),
+		"zip": zipArchive(t, "Chart.yaml", testChartYAML, "bomb", bomb),
+	} {
+		if _, err := archiveFiles(data); !errors.Is(err, errUploadTooLarge) {
+			t.Errorf("%s bomb: %v, want errUploadTooLarge", name, err)
+		}
+	}
+
+	// archives smaller than the upload limit may unpack to it
+	small := chartArchive(t, map[string]string{"mychart/Chart.yaml": testChartYAML})
+	if _, err := archiveFiles(small); err != nil {
+		t.Errorf("small archive: %v", err)
+	}
+}
+
+func TestPackFiles(t *testing.T) {
+	files, err := archiveFiles(zipArchive(t, "Chart.yaml", testChartYAML, "templates/a.yaml", "kind: A\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := packFiles("mychart", files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if isZip(data) {
+		t.Error("package is a zip")
+	}
+	unpacked, err := archiveFiles(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(unpacked) != len(files) {
+		t.Errorf("%d files unpacked, %d packed", len(unpacked), len(files))
+	}
+}
+
+func TestDownloadChart(t *testing.T) {
+	tempWorkspaces(t)
+	for name, content := range templateChartFiles {
+		if err := chartWorkspace.writeFile([]byte(content), "mychart", name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ws := new(restful.WebService)
+	ws.Route(ws.GET("/archive/{chart-name}").Produces(restful.MIME_JSON, "application/gzip").To(HelmResource{}.downloadChart))
+	container := restful.NewContainer()
+	container.Add(ws)
+	srv := httptest.NewServer(container)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/archive/mychart")
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d: %s", resp.StatusCode, archive)
+	}
+	if got := resp.Header.Get("Content-Disposition"); got != `attachment; filename="mychart-0.1.0.tgz"` {
+		t.Errorf("Content-Disposition %s", got)
+	}
+	if got := resp.Header.Get("Content-Length"); got != strconv.Itoa(len(archive)) {
+		t.Errorf("Content-Length %s, read %d bytes", got, len(archive))
+	}
+	ch, err := loader.LoadArchive(bytes.NewReader(archive))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ch.Name() != "mychart" || len(ch.Templates) != 2 {
+		t.Errorf("got %s with %d templates", ch.Name(), len(ch.Templates))
+	}
+
+	resp, err = http.Get(srv.URL + "/archive/missing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("missing chart: status %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/pkg/errors"
+
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/downloader"
+	"helm.sh/helm/v3/pkg/getter"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+// a dependency of a workspace chart with its status, as helm dependency list
+// shows it
+type chartDependency struct {
+	Name       string `json:"name"`
+	Version    string `json:"version" description:"version constraint of the dependency"`
+	Repository string `json:"repository"`
+	Alias      string `json:"alias,omitempty"`
+	Condition  string `json:"condition,omitempty"`
+	Status     string `json:"status" description:"ok (archive in charts/), unpacked (directory in charts/), missing, wrong version, invalid version, misnamed, corrupt or too many matches"`
+	Found      string `json:"found,omitempty" description:"version of the chart in charts/"`
+}
+
+// the dependencies of a workspace chart
+type chartDependencies struct {
+	Dependencies []*chartDependency `json:"dependencies"`
+	Unknown      []string           `json:"unknown,omitempty" description:"charts in charts/ that are no dependency"`
+	Lock         *chart.Lock        `json:"lock,omitempty" description:"content of Chart.lock"`
+	Output       string             `json:"output,omitempty" description:"what helm printed while updating or building"`
+}
+
+// listChartDependencies returns the dependencies of a workspace chart with
+// their status.
+func listChartDependencies(chartName string) (*chartDependencies, error) {
+	dir, done, err := checkoutChart(chartName)
+	if err != nil {
+		return nil, err
+	}
+	defer done(false)
+	if _, err := chartWorkspace.stat(chartName); err != nil {
+		return nil, err
+	}
+	return dependencyStatus(dir)
+}
+
+// updateChartDependencies runs helm dependency update, or helm dependency build
+// with build set, on a workspace chart: the dependencies are downloaded into
+// charts/ and, on update, resolved anew into Chart.lock.
+func updateChartDependencies(chartName string, build bool, skipRefresh bool) (*chartDependencies, error) {
+	action := "dependency update"
+	if build {
+		action = "dependency build"
+	}
+	var deps *chartDependencies
+	err := changeChart(chartName, action, func() error {
+		dir, done, err := checkoutChart(chartName)
+		if err != nil {
+			return err
+		}
+		if _, err := chartWorkspace.stat(chartName); err != nil {
+			done(false)
+			return err
+		}
+		path, err := filepath.Abs(dir)
+		if err != nil {
+			done(false)
+			return err
+		}
+		if !skipRefresh {
+			if err := cacheDependencyRepos(path); err != nil {
+				done(false)
+				return err
+			}
+		}
+		var out bytes.Buffer
+		manager := &downloader.Manager{
+			Out:              &out,
+			ChartPath:        path,
+			Getters:          getter.All(settingsGlobal),
+			SkipUpdate:       skipRefresh,
+			Debug:            settingsGlobal.Debug,
+			RepositoryConfig: settingsGlobal.RepositoryConfig,
+			RepositoryCache:  settingsGlobal.RepositoryCache,
+		}
+		if build {
+			err = manager.Build()
+		} else {
+			err = manager.Update()
+		}
+		if err != nil {
+			done(false)
+			return errors.Wrap(err, action)
+		}
+		if deps, err = dependencyStatus(dir); err != nil {
+			done(false)
+			return err
+		}
+		deps.Output = out.String()
+		return done(true)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return deps, nil
+}
+
+// cacheDependencyRepos downloads the indexes of the repositories the
+// dependencies of the chart in dir refer to by URL, unless they are configured
+// repositories. downloader.Manager of helm 3.6 puts these into the default
+// cache of helm instead of RepositoryCache, where it looks for them after.
+func cacheDependencyRepos(dir string) error {
+	ch, err := loader.Load(dir)
+	if err != nil {
+		return err
+	}
+	rf, err := repo.LoadFile(settingsGlobal.RepositoryConfig)
+	if err != nil && !os.IsNotExist(errors.Cause(err)) {
+		return err
+	}
+	for _, dep := range ch.Metadata.Dependencies {
+		if !strings.HasPrefix(dep.Repository, "http://") && !strings.HasPrefix(dep.Repository, "https://") {
+			continue
+		}
+		configured := false
+		for _, entry := range rf.Repositories {
+			configured = configured || strings.TrimSuffix(entry.URL, "/") == strings.TrimSuffix(dep.Repository, "/")
+		}
+		if configured {
+			continue
+		}
+		// the name downloader.Manager gives repositories it isn't configured with
+		sum := sha256.Sum256([]byte(dep.Repository))
+		entry := &repo.Entry{Name: "helm-manager-" + hex.EncodeToString(sum[:]), URL: dep.Repository}
+		r, err := repo.NewChartRepository(entry, getter.All(settingsGlobal))
+		if err != nil {
+			return err
+		}
+		r.CachePath = settingsGlobal.RepositoryCache
+		if _, err := r.DownloadIndexFile(); err != nil {
+			return errors.Wrapf(err, "failed to get the index of %s", dep.Repository)
+		}
+	}
+	return nil
+}
+
+// dependencyStatus loads the chart in dir and checks its dependencies against
+// the charts in its charts/ directory.
+func dependencyStatus(dir string) (*chartDependencies, error) {
+	ch, err := loader.Load(dir)
+	if err != nil {
+		return nil, err
+	}
+	deps := &chartDependencies{Dependencies: []*chartDependency{}, Lock: ch.Lock}
+	for _, dep := range ch.Metadata.Dependencies {
+		d := &chartDependency{
+			Name:       dep.Name,
+			Version:    dep.Version,
+			Repository: dep.Repository,
+			Alias:      dep.Alias,
+			Condition:  dep.Condition,
+		}
+		d.Status, d.Found = archiveDependencyStatus(dir, dep)
+		if d.Status == "" {
+			d.Status, d.Found = "missing", ""
+			for _, sub := range ch.Dependencies() {
+				if sub.Name() == dep.Name {
+					d.Status, d.Found = versionStatus(dep, sub.Metadata.Version, "unpacked")
+				}
+			}
+		}
+		deps.Dependencies = append(deps.Dependencies, d)
+	}
+
+	known := map[string]bool{}
+	for _, dep := range ch.Metadata.Dependencies {
+		known[dep.Name] = true
+	}
+	for _, sub := range ch.Dependencies() {
+		if !known[sub.Name()] {
+			deps.Unknown = append(deps.Unknown, sub.Name())
+		}
+	}
+	return deps, nil
+}
+
+// archiveDependencyStatus checks the archive of a dependency in charts/, the
+// way helm does before looking at the unpacked charts. It returns an empty
+// status if there is no archive.
+func archiveDependencyStatus(dir string, dep *chart.Dependency) (string, string) {
+	archives, err := filepath.Glob(filepath.Join(dir, "charts", fmt.Sprintf("%s-*.tgz", dep.Name)))
+	if err != nil {
+		return "bad pattern", ""
+	}
+	if len(archives) > 1 {
+		// only those named <name>-<version>.tgz count
+		found := []string{}
+		for _, archive := range archives {
+			version := strings.TrimPrefix(strings.TrimSuffix(filepath.Base(archive), ".tgz"), dep.Name+"-")
+			if _, err := semver.StrictNewVersion(version); err == nil {
+				found = append(found, archive)
+			}
+		}
+		if len(found) > 1 {
+			return "too many matches", ""
+		}
+		archives = found
+	}
+	if len(archives) == 0 {
+		return "", ""
+	}
+	if _, err := os.Stat(archives[0]); err != nil {
+		return "", ""
+	}
+	sub, err := loader.Load(archives[0])
+	if err != nil {
+		return "corrupt", ""
+	}
+	if sub.Name() != dep.Name {
+		return "misnamed", sub.Metadata.Version
+	}
+	return versionStatus(dep, sub.Metadata.Version, "ok")
+}
+
+// versionStatus checks the version of the chart found for a dependency against
+// its constraint.
+func versionStatus(dep *chart.Dependency, version string, ok string) (string, string) {
+	if version == dep.Version {
+		return ok, version
+	}
+	constraint, err := semver.NewConstraint(dep.Version)
+	if err != nil {
+		return "invalid version", version
+	}
+	v, err := semver.NewVersion(version)
+	if err != nil {
+		return "invalid version", version
+	}
+	if !constraint.Check(v) {
+		return "wrong version", version
+	}
+	return ok, version
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"helm.sh/helm/v3/pkg/chart"
+)
+
+func TestDependencyStatus(t *testing.T) {
+	deps, err := dependencyStatus("testdata/dependencies")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		status string
+		found  string
+	}{
+		{"db", "ok", "1.2.3"},
+		{"cache", "unpacked", "2.1.0"},
+		{"queue", "missing", ""},
+		{"search", "wrong version", "2.0.0"},
+		{"auth", "misnamed", "1.0.0"},
+		{"web", "too many matches", ""},
+		{"metrics", "invalid version", "1.0.0"},
+	}
+	if len(deps.Dependencies) != len(tests) {
+		t.Fatalf("got %d dependencies, want %d", len(deps.Dependencies), len(tests))
+	}
+	for i, tt := range tests {
+		d := deps.Dependencies[i]
+		if d.Name != tt.name || d.Status != tt.status || d.Found != tt.found {
+			t.Errorf("%s: got %s %q found %q, want %q found %q", tt.name, d.Name, d.Status, d.Found, tt.status, tt.found)
+		}
+	}
+	sort.Strings(deps.Unknown)
+	if want := []string{"authn", "extra"}; !reflect.DeepEqual(deps.Unknown, want) {
+		t.Errorf("unknown %v, want %v", deps.Unknown, want)
+	}
+}
+
+func TestVersionStatus(t *testing.T) {
+	tests := []struct {
+		constraint string
+		version    string
+		status     string
+	}{
+		{"1.2.3", "1.2.3", "ok"},
+		{"~1.2.0", "1.2.9", "ok"},
+		{"~1.2.0", "1.3.0", "wrong version"},
+		{">=1.0.0 <2.0.0", "2.0.0", "wrong version"},
+		{"latest", "1.0.0", "invalid version"},
+		{"^1.0.0", "one", "invalid version"},
+		{"", "1.0.0", "invalid version"},
+	}
+	for _, tt := range tests {
+		status, found := versionStatus(&chart.Dependency{Name: "db", Version: tt.constraint}, tt.version, "ok")
+		if status != tt.status || found != tt.version {
+			t.Errorf("%q with %s: got %s %s, want %s", tt.constraint, tt.version, status, found, tt.status)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// one file or directory of a workspace chart
+type chartFileEntry struct {
+	Path     string            `json:"path" description:"path relative to the chart"`
+	Name     string            `json:"name"`
+	Type     string            `json:"type" description:"file or dir"`
+	Size     int64             `json:"size"`
+	ModTime  time.Time         `json:"mod_time"`
+	Hash     string            `json:"hash,omitempty" description:"sha256 of the content of a file, its ETag without quotes"`
+	Kind     string            `json:"kind,omitempty" description:"chart, values, schema, template, helper, notes, test, crd, subchart, subchart-archive, dependency-lock, helmignore, doc or other"`
+	Children []*chartFileEntry `json:"children,omitempty"`
+}
+
+// chartFiles lists the files and directories of a workspace chart, together
+// with an ETag of the whole chart that changes with any of its files.
+func chartFiles(chartName string) ([]*chartFileEntry, string, error) {
+	if err := validName(chartName); err != nil {
+		return nil, "", err
+	}
+	entries := []*chartFileEntry{}
+	tree := sha256.New()
+	err := chartWorkspace.walk(func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel := strings.TrimPrefix(strings.TrimPrefix(file, chartName), "/")
+		if rel == "" {
+			// the chart directory itself
+			return nil
+		}
+		entry := &chartFileEntry{
+			Path:    rel,
+			Name:    info.Name(),
+			Type:    "file",
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+			Kind:    chartFileKind(rel, info.IsDir()),
+		}
+		if info.IsDir() {
+			entry.Type = "dir"
+			entry.Size = 0
+		} else {
+			content, err := chartWorkspace.readFile(file)
+			if err != nil {
+				return err
+			}
+			sum := sha256.Sum256(content)
+			entry.Hash = hex.EncodeToString(sum[:])
+			fmt.Fprintf(tree, "%s %s\n", rel, entry.Hash)
+		}
+		entries = append(entries, entry)
+		return nil
+	}, chartName)
+	if err != nil {
+		return nil, "", err
+	}
+	return entries, `"` + hex.EncodeToString(tree.Sum(nil)) + `"`, nil
+}
+
+// chartFileTree nests the entries, as listed by chartFiles, below their directories.
+func chartFileTree(chartName string, entries []*chartFileEntry) *chartFileEntry {
+	root := &chartFileEntry{Name: chartName, Type: "dir", Children: []*chartFileEntry{}}
+	dirs := map[string]*chartFileEntry{"": root}
+	for _, entry := range entries {
+		parent := dirs[parentDir(entry.Path)]
+		if parent == nil {
+			parent = root
+		}
+		if entry.Type == "dir" {
+			entry.Children = []*chartFileEntry{}
+			dirs[entry.Path] = entry
+		}
+		parent.Children = append(parent.Children, entry)
+	}
+	return root
+}
+
+// chartFileKind classifies a file of a chart by its role, as far as helm
+// tells from the path.
+func chartFileKind(rel string, dir bool) string {
+	segments := strings.Split(rel, "/")
+	base := segments[len(segments)-1]
+	if dir {
+		if len(segments) == 2 && segments[0] == "charts" {
+			return "subchart"
+		}
+		return ""
+	}
+	switch segments[0] {
+	case "templates":
+		switch {
+		case len(segments) > 2 && segments[1] == "tests":
+			return "test"
+		case strings.HasPrefix(base, "_"):
+			return "helper"
+		case base == "NOTES.txt":
+			return "notes"
+		}
+		return "template"
+	case "crds":
+		return "crd"
+	case "charts":
+		if len(segments) == 2 && (strings.HasSuffix(base, ".tgz") || strings.HasSuffix(base, ".tar.gz")) {
+			return "subchart-archive"
+		}
+		return "subchart"
+	}
+	if len(segments) > 1 {
+		return "other"
+	}
+	switch {
+	case base == "Chart.yaml":
+		return "chart"
+	case base == "values.schema.json":
+		return "schema"
+	case strings.HasPrefix(base, "values") && (path.Ext(base) == ".yaml" || path.Ext(base) == ".yml"):
+		return "values"
+	case base == "Chart.lock" || base == "requirements.yaml" || base == "requirements.lock":
+		return "dependency-lock"
+	case base == ".helmignore":
+		return "helmignore"
+	case strings.HasPrefix(strings.ToUpper(base), "README") || strings.HasPrefix(strings.ToUpper(base), "LICENSE"):
+		return "doc"
+	}
+	return "other"
+}
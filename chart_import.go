@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chart/loader"
+)
+
+// metaWorkspace keeps what the server knows about the workspace charts besides
+// their files, under <chart>/.
+var metaWorkspace = &workspace{store: &localStore{root: ".helm/chart-meta"}}
+
+var errAlreadyExists = errors.New("already exists")
+
+// information of a chart import request
+type ImportInfo struct {
+	Chart     string `json:"chart" description:"chart reference (repo/chart), chart URL or oci:// reference" default:"string"`
+	Version   string `json:"version" description:"version constraint of the chart, the tag of an oci reference" default:"string"`
+	RepoURL   string `json:"repo_url" description:"repository URL to look the chart up in, instead of a configured repository" default:"string"`
+	Username  string `json:"username" description:"username of the repository or registry" default:"string"`
+	Password  string `json:"password" description:"password of the repository or registry" default:"string"`
+	PlainHTTP bool   `json:"plain_http" description:"use http instead of https to talk to an OCI registry" default:"false"`
+	Name      string `json:"name" description:"name of the chart in the workspace, the name of the chart when empty" default:"string"`
+	Overwrite bool   `json:"overwrite" description:"replace a workspace chart of the same name" default:"false"`
+	Verify    bool   `json:"verify" description:"verify the chart against the trusted keys, always done for verified-only repositories" default:"false"`
+}
+
+// where a workspace chart was imported from
+type chartOrigin struct {
+	Source     string    `json:"source" description:"repo, url or oci"`
+	Chart      string    `json:"chart"`
+	RepoURL    string    `json:"repo_url,omitempty"`
+	Name       string    `json:"name" description:"name of the chart in its Chart.yaml"`
+	Version    string    `json:"version" description:"version of the imported chart"`
+	AppVersion string    `json:"app_version,omitempty"`
+	Digest     string    `json:"digest" description:"sha256 of the imported chart archive"`
+	Verified   bool      `json:"verified,omitempty" description:"whether the chart was verified against the trusted keys"`
+	ImportedAt time.Time `json:"imported_at"`
+}
+
+// importChart pulls a chart and extracts it into the workspace.
+func importChart(importInfo *ImportInfo) (*chartOrigin, error) {
+	if importInfo.Chart == "" {
+		return nil, errors.New("chart is required")
+	}
+	origin := &chartOrigin{Chart: importInfo.Chart, RepoURL: importInfo.RepoURL}
+	var archive []byte
+	if strings.HasPrefix(importInfo.Chart, "oci://") {
+		if importInfo.Verify {
+			return nil, errors.Wrap(errVerificationFailed, "charts from OCI registries have no provenance to verify")
+		}
+		ref, err := parseOCIReference(importInfo.Chart, importInfo.Version)
+		if err != nil {
+			return nil, err
+		}
+		client := &ociClient{
+			username:  importInfo.Username,
+			password:  importInfo.Password,
+			plainHTTP: importInfo.PlainHTTP,
+		}
+		if archive, err = client.pull(ref); err != nil {
+			return nil, err
+		}
+		origin.Source = "oci"
+		origin.Chart = ref.String()
+	} else {
+		// LocateChart would read local paths of the server
+		if isLocalPath(importInfo.Chart) || implicitChartSource(importInfo.Chart) == sourceRepo && strings.Contains(importInfo.Chart, "://") {
+			return nil, errors.Wrapf(errInvalidChartRef, "%q is not a chart reference or URL", importInfo.Chart)
+		}
+		opts := action.ChartPathOptions{
+			Version:  importInfo.Version,
+			RepoURL:  importInfo.RepoURL,
+			Username: importInfo.Username,
+			Password: importInfo.Password,
+		}
+		cleanup, err := verifyChartOptions(&opts, importInfo.Chart, importInfo.Verify)
+		if err != nil {
+			return nil, err
+		}
+		defer cleanup()
+		cp, err := opts.LocateChart(importInfo.Chart, settingsGlobal)
+		if err != nil {
+			return nil, err
+		}
+		if archive, err = ioutil.ReadFile(cp); err != nil {
+			return nil, err
+		}
+		origin.Verified = opts.Verify
+		origin.Source = "repo"
+		if strings.Contains(importInfo.Chart, "://") {
+			origin.Source = "url"
+		}
+	}
+
+	ch, err := loader.LoadArchive(bytes.NewReader(archive))
+	if err != nil {
+		return nil, invalidArchive(err)
+	}
+	sum := sha256.Sum256(archive)
+	origin.Name = ch.Metadata.Name
+	origin.Version = ch.Metadata.Version
+	origin.AppVersion = ch.Metadata.AppVersion
+	origin.Digest = "sha256:" + hex.EncodeToString(sum[:])
+	origin.ImportedAt = time.Now()
+
+	chartName := importInfo.Name
+	if chartName == "" {
+		chartName = ch.Metadata.Name
+	}
+	if err := unpackChart(chartName, archive, importInfo.Overwrite, "import "+origin.Chart); err != nil {
+		return nil, err
+	}
+	data, err := json.MarshalIndent(origin, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := metaWorkspace.writeFile(data, chartName, "origin.json"); err != nil {
+		return nil, err
+	}
+	return origin, nil
+}
+
+// unpackChart extracts a chart archive into the workspace as chartName.
+func unpackChart(chartName string, archive []byte, overwrite bool, action string) error {
+	files, err := loader.LoadArchiveFiles(bytes.NewReader(archive))
+	if err != nil {
+		return invalidArchive(err)
+	}
+	return unpackChartFiles(chartName, files, overwrite, action)
+}
+
+// getChartOrigin returns where a workspace chart was imported from.
+func getChartOrigin(chartName string) (*chartOrigin, error) {
+	if err := validName(chartName); err != nil {
+		return nil, err
+	}
+	data, err := metaWorkspace.readFile(chartName, "origin.json")
+	if err != nil {
+		return nil, err
+	}
+	origin := &chartOrigin{}
+	if err := json.Unmarshal(data, origin); err != nil {
+		return nil, err
+	}
+	return origin, nil
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/pkg/errors"
+	yamlv3 "gopkg.in/yaml.v3"
+	"sigs.k8s.io/yaml"
+
+	"helm.sh/helm/v3/pkg/chart"
+)
+
+var errInvalidMetadata = errors.New("invalid chart metadata")
+
+// editableMetadata are the fields of Chart.yaml a metadata patch may set. The
+// name is the one of the workspace chart, version and appVersion are bumped.
+var editableMetadata = map[string]bool{
+	"description":  true,
+	"keywords":     true,
+	"maintainers":  true,
+	"home":         true,
+	"sources":      true,
+	"icon":         true,
+	"annotations":  true,
+	"type":         true,
+	"kubeVersion":  true,
+	"dependencies": true,
+}
+
+// result of bumping a version of a chart
+type versionBump struct {
+	Field    string `json:"field" description:"version or appVersion"`
+	Previous string `json:"previous"`
+	Version  string `json:"version"`
+}
+
+// getChartMetadata returns the metadata of a workspace chart together with the
+// ETag of its Chart.yaml.
+func getChartMetadata(chartName string) (*chart.Metadata, string, error) {
+	if err := validName(chartName); err != nil {
+		return nil, "", err
+	}
+	content, err := chartWorkspace.readFile(chartName, "Chart.yaml")
+	if err != nil {
+		return nil, "", err
+	}
+	md := &chart.Metadata{}
+	if err := yaml.Unmarshal(content, md); err != nil {
+		return nil, "", err
+	}
+	return md, contentETag(content), nil
+}
+
+// patchChartMetadata applies a JSON merge patch of the editable fields to the
+// Chart.yaml of a workspace chart: fields of the patch replace those of the
+// chart, null removes them. Comments and the order of the fields stay.
+func patchChartMetadata(req *http.Request, chartName string, patch []byte) (*chart.Metadata, string, error) {
+	if err := validName(chartName); err != nil {
+		return nil, "", err
+	}
+	// JSON is YAML, decoding the patch as such keeps the order of its fields
+	patchDoc := &yamlv3.Node{}
+	if err := yamlv3.Unmarshal(patch, patchDoc); err != nil {
+		return nil, "", errors.Wrap(errInvalidMetadata, err.Error())
+	}
+	if len(patchDoc.Content) == 0 || patchDoc.Content[0].Kind != yamlv3.MappingNode {
+		return nil, "", errors.Wrap(errInvalidMetadata, "the patch must be a JSON object")
+	}
+	fields := patchDoc.Content[0].Content
+	for i := 0; i < len(fields); i += 2 {
+		if !editableMetadata[fields[i].Value] {
+			return nil, "", errors.Wrapf(errInvalidMetadata, "field %q can't be patched", fields[i].Value)
+		}
+	}
+
+	var md *chart.Metadata
+	var content []byte
+	err := changeChart(chartName, "edit metadata", func() error {
+		if err := checkPreconditions(req, chartWorkspace, chartName, "Chart.yaml"); err != nil {
+			return err
+		}
+		doc, err := readChartYAML(chartName)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < len(fields); i += 2 {
+			if fields[i+1].Tag == "!!null" {
+				removeYAMLField(doc.Content[0], fields[i].Value)
+				continue
+			}
+			blockStyle(fields[i+1])
+			setYAMLField(doc.Content[0], fields[i].Value, fields[i+1])
+		}
+		if content, err = encodeYAML(doc); err != nil {
+			return err
+		}
+		if md, err = validateMetadata(content); err != nil {
+			return err
+		}
+		if md.APIVersion == chart.APIVersionV1 && len(md.Dependencies) > 0 {
+			return errors.Wrap(errInvalidMetadata, "apiVersion v1 charts keep their dependencies in requirements.yaml")
+		}
+		return chartWorkspace.writeFile(content, chartName, "Chart.yaml")
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return md, contentETag(content), nil
+}
+
+// bumpChartVersion increments the version or appVersion of a workspace chart
+// by part, major, minor, patch or prerelease. A prerelease bump counts up the
+// last number of the prerelease, or starts preid.0 (rc.0 by default) on the
+// next patch version. Only the version itself changes in Chart.yaml.
+func bumpChartVersion(req *http.Request, chartName string, field string, part string, preid string) (*versionBump, string, error) {
+	if err := validName(chartName); err != nil {
+		return nil, "", err
+	}
+	bump := &versionBump{Field: field}
+	var content []byte
+	err := changeChart(chartName, "bump "+field, func() error {
+		if err := checkPreconditions(req, chartWorkspace, chartName, "Chart.yaml"); err != nil {
+			return err
+		}
+		original, err := chartWorkspace.readFile(chartName, "Chart.yaml")
+		if err != nil {
+			return err
+		}
+		doc, err := parseChartYAML(original)
+		if err != nil {
+			return err
+		}
+		node := yamlField(doc.Content[0], field)
+		if node == nil || node.Kind != yamlv3.ScalarNode || node.Value == "" {
+			return errors.Wrapf(errInvalidMetadata, "the chart has no %s", field)
+		}
+		bump.Previous = node.Value
+		if bump.Version, err = nextVersion(node.Value, part, preid); err != nil {
+			return err
+		}
+		if content = replaceScalar(original, node, bump.Version); content == nil {
+			// the value isn't where the parser says, write the whole document
+			node.Value = bump.Version
+			if content, err = encodeYAML(doc); err != nil {
+				return err
+			}
+		}
+		if _, err := validateMetadata(content); err != nil {
+			return err
+		}
+		return chartWorkspace.writeFile(content, chartName, "Chart.yaml")
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return bump, contentETag(content), nil
+}
+
+// nextVersion increments part of the semantic version v.
+func nextVersion(v string, part string, preid string) (string, error) {
+	version, err := semver.NewVersion(v)
+	if err != nil {
+		return "", errors.Wrapf(errInvalidMetadata, "%q is not a semantic version", v)
+	}
+	var next semver.Version
+	switch part {
+	case "major":
+		next = version.IncMajor()
+	case "minor":
+		next = version.IncMinor()
+	case "patch":
+		next = version.IncPatch()
+	case "prerelease":
+		pre := version.Prerelease()
+		if pre == "" || (preid != "" && pre != preid && !strings.HasPrefix(pre, preid+".")) {
+			if preid == "" {
+				preid = "rc"
+			}
+			if pre == "" {
+				*version = version.IncPatch()
+			}
+			pre = preid + ".0"
+		} else {
+			ids := strings.Split(pre, ".")
+			if n, err := strconv.Atoi(ids[len(ids)-1]); err == nil {
+				ids[len(ids)-1] = strconv.Itoa(n + 1)
+			} else {
+				ids = append(ids, "0")
+			}
+			pre = strings.Join(ids, ".")
+		}
+		if next, err = version.SetPrerelease(pre); err != nil {
+			return "", errors.Wrap(errInvalidMetadata, err.Error())
+		}
+	default:
+		return "", errors.Errorf("unknown version part %q", part)
+	}
+	return next.Original(), nil
+}
+
+// validateMetadata loads the metadata of a Chart.yaml the way helm does and
+// validates it.
+func validateMetadata(content []byte) (*chart.Metadata, error) {
+	md := &chart.Metadata{}
+	if err := yaml.Unmarshal(content, md); err != nil {
+		return nil, errors.Wrap(errInvalidMetadata, err.Error())
+	}
+	if err := md.Validate(); err != nil {
+		return nil, errors.Wrap(errInvalidMetadata, err.Error())
+	}
+	return md, nil
+}
+
+func readChartYAML(chartName string) (*yamlv3.Node, error) {
+	content, err := chartWorkspace.readFile(chartName, "Chart.yaml")
+	if err != nil {
+		return nil, err
+	}
+	return parseChartYAML(content)
+}
+
+// parseChartYAML parses a Chart.yaml into its document node, the mapping of
+// the metadata.
+func parseChartYAML(content []byte) (*yamlv3.Node, error) {
+	doc := &yamlv3.Node{}
+	if err := yamlv3.Unmarshal(content, doc); err != nil {
+		return nil, errors.Wrap(errInvalidMetadata, err.Error())
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yamlv3.MappingNode {
+		return nil, errors.Wrap(errInvalidMetadata, "Chart.yaml is not a mapping")
+	}
+	return doc, nil
+}
+
+func encodeYAML(doc *yamlv3.Node) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := yamlv3.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(doc); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// yamlField returns the value of a field of a mapping, nil if there is no such
+// field.
+func yamlField(mapping *yamlv3.Node, key string) *yamlv3.Node {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// setYAMLField replaces the value of a field of a mapping, keeping the
+// comments of the old value, or adds the field at the end.
+func setYAMLField(mapping *yamlv3.Node, key string, value *yamlv3.Node) {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			keepComments(mapping.Content[i+1], value)
+			mapping.Content[i+1] = value
+			return
+		}
+	}
+	mapping.Content = append(mapping.Content, &yamlv3.Node{Kind: yamlv3.ScalarNode, Tag: "!!str", Value: key}, value)
+}
+
+// removeYAMLField removes a field of a mapping, telling if it was there.
+func removeYAMLField(mapping *yamlv3.Node, key string) bool {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			mapping.Content = append(mapping.Content[:i], mapping.Content[i+2:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// keepComments moves the comments of a node to the node replacing it, unless
+// that has comments of its own.
+func keepComments(old *yamlv3.Node, value *yamlv3.Node) {
+	if value.HeadComment == "" {
+		value.HeadComment = old.HeadComment
+	}
+	if value.LineComment == "" {
+		value.LineComment = old.LineComment
+	}
+}
+
+// blockStyle clears the flow and quoting styles of a node decoded from JSON,
+// so it's written like the rest of a Chart.yaml.
+func blockStyle(node *yamlv3.Node) {
+	node.Style = 0
+	for _, child := range node.Content {
+		blockStyle(child)
+	}
+}
+
+// replaceScalar replaces the text of the scalar node in content by value,
+// keeping its quotes. It returns nil if the node isn't found where expected.
+func replaceScalar(content []byte, node *yamlv3.Node, value string) []byte {
+	token, replacement := node.Value, value
+	switch {
+	case node.Style&yamlv3.DoubleQuotedStyle != 0:
+		token, replacement = `"`+token+`"`, `"`+replacement+`"`
+	case node.Style&yamlv3.SingleQuotedStyle != 0:
+		token, replacement = "'"+token+"'", "'"+replacement+"'"
+	case node.Style != 0:
+		return nil
+	}
+	lines := bytes.SplitAfter(content, []byte("\n"))
+	if node.Line < 1 || node.Line > len(lines) {
+		return nil
+	}
+	line := lines[node.Line-1]
+	start := node.Column - 1
+	if start < 0 || start > len(line) || !bytes.HasPrefix(line[start:], []byte(token)) {
+		return nil
+	}
+	lines[node.Line-1] = append(append(append([]byte{}, line[:start]...), replacement...), line[start+len(token):]...)
+	return bytes.Join(lines, nil)
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+const metadataChartYAML = `# the web chart
+apiVersion: v2
+name: web
+description: a web server # shown in the hub
+version: "1.2.3"
+appVersion: '2.0'
+keywords:
+  - web
+icon: https://example.com/icon.png
+`
+
+func TestPatchChartMetadata(t *testing.T) {
+	tempWorkspaces(t)
+	tests := []struct {
+		name  string
+		patch string
+		want  []string
+		gone  []string
+	}{
+		{
+			"replace and add",
+			`{"description": "the web server", "home": "https://example.com"}`,
+			[]string{"# the web chart\n", "description: the web server # shown in the hub\n", "home: https://example.com\n", `version: "1.2.3"`},
+			[]string{"a web server"},
+		},
+		{
+			"list",
+			`{"keywords": ["web", "http"]}`,
+			[]string{"keywords:\n- web\n- http\nicon:"},
+			nil,
+		},
+		{
+			"remove",
+			`{"icon": null}`,
+			[]string{"name: web\n"},
+			[]string{"icon:"},
+		},
+	}
+	for _, tt := range tests {
+		if err := chartWorkspace.writeFile([]byte(metadataChartYAML), "web", "Chart.yaml"); err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest(http.MethodPatch, "/chart/web/metadata", nil)
+		if _, _, err := patchChartMetadata(req, "web", []byte(tt.patch)); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		content, _ := chartWorkspace.readFile("web", "Chart.yaml")
+		for _, want := range tt.want {
+			if !strings.Contains(string(content), want) {
+				t.Errorf("%s: %q missing from\n%s", tt.name, want, content)
+			}
+		}
+		for _, gone := range tt.gone {
+			if strings.Contains(string(content), gone) {
+				t.Errorf("%s: %q left in\n%s", tt.name, gone, content)
+			}
+		}
+	}
+}
+
+func TestPatchChartMetadataRefused(t *testing.T) {
+	tempWorkspaces(t)
+	if err := chartWorkspace.writeFile([]byte(metadataChartYAML), "web", "Chart.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		patch string
+	}{
+		{"name", `{"name": "other"}`},
+		{"version", `{"description": "x", "version": "9.9.9"}`},
+		{"apiVersion", `{"apiVersion": "v1"}`},
+		{"unknown field", `{"replicas": 3}`},
+		{"not an object", `["description"]`},
+		{"not JSON", `{"description": `},
+		{"invalid type", `{"type": "plugin"}`},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPatch, "/chart/web/metadata", nil)
+		_, _, err := patchChartMetadata(req, "web", []byte(tt.patch))
+		if !errors.Is(err, errInvalidMetadata) {
+			t.Errorf("%s: %v, want invalid metadata", tt.name, err)
+		}
+		if content, _ := chartWorkspace.readFile("web", "Chart.yaml"); string(content) != metadataChartYAML {
+			t.Errorf("%s: Chart.yaml changed to\n%s", tt.name, content)
+		}
+	}
+}
+
+func TestNextVersion(t *testing.T) {
+	tests := []struct {
+		version string
+		part    string
+		preid   string
+		want    string
+	}{
+		{"1.2.3", "major", "", "2.0.0"},
+		{"1.2.3", "minor", "", "1.3.0"},
+		{"1.2.3", "patch", "", "1.2.4"},
+		{"v1.2.3", "patch", "", "v1.2.4"},
+		{"1.2.3-rc.1", "patch", "", "1.2.3"},
+		{"1.2.3-rc.1", "minor", "", "1.3.0"},
+		{"1.2.3", "prerelease", "", "1.2.4-rc.0"},
+		{"1.2.3", "prerelease", "beta", "1.2.4-beta.0"},
+		{"1.2.4-rc.0", "prerelease", "", "1.2.4-rc.1"},
+		{"1.2.4-rc.9", "prerelease", "rc", "1.2.4-rc.10"},
+		{"1.2.4-rc.1", "prerelease", "beta", "1.2.4-beta.0"},
+		{"1.2.4-alpha", "prerelease", "", "1.2.4-alpha.0"},
+	}
+	for _, tt := range tests {
+		got, err := nextVersion(tt.version, tt.part, tt.preid)
+		if err != nil {
+			t.Errorf("%s %s %s: %v", tt.version, tt.part, tt.preid, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s %s %s: got %s, want %s", tt.version, tt.part, tt.preid, got, tt.want)
+		}
+	}
+
+	if _, err := nextVersion("latest", "patch", ""); !errors.Is(err, errInvalidMetadata) {
+		t.Errorf("non-semver version: %v", err)
+	}
+	if _, err := nextVersion("1.2.3", "build", ""); err == nil {
+		t.Error("unknown part accepted")
+	}
+}
+
+func TestReplaceScalar(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"plain", "name: web\nversion: 1.2.3\n", "name: web\nversion: 1.2.4\n"},
+		{"double quoted", "version: \"1.2.3\" # release\n", "version: \"1.2.4\" # release\n"},
+		{"single quoted", "version:   '1.2.3'\nname: web\n", "version:   '1.2.4'\nname: web\n"},
+		{"no trailing newline", "name: web\nversion: 1.2.3", "name: web\nversion: 1.2.4"},
+		{"literal block", "version: |\n  1.2.3\n", ""},
+	}
+	for _, tt := range tests {
+		doc, err := parseChartYAML([]byte(tt.content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := replaceScalar([]byte(tt.content), yamlField(doc.Content[0], "version"), "1.2.4")
+		if string(got) != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	// a node that doesn't match the content isn't replaced
+	node := &yamlv3.Node{Kind: yamlv3.ScalarNode, Value: "1.2.3", Line: 1, Column: 3}
+	if got := replaceScalar([]byte("version: 1.2.3\n"), node, "1.2.4"); got != nil {
+		t.Errorf("misplaced node: got %q", got)
+	}
+}
+
+func TestBumpChartVersion(t *testing.T) {
+	tempWorkspaces(t)
+	if err := chartWorkspace.writeFile([]byte(metadataChartYAML), "web", "Chart.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/chart/web/version", nil)
+	bump, _, err := bumpChartVersion(req, "web", "version", "minor", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bump.Previous != "1.2.3" || bump.Version != "1.3.0" {
+		t.Errorf("got %+v", bump)
+	}
+	content, _ := chartWorkspace.readFile("web", "Chart.yaml")
+	if want := strings.Replace(metadataChartYAML, `"1.2.3"`, `"1.3.0"`, 1); string(content) != want {
+		t.Errorf("got\n%s\nwant\n%s", content, want)
+	}
+}
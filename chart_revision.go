@@ -0,0 +1,445 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// The history of the workspace charts is kept as content-addressed snapshots:
+// every file content is stored once under <chart>/objects/<sha256>, and every
+// revision under <chart>/revisions/<n>.json lists the files of the chart with
+// the hashes of their contents.
+var historyWorkspace = &workspace{store: &localStore{root: ".helm/chart-history"}}
+
+// one revision of a workspace chart
+type chartRevision struct {
+	Revision int               `json:"revision"`
+	Created  time.Time         `json:"created"`
+	Action   string            `json:"action" description:"the change that led to the revision"`
+	Files    map[string]string `json:"files,omitempty" description:"sha256 of the content of every file, by path"`
+}
+
+// chartLocks serializes the changes of each workspace chart within the server,
+// so that every revision records the outcome of exactly one change. Changes
+// of different charts don't wait for each other. Replicas sharing the store
+// are kept from recording the same revision by recordChartRevision.
+var chartLocks = struct {
+	sync.Mutex
+	locks map[string]*chartLock
+}{locks: map[string]*chartLock{}}
+
+type chartLock struct {
+	sync.Mutex
+	users int
+}
+
+// maxRevisionAttempts bounds the retries of recording a revision whose number
+// another replica took first.
+const maxRevisionAttempts = 10
+
+// lockChart locks a chart against other changes, returning the unlock function.
+func lockChart(chartName string) func() {
+	chartLocks.Lock()
+	l := chartLocks.locks[chartName]
+	if l == nil {
+		l = &chartLock{}
+		chartLocks.locks[chartName] = l
+	}
+	l.users++
+	chartLocks.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		chartLocks.Lock()
+		if l.users--; l.users == 0 {
+			delete(chartLocks.locks, chartName)
+		}
+		chartLocks.Unlock()
+	}
+}
+
+// changeChart applies a change to a workspace chart and records the outcome as
+// a new revision. A chart without history gets its state before the change
+// recorded first, so the change can be undone. Failing to record history
+// doesn't fail the change.
+func changeChart(chartName string, action string, change func() error) error {
+	if err := validName(chartName); err != nil {
+		return err
+	}
+	defer lockChart(chartName)()
+
+	revisions, err := chartRevisions(chartName)
+	if err != nil {
+		log.Println(err)
+	} else if len(revisions) == 0 {
+		if _, err := chartWorkspace.stat(chartName); err == nil {
+			if _, err := recordChartRevision(chartName, "snapshot"); err != nil {
+				log.Println(err)
+			}
+		}
+	}
+	if err := change(); err != nil {
+		return err
+	}
+	if _, err := recordChartRevision(chartName, action); err != nil {
+		log.Println(err)
+	}
+	return nil
+}
+
+// recordChartRevision snapshots the chart as it is in the workspace. Nothing is
+// recorded when the chart didn't change since the latest revision. Revisions
+// are created only if absent, so that a replica taking the same number first
+// makes this one try the next number.
+func recordChartRevision(chartName string, action string) (*chartRevision, error) {
+	files, err := snapshotChart(chartName)
+	if err != nil {
+		return nil, err
+	}
+	for attempt := 1; ; attempt++ {
+		revisions, err := chartRevisions(chartName)
+		if err != nil {
+			return nil, err
+		}
+		next := 1
+		if len(revisions) > 0 {
+			latest, err := getChartRevision(chartName, revisions[len(revisions)-1])
+			if err != nil {
+				return nil, err
+			}
+			if sameFiles(latest.Files, files) {
+				return latest, nil
+			}
+			next = latest.Revision + 1
+		}
+		rev := &chartRevision{
+			Revision: next,
+			Created:  time.Now(),
+			Action:   action,
+			Files:    files,
+		}
+		data, err := json.Marshal(rev)
+		if err != nil {
+			return nil, err
+		}
+		err = historyWorkspace.createFile(data, chartName, "revisions", fmt.Sprintf("%d.json", next))
+		if err == nil {
+			return rev, nil
+		}
+		if !os.IsExist(errors.Cause(err)) || attempt == maxRevisionAttempts {
+			return nil, err
+		}
+	}
+}
+
+// snapshotChart stores the contents of the files of a chart and returns their hashes.
+func snapshotChart(chartName string) (map[string]string, error) {
+	return walkChart(chartName, true)
+}
+
+// hashChart returns the hashes of the files of a chart without storing them.
+func hashChart(chartName string) (map[string]string, error) {
+	return walkChart(chartName, false)
+}
+
+// walkChart hashes the files of a chart, storing the contents missing from the
+// history with store set.
+func walkChart(chartName string, store bool) (map[string]string, error) {
+	files := map[string]string{}
+	err := chartWorkspace.walk(func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			if file == chartName && os.IsNotExist(err) {
+				// a removed chart has no files
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		content, err := chartWorkspace.readFile(file)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(content)
+		hash := hex.EncodeToString(sum[:])
+		files[strings.TrimPrefix(file, chartName+"/")] = hash
+		if !store {
+			return nil
+		}
+		if _, err := historyWorkspace.stat(chartName, "objects", hash); os.IsNotExist(errors.Cause(err)) {
+			return historyWorkspace.writeFile(content, chartName, "objects", hash)
+		} else if err != nil {
+			return err
+		}
+		return nil
+	}, chartName)
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// chartRevisions returns the revision numbers of a chart in ascending order.
+func chartRevisions(chartName string) ([]int, error) {
+	infos, err := historyWorkspace.readDir(chartName, "revisions")
+	if os.IsNotExist(errors.Cause(err)) {
+		return []int{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	revisions := []int{}
+	for _, info := range infos {
+		n, err := strconv.Atoi(strings.TrimSuffix(info.Name(), ".json"))
+		if err != nil {
+			continue
+		}
+		revisions = append(revisions, n)
+	}
+	sort.Ints(revisions)
+	return revisions, nil
+}
+
+// listChartRevisions returns the revisions of a chart, without their files.
+func listChartRevisions(chartName string) ([]*chartRevision, error) {
+	if err := validName(chartName); err != nil {
+		return nil, err
+	}
+	revisions, err := chartRevisions(chartName)
+	if err != nil {
+		return nil, err
+	}
+	list := []*chartRevision{}
+	for _, n := range revisions {
+		rev, err := getChartRevision(chartName, n)
+		if err != nil {
+			return nil, err
+		}
+		rev.Files = nil
+		list = append(list, rev)
+	}
+	return list, nil
+}
+
+func getChartRevision(chartName string, revision int) (*chartRevision, error) {
+	if err := validName(chartName); err != nil {
+		return nil, err
+	}
+	data, err := historyWorkspace.readFile(chartName, "revisions", fmt.Sprintf("%d.json", revision))
+	if err != nil {
+		return nil, err
+	}
+	rev := &chartRevision{}
+	if err := json.Unmarshal(data, rev); err != nil {
+		return nil, err
+	}
+	return rev, nil
+}
+
+// chartRevisionFile returns the content of a file at a revision of a chart.
+func chartRevisionFile(chartName string, revision int, filePath string) ([]byte, error) {
+	rev, err := getChartRevision(chartName, revision)
+	if err != nil {
+		return nil, err
+	}
+	name, err := cleanPath(filePath)
+	if err != nil {
+		return nil, err
+	}
+	hash, ok := rev.Files[name]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: path.Join(chartName, name), Err: os.ErrNotExist}
+	}
+	return historyWorkspace.readFile(chartName, "objects", hash)
+}
+
+// restoreChartRevision puts the files of a chart back to how they were at a
+// revision, including a chart that was removed since.
+func restoreChartRevision(chartName string, revision int) (*chartRevision, error) {
+	rev, err := getChartRevision(chartName, revision)
+	if err != nil {
+		return nil, err
+	}
+	restored := rev
+	err = changeChart(chartName, fmt.Sprintf("restore revision %d", revision), func() error {
+		current, err := hashChart(chartName)
+		if err != nil {
+			return err
+		}
+		for file, hash := range rev.Files {
+			if current[file] == hash {
+				continue
+			}
+			content, err := historyWorkspace.readFile(chartName, "objects", hash)
+			if err != nil {
+				return err
+			}
+			if err := chartWorkspace.writeFile(content, chartName, file); err != nil {
+				return err
+			}
+		}
+		for file := range current {
+			if _, ok := rev.Files[file]; !ok {
+				if err := chartWorkspace.remove(chartName, file); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	revisions, err := chartRevisions(chartName)
+	if err == nil && len(revisions) > 0 {
+		restored, err = getChartRevision(chartName, revisions[len(revisions)-1])
+	}
+	if err != nil {
+		return nil, err
+	}
+	restored.Files = nil
+	return restored, nil
+}
+
+// diffChart returns a unified diff of a chart between two revisions. A to of 0
+// stands for the chart as it is in the workspace.
+func diffChart(chartName string, from int, to int) (string, error) {
+	fromRev, err := getChartRevision(chartName, from)
+	if err != nil {
+		return "", err
+	}
+	toFiles := map[string]string{}
+	toName := "workspace"
+	// the files of the workspace aren't in the history, they are read as they are
+	toObject := func(file string, hash string) ([]byte, error) {
+		if hash == "" {
+			return nil, nil
+		}
+		return chartWorkspace.readFile(chartName, file)
+	}
+	if to == 0 {
+		unlock := lockChart(chartName)
+		defer unlock()
+		toFiles, err = hashChart(chartName)
+	} else {
+		toObject = func(file string, hash string) ([]byte, error) {
+			return historyObject(chartName, hash)
+		}
+		var toRev *chartRevision
+		toRev, err = getChartRevision(chartName, to)
+		if toRev != nil {
+			toFiles = toRev.Files
+			toName = fmt.Sprintf("revision %d", to)
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+
+	var files []string
+	for file := range fromRev.Files {
+		files = append(files, file)
+	}
+	for file := range toFiles {
+		if _, ok := fromRev.Files[file]; !ok {
+			files = append(files, file)
+		}
+	}
+	sort.Strings(files)
+
+	var out bytes.Buffer
+	for _, file := range files {
+		fromHash, toHash := fromRev.Files[file], toFiles[file]
+		if fromHash == toHash {
+			continue
+		}
+		fromContent, err := historyObject(chartName, fromHash)
+		if err != nil {
+			return "", err
+		}
+		toContent, err := toObject(file, toHash)
+		if err != nil {
+			return "", err
+		}
+		fromFile, toFile := "a/"+file, "b/"+file
+		if fromHash == "" {
+			fromFile = "/dev/null"
+		}
+		if toHash == "" {
+			toFile = "/dev/null"
+		}
+		if isBinary(fromContent) || isBinary(toContent) {
+			fmt.Fprintf(&out, "Binary files %s and %s differ\n", fromFile, toFile)
+			continue
+		}
+		diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+			A:        diffLines(fromContent),
+			B:        diffLines(toContent),
+			FromFile: fromFile,
+			FromDate: fmt.Sprintf("revision %d", from),
+			ToFile:   toFile,
+			ToDate:   toName,
+			Context:  3,
+		})
+		if err != nil {
+			return "", err
+		}
+		out.WriteString(diff)
+	}
+	return out.String(), nil
+}
+
+// historyObject returns a stored file content, nothing for an empty hash.
+func historyObject(chartName string, hash string) ([]byte, error) {
+	if hash == "" {
+		return nil, nil
+	}
+	return historyWorkspace.readFile(chartName, "objects", hash)
+}
+
+// diffLines splits content into lines ending in a newline. difflib.SplitLines
+// would make an empty last line of the final newline.
+func diffLines(content []byte) []string {
+	if len(content) == 0 {
+		return nil
+	}
+	lines := strings.SplitAfter(string(content), "\n")
+	if last := len(lines) - 1; lines[last] == "" {
+		lines = lines[:last]
+	} else {
+		lines[last] += "\n"
+	}
+	return lines
+}
+
+func isBinary(content []byte) bool {
+	return bytes.IndexByte(content, 0) != -1
+}
+
+func sameFiles(a map[string]string, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for file, hash := range a {
+		if b[file] != hash {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+// racingStore takes the name of the next file created, like a replica
+// recording the same revision first.
+type racingStore struct {
+	workspaceStore
+	raced bool
+}
+
+func (s *racingStore) createFile(name string, data []byte) error {
+	if !s.raced {
+		s.raced = true
+		if err := s.workspaceStore.createFile(name, []byte(`{"revision":2,"action":"other replica","files":{}}`)); err != nil {
+			return err
+		}
+	}
+	return s.workspaceStore.createFile(name, data)
+}
+
+func TestRecordChartRevisionRace(t *testing.T) {
+	tempWorkspaces(t)
+	if err := chartWorkspace.writeFile([]byte("name: mychart\n"), "mychart", "Chart.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := recordChartRevision("mychart", "create"); err != nil {
+		t.Fatal(err)
+	}
+	historyWorkspace.store = &racingStore{workspaceStore: historyWorkspace.store}
+
+	if err := chartWorkspace.writeFile([]byte("name: mychart\nversion: 0.2.0\n"), "mychart", "Chart.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	rev, err := recordChartRevision("mychart", "edit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rev.Revision != 3 {
+		t.Errorf("revision = %d, want 3", rev.Revision)
+	}
+	other, err := getChartRevision("mychart", 2)
+	if err != nil || other.Action != "other replica" {
+		t.Errorf("revision of the other replica = %+v, %v", other, err)
+	}
+}
+
+func TestChangeChartConcurrent(t *testing.T) {
+	tempWorkspaces(t)
+	if err := chartWorkspace.writeFile([]byte("name: mychart\n"), "mychart", "Chart.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			err := changeChart("mychart", fmt.Sprintf("edit %d", i), func() error {
+				return chartWorkspace.writeFile([]byte(fmt.Sprintf("v%d", i)), "mychart", fmt.Sprintf("file%d.txt", i))
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	revisions, err := chartRevisions("mychart")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the snapshot before the first change and one revision per change
+	if len(revisions) != 21 {
+		t.Errorf("%d revisions, want 21", len(revisions))
+	}
+	latest, err := getChartRevision("mychart", revisions[len(revisions)-1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(latest.Files) != 21 {
+		t.Errorf("latest revision has %d files, want 21", len(latest.Files))
+	}
+	if len(chartLocks.locks) != 0 {
+		t.Errorf("%d chart locks left", len(chartLocks.locks))
+	}
+}
+
+// editChart writes a file of mychart as a change, and removes it for nil content.
+func editChart(t *testing.T, action string, file string, content []byte) {
+	t.Helper()
+	err := changeChart("mychart", action, func() error {
+		if content == nil {
+			return chartWorkspace.remove("mychart", file)
+		}
+		return chartWorkspace.writeFile(content, "mychart", file)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDiffChart(t *testing.T) {
+	tempWorkspaces(t)
+	editChart(t, "create", "Chart.yaml", []byte("name: mychart\nversion: 0.1.0\n"))
+	editChart(t, "add values", "values.yaml", []byte("replicaCount: 1\n"))
+	editChart(t, "bump", "Chart.yaml", []byte("name: mychart\nversion: 0.2.0\n"))
+	editChart(t, "add binary", "files/logo.png", []byte("\x89PNG\x00"))
+	editChart(t, "drop values", "values.yaml", nil)
+
+	diff, err := diffChart("mychart", 1, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `--- a/Chart.yaml	revision 1
++++ b/Chart.yaml	revision 3
+@@ -1,2 +1,2 @@
+ name: mychart
+-version: 0.1.0
++version: 0.2.0
+--- /dev/null	revision 1
++++ b/values.yaml	revision 3
+@@ -0,0 +1 @@
++replicaCount: 1
+`
+	if diff != want {
+		t.Errorf("diff 1..3:\n%s\nwant:\n%s", diff, want)
+	}
+
+	// against the workspace
+	diff, err = diffChart("mychart", 3, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"Binary files /dev/null and b/files/logo.png differ\n",
+		"--- a/values.yaml\trevision 3\n+++ /dev/null\tworkspace\n",
+		"-replicaCount: 1\n",
+	} {
+		if !strings.Contains(diff, line) {
+			t.Errorf("diff 3..workspace lacks %q:\n%s", line, diff)
+		}
+	}
+
+	// diffing against the workspace doesn't store its files in the history
+	objects, err := historyWorkspace.readDir("mychart", "objects")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := chartWorkspace.writeFile([]byte("name: mychart\nversion: 0.3.0\n"), "mychart", "Chart.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	diff, err = diffChart("mychart", 5, 0)
+	if err != nil || !strings.Contains(diff, "+version: 0.3.0\n") {
+		t.Errorf("diff 5..workspace = %q, %v", diff, err)
+	}
+	if after, err := historyWorkspace.readDir("mychart", "objects"); err != nil || len(after) != len(objects) {
+		t.Errorf("%d objects after the diff, %d before: %v", len(after), len(objects), err)
+	}
+	if err := chartWorkspace.writeFile([]byte("name: mychart\nversion: 0.2.0\n"), "mychart", "Chart.yaml"); err != nil {
+		t.Fatal(err)
+	}
+
+	if diff, err := diffChart("mychart", 5, 0); err != nil || diff != "" {
+		t.Errorf("diff of the latest revision to the workspace = %q, %v", diff, err)
+	}
+	if _, err := diffChart("mychart", 42, 0); !os.IsNotExist(errors.Cause(err)) {
+		t.Errorf("diff of a missing revision = %v", err)
+	}
+}
+
+func TestRestoreChartRevision(t *testing.T) {
+	tempWorkspaces(t)
+	editChart(t, "create", "Chart.yaml", []byte("name: mychart\nversion: 0.1.0\n"))
+	editChart(t, "add values", "values.yaml", []byte("replicaCount: 1\n"))
+	editChart(t, "bump", "Chart.yaml", []byte("name: mychart\nversion: 0.2.0\n"))
+	editChart(t, "add template", "templates/a.yaml", []byte("kind: A\n"))
+
+	rev, err := restoreChartRevision("mychart", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rev.Revision != 5 || rev.Action != "restore revision 2" || rev.Files != nil {
+		t.Errorf("restored = %+v", rev)
+	}
+	content, err := chartWorkspace.readFile("mychart", "Chart.yaml")
+	if err != nil || string(content) != "name: mychart\nversion: 0.1.0\n" {
+		t.Errorf("Chart.yaml = %q, %v", content, err)
+	}
+	if _, err := chartWorkspace.stat("mychart", "templates", "a.yaml"); !os.IsNotExist(errors.Cause(err)) {
+		t.Errorf("file added after the revision is still there: %v", err)
+	}
+	if content, err := chartRevisionFile("mychart", 4, "templates/a.yaml"); err != nil || string(content) != "kind: A\n" {
+		t.Errorf("templates/a.yaml of revision 4 = %q, %v", content, err)
+	}
+
+	// a removed chart comes back
+	if err := chartWorkspace.removeAll("mychart"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := restoreChartRevision("mychart", 4); err != nil {
+		t.Fatal(err)
+	}
+	if content, err := chartWorkspace.readFile("mychart", "templates", "a.yaml"); err != nil || string(content) != "kind: A\n" {
+		t.Errorf("templates/a.yaml of the restored chart = %q, %v", content, err)
+	}
+
+	if _, err := restoreChartRevision("mychart", 42); !os.IsNotExist(errors.Cause(err)) {
+		t.Errorf("restore of a missing revision = %v", err)
+	}
+}
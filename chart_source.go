@@ -0,0 +1,167 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"helm.sh/helm/v3/pkg/action"
+)
+
+var (
+	errInvalidChartRef = errors.New("invalid chart reference")
+	errChartNotFound   = errors.New("chart not found")
+)
+
+// Sources of the chart of a release.
+const (
+	sourceWorkspace = "workspace"
+	sourcePackage   = "package"
+	sourceRepo      = "repo"
+	sourceURL       = "url"
+	sourceOCI       = "oci"
+)
+
+// resolveChartSource turns the chart of a release into the reference
+// LocateChart is called with, setting up opts for it. Workspace charts and
+// packages resolve to their files in the workspace, OCI charts are pulled into
+// a temporary archive; call cleanup once the chart is loaded. Without a source
+// it is told by the chart: oci:// and http(s):// references are pulled as
+// such, anything else must be a repo/chart reference. Local paths of the server
+// are never resolved.
+func resolveChartSource(releaseInfo *ReleaseInfo, opts *action.ChartPathOptions) (string, func(), error) {
+	chartRef := releaseInfo.Chart
+	source := releaseInfo.Source
+	if source == "" {
+		source = implicitChartSource(releaseInfo.Chart)
+	}
+	var free func()
+	switch source {
+	case sourceWorkspace:
+		if releaseInfo.Verify {
+			return "", nil, errors.Wrap(errVerificationFailed, "workspace charts have no provenance to verify")
+		}
+		dir, done, err := checkoutChart(releaseInfo.Chart)
+		if err != nil {
+			return "", nil, err
+		}
+		if _, err := chartWorkspace.stat(releaseInfo.Chart); err != nil {
+			done(false)
+			return "", nil, err
+		}
+		if chartRef, err = filepath.Abs(dir); err != nil {
+			done(false)
+			return "", nil, err
+		}
+		free = func() { done(false) }
+	case sourcePackage:
+		if err := validName(releaseInfo.Chart); err != nil {
+			return "", nil, err
+		}
+		if err := validName(releaseInfo.Package); err != nil {
+			return "", nil, err
+		}
+		if _, err := packageWorkspace.stat(releaseInfo.Chart, releaseInfo.Package); err != nil {
+			return "", nil, err
+		}
+		// the provenance file is checked out along with the package
+		dir, done, err := packageWorkspace.checkout(releaseInfo.Chart)
+		if err != nil {
+			return "", nil, err
+		}
+		if chartRef, err = filepath.Abs(filepath.Join(dir, releaseInfo.Package)); err != nil {
+			done(false)
+			return "", nil, err
+		}
+		free = func() { done(false) }
+	case sourceRepo:
+		// LocateChart would read local paths of the server
+		if isLocalPath(releaseInfo.Chart) || strings.Contains(releaseInfo.Chart, "://") {
+			return "", nil, errors.Wrapf(errInvalidChartRef, "%q is not a chart reference", releaseInfo.Chart)
+		}
+		if releaseInfo.RepoURL == "" && !strings.Contains(releaseInfo.Chart, "/") {
+			return "", nil, errors.Wrapf(errInvalidChartRef, "%q is not a chart reference, expected repo/chart", releaseInfo.Chart)
+		}
+		opts.Version = releaseInfo.ChartVersion
+		opts.RepoURL = releaseInfo.RepoURL
+	case sourceURL:
+		if !strings.HasPrefix(releaseInfo.Chart, "http://") && !strings.HasPrefix(releaseInfo.Chart, "https://") {
+			return "", nil, errors.Wrapf(errInvalidChartRef, "%q is not an http or https URL", releaseInfo.Chart)
+		}
+	case sourceOCI:
+		if releaseInfo.Verify {
+			return "", nil, errors.Wrap(errVerificationFailed, "charts from OCI registries have no provenance to verify")
+		}
+		if !strings.HasPrefix(releaseInfo.Chart, "oci://") {
+			return "", nil, errors.Wrapf(errInvalidChartRef, "%q is not an oci:// reference", releaseInfo.Chart)
+		}
+		ref, err := parseOCIReference(releaseInfo.Chart, releaseInfo.ChartVersion)
+		if err != nil {
+			return "", nil, err
+		}
+		client := &ociClient{plainHTTP: releaseInfo.PlainHTTP}
+		archive, err := client.pull(ref)
+		if err != nil {
+			return "", nil, err
+		}
+		f, err := ioutil.TempFile("", "helm-rest-chart-*.tgz")
+		if err != nil {
+			return "", nil, err
+		}
+		free = func() { os.Remove(f.Name()) }
+		_, err = f.Write(archive)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			free()
+			return "", nil, err
+		}
+		chartRef = f.Name()
+	default:
+		return "", nil, errors.Wrapf(errInvalidChartRef, "unknown chart source %q, expected workspace, package, repo, url or oci", releaseInfo.Source)
+	}
+	if free == nil {
+		free = func() {}
+	}
+
+	cleanup, err := verifyChartOptions(opts, chartRef, releaseInfo.Verify)
+	if err != nil {
+		free()
+		return "", nil, err
+	}
+	return chartRef, func() {
+		cleanup()
+		free()
+	}, nil
+}
+
+// implicitChartSource tells the source of a chart given without one.
+func implicitChartSource(chartRef string) string {
+	switch {
+	case strings.HasPrefix(chartRef, "oci://"):
+		return sourceOCI
+	case strings.HasPrefix(chartRef, "http://"), strings.HasPrefix(chartRef, "https://"):
+		return sourceURL
+	}
+	return sourceRepo
+}
+
+// isLocalPath tells if LocateChart would take a chart reference for a path on
+// the server: an absolute or relative path, one with .. segments or one that
+// exists.
+func isLocalPath(chartRef string) bool {
+	if chartRef == "" || filepath.IsAbs(chartRef) || strings.HasPrefix(chartRef, "/") || strings.HasPrefix(chartRef, ".") || strings.ContainsAny(chartRef, "\\\x00") {
+		return true
+	}
+	for _, segment := range strings.Split(chartRef, "/") {
+		if segment == ".." || segment == "." {
+			return true
+		}
+	}
+	_, err := os.Stat(chartRef)
+	return err == nil
+}
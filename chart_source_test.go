@@ -0,0 +1,75 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"helm.sh/helm/v3/pkg/action"
+)
+
+func TestResolveChartSource(t *testing.T) {
+	dir := tempWorkspaces(t)
+	repositoryConfig := settingsGlobal.RepositoryConfig
+	settingsGlobal.RepositoryConfig = filepath.Join(dir, "repositories.yaml")
+	defer func() { settingsGlobal.RepositoryConfig = repositoryConfig }()
+	if err := chartWorkspace.writeFile([]byte("apiVersion: v2\nname: mychart\nversion: 0.1.0\n"), "mychart", "Chart.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	if err := packageWorkspace.writeFile([]byte("package"), "mychart", "mychart-0.1.0.tgz"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		info ReleaseInfo
+		// the chart reference resolved to, a suffix of it for local files
+		want string
+		err  string
+	}{
+		{info: ReleaseInfo{Source: "workspace", Chart: "mychart"}, want: "/charts/mychart"},
+		{info: ReleaseInfo{Source: "workspace", Chart: "missing"}, err: "no such file"},
+		{info: ReleaseInfo{Source: "workspace", Chart: "../chart-package/mychart"}, err: "invalid path"},
+		{info: ReleaseInfo{Source: "workspace", Chart: "mychart", Verify: true}, err: "no provenance"},
+		{info: ReleaseInfo{Source: "package", Chart: "mychart", Package: "mychart-0.1.0.tgz"}, want: "/chart-package/mychart/mychart-0.1.0.tgz"},
+		{info: ReleaseInfo{Source: "package", Chart: "mychart", Package: "../../charts/mychart/Chart.yaml"}, err: "invalid path"},
+		{info: ReleaseInfo{Source: "package", Chart: "mychart", Package: "missing.tgz"}, err: "no such file"},
+		{info: ReleaseInfo{Source: "repo", Chart: "stable/nginx"}, want: "stable/nginx"},
+		{info: ReleaseInfo{Source: "repo", Chart: "nginx", RepoURL: "https://charts.example.com"}, want: "nginx"},
+		{info: ReleaseInfo{Source: "repo", Chart: "nginx"}, err: "expected repo/chart"},
+		{info: ReleaseInfo{Source: "repo", Chart: "/etc/passwd"}, err: "not a chart reference"},
+		{info: ReleaseInfo{Source: "repo", Chart: "stable/../../etc"}, err: "not a chart reference"},
+		{info: ReleaseInfo{Source: "url", Chart: "https://example.com/nginx-1.0.0.tgz"}, want: "https://example.com/nginx-1.0.0.tgz"},
+		{info: ReleaseInfo{Source: "url", Chart: "file:///etc/passwd"}, err: "not an http or https URL"},
+		{info: ReleaseInfo{Source: "oci", Chart: "https://example.com/nginx"}, err: "not an oci:// reference"},
+		{info: ReleaseInfo{Source: "ftp", Chart: "x"}, err: "unknown chart source"},
+		// without a source
+		{info: ReleaseInfo{Chart: "stable/nginx"}, want: "stable/nginx"},
+		{info: ReleaseInfo{Chart: "https://example.com/nginx-1.0.0.tgz"}, want: "https://example.com/nginx-1.0.0.tgz"},
+		{info: ReleaseInfo{Chart: ".helm/charts/mychart"}, err: "not a chart reference"},
+		{info: ReleaseInfo{Chart: "/etc"}, err: "not a chart reference"},
+		{info: ReleaseInfo{Chart: "charts/../../etc"}, err: "not a chart reference"},
+		{info: ReleaseInfo{Chart: dir}, err: "not a chart reference"},
+		{info: ReleaseInfo{Chart: ""}, err: "not a chart reference"},
+	}
+	for _, tt := range tests {
+		opts := &action.ChartPathOptions{}
+		chartRef, cleanup, err := resolveChartSource(&tt.info, opts)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("resolveChartSource(%+v) = %q, %v, want error %q", tt.info, chartRef, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("resolveChartSource(%+v) = %v", tt.info, err)
+			continue
+		}
+		cleanup()
+		if chartRef != tt.want && !(filepath.IsAbs(chartRef) && strings.HasSuffix(chartRef, tt.want)) {
+			t.Errorf("resolveChartSource(%+v) = %q, want %q", tt.info, chartRef, tt.want)
+		}
+		if opts.Verify {
+			t.Errorf("resolveChartSource(%+v) verifies without being asked to", tt.info)
+		}
+	}
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	restful "github.com/emicklei/go-restful/v3"
+	"github.com/pkg/errors"
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+// Media types of the patches of values files.
+const (
+	jsonPatchType  = "application/json-patch+json"
+	mergePatchType = "application/merge-patch+json"
+)
+
+var errInvalidPatch = errors.New("the patch can't be applied")
+
+// one operation of a JSON patch, RFC 6902
+type jsonPatchOperation struct {
+	Op    string      `yaml:"op"`
+	Path  string      `yaml:"path"`
+	From  string      `yaml:"from"`
+	Value yamlv3.Node `yaml:"value"`
+}
+
+// patchValues applies a JSON patch (RFC 6902) or a JSON merge patch (RFC 7396)
+// to a values file of a workspace chart and returns the new content of the
+// file. The patch is applied to the YAML document, so the comments and the
+// order of the values stay.
+func patchValues(req *http.Request, chartName string, file string, patchType string, patch []byte) ([]byte, error) {
+	if err := validName(chartName); err != nil {
+		return nil, err
+	}
+	name, err := cleanPath(file)
+	if err != nil {
+		return nil, err
+	}
+	if chartFileKind(name, false) != "values" {
+		return nil, errors.Wrapf(errInvalidPath, "%q is not a values file", file)
+	}
+
+	var apply func(doc *yamlv3.Node) error
+	switch patchType {
+	case jsonPatchType:
+		ops := []jsonPatchOperation{}
+		if err := yamlv3.Unmarshal(patch, &ops); err != nil {
+			return nil, errors.Wrap(errInvalidPatch, err.Error())
+		}
+		apply = func(doc *yamlv3.Node) error {
+			return applyJSONPatch(doc, ops)
+		}
+	case mergePatchType, restful.MIME_JSON:
+		mergeDoc := &yamlv3.Node{}
+		if err := yamlv3.Unmarshal(patch, mergeDoc); err != nil {
+			return nil, errors.Wrap(errInvalidPatch, err.Error())
+		}
+		if len(mergeDoc.Content) == 0 {
+			return nil, errors.Wrap(errInvalidPatch, "the patch is empty")
+		}
+		apply = func(doc *yamlv3.Node) error {
+			doc.Content[0] = mergePatch(doc.Content[0], mergeDoc.Content[0])
+			return nil
+		}
+	default:
+		return nil, errors.Errorf("unknown patch type %q", patchType)
+	}
+
+	var content []byte
+	err = changeChart(chartName, "patch "+name, func() error {
+		if err := checkPreconditions(req, chartWorkspace, chartName, name); err != nil {
+			return err
+		}
+		original, err := chartWorkspace.readFile(chartName, name)
+		if err != nil {
+			return err
+		}
+		doc := &yamlv3.Node{}
+		if err := yamlv3.Unmarshal(original, doc); err != nil {
+			return errors.Wrapf(errInvalidPatch, "%s is no valid YAML: %v", name, err)
+		}
+		if len(doc.Content) == 0 {
+			doc = &yamlv3.Node{Kind: yamlv3.DocumentNode, Content: []*yamlv3.Node{{Kind: yamlv3.MappingNode, Tag: "!!map"}}}
+		}
+		if err := apply(doc); err != nil {
+			return err
+		}
+		if doc.Content[0].Kind != yamlv3.MappingNode {
+			return errors.Wrap(errInvalidPatch, "values must be a mapping")
+		}
+		if content, err = encodeYAML(doc); err != nil {
+			return err
+		}
+		return chartWorkspace.writeFile(content, chartName, name)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return content, nil
+}
+
+// applyJSONPatch applies the operations of a JSON patch to doc one after the
+// other, stopping at the first one that fails.
+func applyJSONPatch(doc *yamlv3.Node, ops []jsonPatchOperation) error {
+	for i := range ops {
+		op := &ops[i]
+		var err error
+		switch op.Op {
+		case "add", "replace", "test":
+			if op.Value.Kind == 0 {
+				err = errors.New("value is missing")
+				break
+			}
+			blockStyle(&op.Value)
+			switch op.Op {
+			case "add":
+				err = yamlAdd(doc, op.Path, &op.Value)
+			case "replace":
+				err = yamlReplace(doc, op.Path, &op.Value)
+			default:
+				err = yamlTest(doc, op.Path, &op.Value)
+			}
+		case "remove":
+			_, err = yamlRemove(doc, op.Path)
+		case "move":
+			if op.Path == op.From {
+				break
+			}
+			if strings.HasPrefix(op.Path, op.From+"/") {
+				err = errors.Errorf("can't move %s into itself", op.From)
+				break
+			}
+			var value *yamlv3.Node
+			if value, err = yamlRemove(doc, op.From); err == nil {
+				err = yamlAdd(doc, op.Path, value)
+			}
+		case "copy":
+			var value *yamlv3.Node
+			if value, err = yamlPointer(doc, op.From); err == nil {
+				err = yamlAdd(doc, op.Path, copyNode(value))
+			}
+		default:
+			err = errors.Errorf("unknown operation %q", op.Op)
+		}
+		if err != nil {
+			return errors.Wrapf(errInvalidPatch, "operation %d (%s %s): %v", i, op.Op, op.Path, err)
+		}
+	}
+	return nil
+}
+
+// mergePatch merges patch into target as RFC 7396 describes and returns the
+// result, target itself when both are mappings.
+func mergePatch(target *yamlv3.Node, patch *yamlv3.Node) *yamlv3.Node {
+	if patch.Kind != yamlv3.MappingNode {
+		blockStyle(patch)
+		return patch
+	}
+	if target == nil || target.Kind != yamlv3.MappingNode {
+		target = &yamlv3.Node{Kind: yamlv3.MappingNode, Tag: "!!map"}
+	}
+	for i := 0; i+1 < len(patch.Content); i += 2 {
+		key, value := patch.Content[i].Value, patch.Content[i+1]
+		if value.Tag == "!!null" {
+			removeYAMLField(target, key)
+			continue
+		}
+		setYAMLField(target, key, mergePatch(yamlField(target, key), value))
+	}
+	return target
+}
+
+// parsePointer splits a JSON pointer, RFC 6901, into its unescaped tokens.
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, errors.Errorf("invalid JSON pointer %q", pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.Replace(strings.Replace(token, "~1", "/", -1), "~0", "~", -1)
+	}
+	return tokens, nil
+}
+
+// yamlPointer returns the node a JSON pointer refers to in doc.
+func yamlPointer(doc *yamlv3.Node, pointer string) (*yamlv3.Node, error) {
+	tokens, err := parsePointer(pointer)
+	if err != nil {
+		return nil, err
+	}
+	return yamlTokens(doc.Content[0], tokens)
+}
+
+func yamlTokens(node *yamlv3.Node, tokens []string) (*yamlv3.Node, error) {
+	for _, token := range tokens {
+		var err error
+		if node, err = yamlChild(node, token); err != nil {
+			return nil, err
+		}
+	}
+	return node, nil
+}
+
+// yamlChild returns the child of a mapping or sequence a token of a JSON
+// pointer refers to.
+func yamlChild(node *yamlv3.Node, token string) (*yamlv3.Node, error) {
+	if node.Kind == yamlv3.AliasNode {
+		node = node.Alias
+	}
+	switch node.Kind {
+	case yamlv3.MappingNode:
+		if child := yamlField(node, token); child != nil {
+			return child, nil
+		}
+	case yamlv3.SequenceNode:
+		index, err := sequenceIndex(node, token, false)
+		if err != nil {
+			return nil, err
+		}
+		return node.Content[index], nil
+	}
+	return nil, errors.Errorf("%q doesn't exist", token)
+}
+
+// yamlParent returns the mapping or sequence holding the node a JSON pointer
+// refers to, with the last token of the pointer.
+func yamlParent(doc *yamlv3.Node, pointer string) (*yamlv3.Node, string, error) {
+	tokens, err := parsePointer(pointer)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(tokens) == 0 {
+		return nil, "", errors.New("the operation doesn't apply to the whole document")
+	}
+	parent, err := yamlTokens(doc.Content[0], tokens[:len(tokens)-1])
+	if err != nil {
+		return nil, "", err
+	}
+	if parent.Kind == yamlv3.AliasNode {
+		parent = parent.Alias
+	}
+	if parent.Kind != yamlv3.MappingNode && parent.Kind != yamlv3.SequenceNode {
+		return nil, "", errors.Errorf("the parent of %s is not an object or array", pointer)
+	}
+	return parent, tokens[len(tokens)-1], nil
+}
+
+// sequenceIndex parses a token of a JSON pointer as index into a sequence.
+// With insert set the index may also be the end of the sequence, "-" is the
+// end.
+func sequenceIndex(node *yamlv3.Node, token string, insert bool) (int, error) {
+	if insert && token == "-" {
+		return len(node.Content), nil
+	}
+	index, err := strconv.Atoi(token)
+	if err != nil || index < 0 || (token != "0" && strings.HasPrefix(token, "0")) {
+		return 0, errors.Errorf("invalid array index %q", token)
+	}
+	if index > len(node.Content) || (!insert && index == len(node.Content)) {
+		return 0, errors.Errorf("array index %d is out of bounds", index)
+	}
+	return index, nil
+}
+
+func yamlAdd(doc *yamlv3.Node, pointer string, value *yamlv3.Node) error {
+	if pointer == "" {
+		doc.Content[0] = value
+		return nil
+	}
+	parent, token, err := yamlParent(doc, pointer)
+	if err != nil {
+		return err
+	}
+	if parent.Kind == yamlv3.MappingNode {
+		setYAMLField(parent, token, value)
+		return nil
+	}
+	index, err := sequenceIndex(parent, token, true)
+	if err != nil {
+		return err
+	}
+	parent.Content = append(parent.Content[:index], append([]*yamlv3.Node{value}, parent.Content[index:]...)...)
+	return nil
+}
+
+// yamlRemove removes the node a JSON pointer refers to and returns it.
+func yamlRemove(doc *yamlv3.Node, pointer string) (*yamlv3.Node, error) {
+	parent, token, err := yamlParent(doc, pointer)
+	if err != nil {
+		return nil, err
+	}
+	value, err := yamlChild(parent, token)
+	if err != nil {
+		return nil, err
+	}
+	if parent.Kind == yamlv3.MappingNode {
+		removeYAMLField(parent, token)
+		return value, nil
+	}
+	index, _ := sequenceIndex(parent, token, false)
+	parent.Content = append(parent.Content[:index], parent.Content[index+1:]...)
+	return value, nil
+}
+
+func yamlReplace(doc *yamlv3.Node, pointer string, value *yamlv3.Node) error {
+	if pointer == "" {
+		doc.Content[0] = value
+		return nil
+	}
+	parent, token, err := yamlParent(doc, pointer)
+	if err != nil {
+		return err
+	}
+	old, err := yamlChild(parent, token)
+	if err != nil {
+		return err
+	}
+	if parent.Kind == yamlv3.MappingNode {
+		setYAMLField(parent, token, value)
+		return nil
+	}
+	index, _ := sequenceIndex(parent, token, false)
+	keepComments(old, value)
+	parent.Content[index] = value
+	return nil
+}
+
+// yamlTest compares the node a JSON pointer refers to with value, as JSON
+// values.
+func yamlTest(doc *yamlv3.Node, pointer string, value *yamlv3.Node) error {
+	node, err := yamlPointer(doc, pointer)
+	if err != nil {
+		return err
+	}
+	var actual, expected interface{}
+	if err := node.Decode(&actual); err != nil {
+		return err
+	}
+	if err := value.Decode(&expected); err != nil {
+		return err
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		return errors.New("the value differs")
+	}
+	return nil
+}
+
+func copyNode(node *yamlv3.Node) *yamlv3.Node {
+	c := *node
+	c.Content = make([]*yamlv3.Node, len(node.Content))
+	for i, child := range node.Content {
+		c.Content[i] = copyNode(child)
+	}
+	return &c
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+// testValues is written as yaml.v3 writes it, which drops blank lines and
+// doesn't indent sequences in mappings.
+const testValues = `# Default values for mychart.
+replicaCount: 1 # scaled by the HPA
+image:
+  # the image to run
+  repository: nginx
+  tag: "1.21"
+ports:
+- 80
+- 443
+`
+
+func patchTestValues(t *testing.T, patchType string, patch string) (string, error) {
+	t.Helper()
+	tempWorkspaces(t)
+	if err := chartWorkspace.writeFile([]byte(testValues), "mychart", "values.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest(http.MethodPatch, "/helm/chart/mychart/values/values.yaml", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := patchValues(req, "mychart", "values.yaml", patchType, []byte(patch))
+	return string(content), err
+}
+
+func TestJSONPatchValues(t *testing.T) {
+	tests := []struct {
+		name  string
+		patch string
+		want  string
+	}{
+		{"replace", `[{"op": "replace", "path": "/replicaCount", "value": 3}]`, `# Default values for mychart.
+replicaCount: 3 # scaled by the HPA
+image:
+  # the image to run
+  repository: nginx
+  tag: "1.21"
+ports:
+- 80
+- 443
+`},
+		{"add and remove", `[{"op": "add", "path": "/image/pullPolicy", "value": "Always"}, {"op": "remove", "path": "/image/tag"}]`, `# Default values for mychart.
+replicaCount: 1 # scaled by the HPA
+image:
+  # the image to run
+  repository: nginx
+  pullPolicy: Always
+ports:
+- 80
+- 443
+`},
+		{"sequence", `[{"op": "add", "path": "/ports/-", "value": 8080}, {"op": "remove", "path": "/ports/0"}]`, `# Default values for mychart.
+replicaCount: 1 # scaled by the HPA
+image:
+  # the image to run
+  repository: nginx
+  tag: "1.21"
+ports:
+- 443
+- 8080
+`},
+		{"move and copy", `[{"op": "test", "path": "/image/repository", "value": "nginx"}, {"op": "copy", "from": "/image/tag", "path": "/appVersion"}, {"op": "move", "from": "/ports", "path": "/image/ports"}]`, `# Default values for mychart.
+replicaCount: 1 # scaled by the HPA
+image:
+  # the image to run
+  repository: nginx
+  tag: "1.21"
+  ports:
+  - 80
+  - 443
+appVersion: "1.21"
+`},
+		{"escaped pointer", `[{"op": "add", "path": "/podAnnotations", "value": {"a/b~c": "x"}}, {"op": "replace", "path": "/podAnnotations/a~1b~0c", "value": "z"}]`, `# Default values for mychart.
+replicaCount: 1 # scaled by the HPA
+image:
+  # the image to run
+  repository: nginx
+  tag: "1.21"
+ports:
+- 80
+- 443
+podAnnotations:
+  a/b~c: z
+`},
+	}
+	for _, tt := range tests {
+		got, err := patchTestValues(t, jsonPatchType, tt.patch)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s:\n%s\nwant:\n%s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestJSONPatchValuesFails(t *testing.T) {
+	for _, patch := range []string{
+		`[{"op": "test", "path": "/replicaCount", "value": 2}]`,
+		`[{"op": "replace", "path": "/missing", "value": 2}]`,
+		`[{"op": "remove", "path": "/ports/5"}]`,
+		`[{"op": "add", "path": "/image/ports/0"}]`,
+		`[{"op": "move", "from": "/image", "path": "/image/inner"}]`,
+		`[{"op": "replace", "path": "", "value": [1, 2]}]`,
+		`[{"op": "frobnicate", "path": "/a"}]`,
+		`{"op": "add"}`,
+	} {
+		if _, err := patchTestValues(t, jsonPatchType, patch); !errors.Is(err, errInvalidPatch) {
+			t.Errorf("%s = %v, want errInvalidPatch", patch, err)
+		}
+		// a failing patch leaves the file as it was
+		if content, err := chartWorkspace.readFile("mychart", "values.yaml"); err != nil || string(content) != testValues {
+			t.Errorf("%s changed the file: %v", patch, err)
+		}
+	}
+}
+
+func TestMergePatchValues(t *testing.T) {
+	got, err := patchTestValues(t, mergePatchType, `{"replicaCount": 2, "image": {"tag": null, "pullPolicy": "IfNotPresent"}, "ports": [8080], "resources": {"limits": {"cpu": "100m"}}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `# Default values for mychart.
+replicaCount: 2 # scaled by the HPA
+image:
+  # the image to run
+  repository: nginx
+  pullPolicy: IfNotPresent
+ports:
+- 8080
+resources:
+  limits:
+    cpu: 100m
+`
+	if got != want {
+		t.Errorf("merge patch:\n%s\nwant:\n%s", got, want)
+	}
+
+	if _, err := patchTestValues(t, mergePatchType, `[1, 2]`); !errors.Is(err, errInvalidPatch) {
+		t.Errorf("merge patch replacing the values by a list = %v, want errInvalidPatch", err)
+	}
+	if _, err := patchTestValues(t, mergePatchType, ``); !errors.Is(err, errInvalidPatch) {
+		t.Errorf("empty merge patch = %v, want errInvalidPatch", err)
+	}
+}
+
+func TestPatchValuesFile(t *testing.T) {
+	tempWorkspaces(t)
+	req, _ := http.NewRequest(http.MethodPatch, "/", nil)
+	if _, err := patchValues(req, "mychart", "templates/deployment.yaml", mergePatchType, []byte(`{}`)); !errors.Is(err, errInvalidPath) {
+		t.Errorf("patch of a template = %v, want errInvalidPath", err)
+	}
+	if _, err := patchValues(req, "mychart", "../values.yaml", mergePatchType, []byte(`{}`)); err == nil {
+		t.Error("patch outside of the chart succeeded")
+	}
+	if _, err := patchValues(req, "mychart", "values.yaml", "text/plain", []byte(`{}`)); err == nil {
+		t.Error("patch of an unknown type succeeded")
+	}
+}
@@ -48,20 +48,38 @@ destination exists and there are files in that directory, conflicting files
 will be overwritten, but other files will be left alone.
 `
 
-func create(chartName string) error {
-	chartDir := ".helm/charts"
-	// Ensure the chart directory exists
-	err := os.MkdirAll(chartDir, os.ModePerm)
-	if err != nil && !os.IsExist(err) {
-		log.Println(err)
-		return err
+func create(chartName string, starter string) error {
+	if starter == "" {
+		starter = defaultStarter
 	}
-	o := &createOptions{}
-	o.name = fmt.Sprintf("%s/%s", chartDir, chartName)
-	o.starterDir = ".helm/starters"
-	o.starter = ""
-	out := os.Stdout
-	return o.run(out)
+	action := "create"
+	if starter != "" {
+		action = "create from starter " + starter
+	}
+	return changeChart(chartName, action, func() error {
+		chartPath, done, err := checkoutChart(chartName)
+		if err != nil {
+			log.Println(err)
+			return err
+		}
+		o := &createOptions{}
+		o.name = chartPath
+		if starter != "" {
+			dir, starterDone, err := checkoutStarter(starter)
+			if err != nil {
+				done(false)
+				return err
+			}
+			defer starterDone(false)
+			o.starterDir, o.starter = starterDir(dir)
+		}
+		out := os.Stdout
+		if err := o.run(out); err != nil {
+			done(false)
+			return err
+		}
+		return done(true)
+	})
 }
 
 type createOptions struct {
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// requireIfMatch makes edits and removals of chart files without a precondition fail.
+var requireIfMatch bool
+
+var (
+	errPreconditionFailed   = errors.New("the file was changed since it was read")
+	errPreconditionRequired = errors.New("an If-Match or If-None-Match header is required")
+)
+
+// contentETag is the strong entity tag of a file: the sha256 of its content.
+func contentETag(content []byte) string {
+	sum := sha256.Sum256(content)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+// checkPreconditions checks the If-Match and If-None-Match headers of a request
+// changing a file of the workspace against the current content of the file.
+// "If-None-Match: *" allows to create a file only if it doesn't exist yet.
+func checkPreconditions(req *http.Request, w *workspace, elem ...string) error {
+	ifMatch := req.Header.Get("If-Match")
+	ifNoneMatch := req.Header.Get("If-None-Match")
+	if ifMatch == "" && ifNoneMatch == "" {
+		if requireIfMatch {
+			return errPreconditionRequired
+		}
+		return nil
+	}
+	content, err := w.readFile(elem...)
+	exists := err == nil
+	if err != nil && !os.IsNotExist(errors.Cause(err)) {
+		return err
+	}
+	if ifMatch != "" && (!exists || !etagMatch(ifMatch, contentETag(content), false)) {
+		return errPreconditionFailed
+	}
+	if ifNoneMatch != "" && exists && etagMatch(ifNoneMatch, contentETag(content), true) {
+		return errPreconditionFailed
+	}
+	return nil
+}
+
+// etagMatch tells if the value of an If-Match or If-None-Match header matches
+// etag. If-Match compares strongly, a weak tag never matches; If-None-Match
+// compares weakly, ignoring the W/ prefix (RFC 7232, section 2.3.2).
+func etagMatch(header string, etag string, weak bool) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if weak {
+			tag = strings.TrimPrefix(tag, "W/")
+		}
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
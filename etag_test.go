@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestETagMatch(t *testing.T) {
+	etag := contentETag([]byte("replicaCount: 1\n"))
+	tests := []struct {
+		header string
+		weak   bool
+		want   bool
+	}{
+		{etag, false, true},
+		{etag, true, true},
+		{"*", false, true},
+		{`"other", ` + etag, false, true},
+		{"W/" + etag, false, false},
+		{"W/" + etag, true, true},
+		{`"other"`, false, false},
+		{`"other"`, true, false},
+		{etag[1 : len(etag)-1], false, false},
+	}
+	for _, tt := range tests {
+		if got := etagMatch(tt.header, etag, tt.weak); got != tt.want {
+			t.Errorf("etagMatch(%q, weak %v) = %v, want %v", tt.header, tt.weak, got, tt.want)
+		}
+	}
+}
+
+func TestCheckPreconditions(t *testing.T) {
+	tempWorkspaces(t)
+	defer func(required bool) { requireIfMatch = required }(requireIfMatch)
+	content := []byte("replicaCount: 1\n")
+	if err := chartWorkspace.writeFile(content, "mychart", "values.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	etag := contentETag(content)
+	stale := contentETag([]byte("replicaCount: 2\n"))
+
+	tests := []struct {
+		file        string
+		ifMatch     string
+		ifNoneMatch string
+		required    bool
+		err         error
+	}{
+		{"values.yaml", "", "", false, nil},
+		{"values.yaml", "", "", true, errPreconditionRequired},
+		{"values.yaml", etag, "", true, nil},
+		{"values.yaml", "*", "", false, nil},
+		{"values.yaml", stale, "", false, errPreconditionFailed},
+		{"values.yaml", "W/" + etag, "", false, errPreconditionFailed},
+		{"new.yaml", "*", "", false, errPreconditionFailed},
+		{"new.yaml", etag, "", false, errPreconditionFailed},
+		{"new.yaml", "", "*", true, nil},
+		{"values.yaml", "", "*", false, errPreconditionFailed},
+		{"values.yaml", "", "W/" + etag, false, errPreconditionFailed},
+		{"values.yaml", "", stale, false, nil},
+	}
+	for _, tt := range tests {
+		requireIfMatch = tt.required
+		req, err := http.NewRequest(http.MethodPut, "/helm/chart/mychart/file/"+tt.file, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.ifMatch != "" {
+			req.Header.Set("If-Match", tt.ifMatch)
+		}
+		if tt.ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", tt.ifNoneMatch)
+		}
+		err = checkPreconditions(req, chartWorkspace, "mychart", tt.file)
+		if tt.err == nil && err != nil || tt.err != nil && !errors.Is(err, tt.err) {
+			t.Errorf("%s If-Match %q If-None-Match %q: %v, want %v", tt.file, tt.ifMatch, tt.ifNoneMatch, err, tt.err)
+		}
+	}
+}
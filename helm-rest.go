@@ -1,15 +1,16 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"mime"
 	"net/http"
 	"os"
 	"os/signal"
-	"path/filepath"
 	"strconv"
 	"strings"
 	"syscall"
@@ -22,6 +23,7 @@ import (
 	"github.com/spf13/pflag"
 	"helm.sh/helm/v3/cmd/helm/search"
 	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chart"
 	"helm.sh/helm/v3/pkg/cli"
 	"helm.sh/helm/v3/pkg/release"
 	"helm.sh/helm/v3/pkg/repo"
@@ -36,6 +38,7 @@ var (
 	settingsGlobal *cli.EnvSettings
 	server         *http.Server
 	container      *restful.Container
+	idempotency    *idempotencyStore
 )
 
 func init() {
@@ -43,14 +46,34 @@ func init() {
 	settingsGlobal = cli.New()
 
 	var listenPort string
+	var idempotencyTTL time.Duration
+	storage := &workspaceStorageOptions{}
 	pflag.CommandLine.StringVar(&listenPort, "port", "8080", "server listen port")
+	pflag.CommandLine.DurationVar(&idempotencyTTL, "idempotency-ttl", 24*time.Hour, "how long the outcome of a request with an Idempotency-Key is kept for replay; outcomes are kept in memory, by each replica on its own")
 	pflag.CommandLine.StringVar(&settingsGlobal.KubeConfig, "kubeconfig", "config/kubeconfig", "path to the kubeconfig file")
 	pflag.CommandLine.StringVar(&settingsGlobal.RepositoryConfig, "repository-config", ".helm/repository/repositories.yaml", "path to the file containing repository names and URLs")
 	pflag.CommandLine.StringVar(&settingsGlobal.RepositoryCache, "repository-cache", ".helm/repository/cache", "path to the file containing cached repository indexes")
+	pflag.CommandLine.StringVar(&defaultStarter, "default-starter", "", "starter new charts are created from when none is given, helm's default chart when empty")
+	pflag.CommandLine.Int64Var(&maxUploadSize, "max-upload-size", maxUploadSize, "maximum size in bytes of uploaded chart archives")
+	pflag.CommandLine.StringVar(&keyring, "keyring", "", "secret keyring packages are signed with")
+	pflag.CommandLine.StringVar(&signingKey, "signing-key", "", "name of the key in the keyring packages are signed with when the request names none")
+	pflag.CommandLine.StringVar(&passphraseFile, "passphrase-file", "", "file holding the passphrase of the signing key")
+	pflag.CommandLine.BoolVar(&requireIfMatch, "require-if-match", false, "reject edits and removals of chart files without an If-Match or If-None-Match header")
+	pflag.CommandLine.StringVar(&storage.kind, "workspace-storage", "local", "where the charts and packages of the workspace are kept, local or s3")
+	pflag.CommandLine.StringVar(&storage.s3Bucket, "s3-bucket", "", "bucket of the s3 workspace storage")
+	pflag.CommandLine.StringVar(&storage.s3Prefix, "s3-prefix", "", "prefix of the object keys in the s3 workspace storage")
+	pflag.CommandLine.StringVar(&storage.s3Endpoint, "s3-endpoint", "", "endpoint of a s3 compatible object store, e.g. http://localhost:9000 for minio")
+	pflag.CommandLine.StringVar(&storage.s3Region, "s3-region", "", "region of the s3 bucket, AWS_REGION when empty")
+	pflag.CommandLine.BoolVar(&storage.s3PathStyle, "s3-path-style", false, "use path style urls, needed by most s3 compatible object stores")
 	pflag.Parse()
 
+	if err := setupWorkspaces(storage); err != nil {
+		log.Fatalln(err)
+	}
+
 	container = restful.NewContainer()
 	server = &http.Server{Addr: fmt.Sprintf(":%s", listenPort), Handler: container}
+	idempotency = newIdempotencyStore(idempotencyTTL)
 
 	HelmResource{}.Register()
 
@@ -67,11 +90,13 @@ func init() {
 
 	// Optionally, you may need to enable CORS for the UI to work.
 	cors := restful.CrossOriginResourceSharing{
-		AllowedHeaders: []string{"Content-Type", "Accept"},
-		AllowedMethods: []string{"GET", "POST", "PUT", "DELETE"},
+		AllowedHeaders: []string{"Content-Type", "Accept", idempotencyKeyHeader, "If-Match", "If-None-Match"},
+		ExposeHeaders:  []string{"ETag"},
+		AllowedMethods: []string{"GET", "POST", "PUT", "PATCH", "DELETE"},
 		CookiesAllowed: false,
 		Container:      restful.DefaultContainer}
 	container.Filter(cors.Filter)
+	container.Filter(idempotency.filter)
 }
 
 func (h HelmResource) listRepo(req *restful.Request, resp *restful.Response) {
@@ -91,6 +116,9 @@ func (h HelmResource) addRepo(req *restful.Request, resp *restful.Response) {
 	repoinfo := repo.Entry{}
 	req.ReadEntity(&repoinfo)
 	err := addRepo(&repoinfo)
+	if err == nil && req.QueryParameter("verified-only") == "true" {
+		err = setVerifiedOnly(repoinfo.Name, true)
+	}
 	if err != nil {
 		log.Println(err)
 		result := &Result{}
@@ -139,6 +167,41 @@ func (h HelmResource) removeRepo(req *restful.Request, resp *restful.Response) {
 	resp.WriteHeaderAndEntity(http.StatusOK, result)
 }
 
+func (h HelmResource) verifiedOnlyRepos(req *restful.Request, resp *restful.Response) {
+	names, err := verifiedOnlyRepos()
+	if err != nil {
+		log.Println(err)
+		result := &Result{}
+		result.Result = false
+		result.Error = err.Error()
+		resp.WriteHeaderAndEntity(http.StatusInternalServerError, result)
+		return
+	}
+	resp.WriteHeaderAndEntity(http.StatusOK, names)
+}
+
+func (h HelmResource) setVerifiedOnly(req *restful.Request, resp *restful.Response) {
+	name := req.PathParameter("repo-name")
+	verifiedOnly := req.Request.Method == http.MethodPut
+	err := setVerifiedOnly(name, verifiedOnly)
+	if err != nil {
+		log.Println(err)
+		result := &Result{}
+		result.Result = false
+		result.Error = err.Error()
+		resp.WriteHeaderAndEntity(workspaceStatus(err), result)
+		return
+	}
+	result := &Result{}
+	result.Result = true
+	if verifiedOnly {
+		result.Message = fmt.Sprintf("charts of %s are only installed verified", name)
+	} else {
+		result.Message = fmt.Sprintf("charts of %s are installed without verification", name)
+	}
+	resp.WriteHeaderAndEntity(http.StatusOK, result)
+}
+
 func (h HelmResource) searchRepo(req *restful.Request, resp *restful.Response) {
 	keyword := req.QueryParameter("keyword")
 	charts, err := searchRepo(keyword)
@@ -154,8 +217,35 @@ func (h HelmResource) searchRepo(req *restful.Request, resp *restful.Response) {
 }
 
 func (h HelmResource) list(req *restful.Request, resp *restful.Response) {
-	namespace := req.QueryParameter("namespace")
-	releases, err := list(namespace)
+	o := &listOptions{}
+	o.namespace = req.QueryParameter("namespace")
+	o.filter = req.QueryParameter("filter")
+	o.selector = req.QueryParameter("selector")
+	o.sortBy = req.QueryParameter("sort")
+	o.reverse = req.QueryParameter("reverse") == "true"
+	o.full = req.QueryParameter("full") == "true"
+	if status := req.QueryParameter("status"); status != "" {
+		o.statuses = strings.Split(status, ",")
+	}
+	var errParse error
+	if limit := req.QueryParameter("limit"); limit != "" {
+		o.limit, errParse = parseCount("limit", limit)
+	}
+	if offset := req.QueryParameter("offset"); offset != "" && errParse == nil {
+		o.offset, errParse = parseCount("offset", offset)
+	}
+	if token := req.QueryParameter("continue"); token != "" && errParse == nil {
+		o.offset, errParse = decodeContinue(token)
+	}
+	if errParse != nil {
+		log.Println(errParse)
+		result := &Result{}
+		result.Result = false
+		result.Error = errParse.Error()
+		resp.WriteHeaderAndEntity(http.StatusBadRequest, result)
+		return
+	}
+	releases, err := list(o)
 	if err != nil {
 		log.Println(err)
 		result := &Result{}
@@ -196,6 +286,7 @@ func (h HelmResource) install(req *restful.Request, resp *restful.Response) {
 	}
 	log.Println(string(jsonInfo))
 	release, err := install(&releaseInfo)
+	err = metadataWarning(resp, release, err)
 	if err != nil {
 		log.Println(err)
 		result := &Result{}
@@ -221,6 +312,7 @@ func (h HelmResource) upgrade(req *restful.Request, resp *restful.Response) {
 	}
 	log.Println(string(jsonInfo))
 	release, err := upgrade(&releaseInfo)
+	err = metadataWarning(resp, release, err)
 	if err != nil {
 		log.Println(err)
 		result := &Result{}
@@ -232,10 +324,40 @@ func (h HelmResource) upgrade(req *restful.Request, resp *restful.Response) {
 	resp.WriteHeaderAndEntity(http.StatusOK, release)
 }
 
+// metadataWarning answers a release deployed without its labels and
+// annotations with a Warning header instead of an error, see
+// errReleaseMetadataNotStored. It returns the errors that remain.
+func metadataWarning(resp *restful.Response, rel *release.Release, err error) error {
+	if err == nil || rel == nil || !errors.Is(err, errReleaseMetadataNotStored) {
+		return err
+	}
+	log.Println(err)
+	resp.AddHeader("Warning", "199 helm-rest "+strconv.Quote(err.Error()))
+	return nil
+}
+
 func (h HelmResource) uninstall(req *restful.Request, resp *restful.Response) {
 	releases := strings.Split(req.QueryParameter("releases"), ",")
-	namespace := req.QueryParameter("namespace")
-	err := uninstall(releases, namespace)
+	o := &uninstallOptions{}
+	o.namespace = req.QueryParameter("namespace")
+	o.keepHistory = req.QueryParameter("keep-history") == "true"
+	o.dryRun = req.QueryParameter("dry-run") == "true"
+	o.disableHooks = req.QueryParameter("disable-hooks") == "true"
+	o.wait = req.QueryParameter("wait") == "true"
+	o.timeout = 300 * time.Second
+	if timeout := req.QueryParameter("timeout"); timeout != "" {
+		var errParse error
+		o.timeout, errParse = time.ParseDuration(timeout)
+		if errParse != nil {
+			log.Println(errParse)
+			result := &Result{}
+			result.Result = false
+			result.Error = errParse.Error()
+			resp.WriteHeaderAndEntity(http.StatusBadRequest, result)
+			return
+		}
+	}
+	results, err := uninstall(releases, o)
 	if err != nil {
 		log.Println(err)
 		result := &Result{}
@@ -244,10 +366,7 @@ func (h HelmResource) uninstall(req *restful.Request, resp *restful.Response) {
 		resp.WriteHeaderAndEntity(http.StatusInternalServerError, result)
 		return
 	}
-	result := &Result{}
-	result.Result = true
-	result.Message = "uninstalled"
-	resp.WriteHeaderAndEntity(http.StatusOK, result)
+	resp.WriteHeaderAndEntity(http.StatusOK, results)
 }
 
 func (h HelmResource) history(req *restful.Request, resp *restful.Response) {
@@ -275,9 +394,17 @@ func (h HelmResource) history(req *restful.Request, resp *restful.Response) {
 }
 
 func (h HelmResource) rollback(req *restful.Request, resp *restful.Response) {
-	releaseInfo := ReleaseInfo{}
-	req.ReadEntity(&releaseInfo)
-	err := rollback(&releaseInfo)
+	rollbackInfo := RollbackInfo{}
+	req.ReadEntity(&rollbackInfo)
+	if rollbackInfo.LastDeployed && rollbackInfo.Version != 0 {
+		result := &Result{}
+		result.Result = false
+		result.Error = "last_deployed and version can't be given together"
+		resp.WriteHeaderAndEntity(http.StatusBadRequest, result)
+		return
+	}
+	release, err := rollback(&rollbackInfo)
+	err = metadataWarning(resp, release, err)
 	if err != nil {
 		log.Println(err)
 		result := &Result{}
@@ -286,15 +413,13 @@ func (h HelmResource) rollback(req *restful.Request, resp *restful.Response) {
 		resp.WriteHeaderAndEntity(http.StatusInternalServerError, result)
 		return
 	}
-	result := &Result{}
-	result.Result = true
-	result.Message = "Rollback was a success! Happy Helming!"
-	resp.WriteHeaderAndEntity(http.StatusOK, result)
+	resp.WriteHeaderAndEntity(http.StatusOK, release)
 }
 
-func (h HelmResource) create(req *restful.Request, resp *restful.Response) {
-	chartName := req.PathParameter("chart-name")
-	err := create(chartName)
+func (h HelmResource) releaseTest(req *restful.Request, resp *restful.Response) {
+	testInfo := TestInfo{}
+	req.ReadEntity(&testInfo)
+	run, err := releaseTest(&testInfo)
 	if err != nil {
 		log.Println(err)
 		result := &Result{}
@@ -303,15 +428,13 @@ func (h HelmResource) create(req *restful.Request, resp *restful.Response) {
 		resp.WriteHeaderAndEntity(http.StatusInternalServerError, result)
 		return
 	}
-	result := &Result{}
-	result.Result = true
-	result.Message = "chart created successfully"
-	resp.WriteHeaderAndEntity(http.StatusOK, result)
+	resp.WriteHeaderAndEntity(http.StatusOK, run)
 }
 
-func (h HelmResource) packageChart(req *restful.Request, resp *restful.Response) {
-	chartName := req.PathParameter("chart-name")
-	err := packageChart(chartName)
+func (h HelmResource) lastTestRun(req *restful.Request, resp *restful.Response) {
+	releaseName := req.QueryParameter("release-name")
+	namespace := req.QueryParameter("namespace")
+	run, err := lastTestRun(releaseName, namespace)
 	if err != nil {
 		log.Println(err)
 		result := &Result{}
@@ -320,71 +443,122 @@ func (h HelmResource) packageChart(req *restful.Request, resp *restful.Response)
 		resp.WriteHeaderAndEntity(http.StatusInternalServerError, result)
 		return
 	}
-	result := &Result{}
-	result.Result = true
-	result.Message = "chart packaged successfully"
-	resp.WriteHeaderAndEntity(http.StatusOK, result)
+	resp.WriteHeaderAndEntity(http.StatusOK, run)
 }
 
-func (h HelmResource) upload(req *restful.Request, resp *restful.Response) {
-	repoName := req.PathParameter("repo-name")
+func (h HelmResource) template(req *restful.Request, resp *restful.Response) {
+	templateInfo := TemplateInfo{}
+	req.ReadEntity(&templateInfo)
+	manifests, err := template(&templateInfo)
+	if err != nil {
+		log.Println(err)
+		result := &Result{}
+		result.Result = false
+		result.Error = err.Error()
+		resp.WriteHeaderAndEntity(workspaceStatus(err), result)
+		return
+	}
+	resp.Header().Set("Content-Type", "application/x-yaml")
+	resp.WriteHeader(http.StatusOK)
+	resp.Write([]byte(manifests))
+}
+
+func (h HelmResource) lint(req *restful.Request, resp *restful.Response) {
 	chartName := req.PathParameter("chart-name")
-	chartPackage := req.PathParameter("chart-package-name")
-	message, err := upload(chartPackage, chartName, repoName)
+	lintInfo := LintInfo{}
+	req.ReadEntity(&lintInfo)
+	report, err := lint(chartName, &lintInfo)
 	if err != nil {
 		log.Println(err)
 		result := &Result{}
 		result.Result = false
 		result.Error = err.Error()
-		resp.WriteHeaderAndEntity(http.StatusInternalServerError, result)
+		resp.WriteHeaderAndEntity(workspaceStatus(err), result)
+		return
+	}
+	resp.WriteHeaderAndEntity(http.StatusOK, report)
+}
+
+func (h HelmResource) create(req *restful.Request, resp *restful.Response) {
+	chartName := req.PathParameter("chart-name")
+	err := create(chartName, req.QueryParameter("starter"))
+	if err != nil {
+		log.Println(err)
+		result := &Result{}
+		result.Result = false
+		result.Error = err.Error()
+		resp.WriteHeaderAndEntity(workspaceStatus(err), result)
 		return
 	}
 	result := &Result{}
 	result.Result = true
-	result.Message = message
+	result.Message = "chart created successfully"
 	resp.WriteHeaderAndEntity(http.StatusOK, result)
 }
 
-func (h HelmResource) getChartFile(req *restful.Request, resp *restful.Response) {
-	chartName := req.PathParameter("chart-name")
-	filePath := req.PathParameter("file-path")
-	chartDir := ".helm/charts"
-	// Ensure the chart directory exists
-	err := os.MkdirAll(chartDir, os.ModePerm)
-	if err != nil && !os.IsExist(err) {
+func (h HelmResource) importChart(req *restful.Request, resp *restful.Response) {
+	importInfo := ImportInfo{}
+	req.ReadEntity(&importInfo)
+	origin, err := importChart(&importInfo)
+	if err != nil {
 		log.Println(err)
 		result := &Result{}
 		result.Result = false
 		result.Error = err.Error()
-		resp.WriteHeaderAndEntity(http.StatusInternalServerError, result)
+		status := workspaceStatus(err)
+		if status == http.StatusNotFound {
+			// a missing chart of the repository is not a missing resource
+			status = http.StatusInternalServerError
+		}
+		resp.WriteHeaderAndEntity(status, result)
 		return
 	}
-	file := fmt.Sprintf("%s/%s/%s", chartDir, chartName, filePath)
-	http.ServeFile(
-		resp.ResponseWriter,
-		req.Request,
-		file)
+	resp.WriteHeaderAndEntity(http.StatusCreated, origin)
 }
 
-func (h HelmResource) editChartFile(req *restful.Request, resp *restful.Response) {
+func (h HelmResource) uploadArchive(req *restful.Request, resp *restful.Response) {
+	// read the body first, QueryParameter would parse a multipart form without size limit
+	data, err := readUpload(req, resp)
+	target := req.QueryParameter("target")
+	if err == nil && target != "" && target != "chart" && target != "package" {
+		result := &Result{}
+		result.Result = false
+		result.Error = fmt.Sprintf("unknown target %q", target)
+		resp.WriteHeaderAndEntity(http.StatusBadRequest, result)
+		return
+	}
+	var upload *archiveUpload
+	if err == nil {
+		upload, err = uploadArchive(data, target, req.QueryParameter("name"), req.QueryParameter("overwrite") == "true")
+	}
+	if err != nil {
+		log.Println(err)
+		result := &Result{}
+		result.Result = false
+		result.Error = err.Error()
+		resp.WriteHeaderAndEntity(workspaceStatus(err), result)
+		return
+	}
+	resp.WriteHeaderAndEntity(http.StatusCreated, upload)
+}
+
+func (h HelmResource) getChartOrigin(req *restful.Request, resp *restful.Response) {
 	chartName := req.PathParameter("chart-name")
-	filePath := req.PathParameter("file-path")
-	chartDir := ".helm/charts"
-	// Ensure the chart directory exists
-	err := os.MkdirAll(chartDir, os.ModePerm)
-	if err != nil && !os.IsExist(err) {
+	origin, err := getChartOrigin(chartName)
+	if err != nil {
 		log.Println(err)
 		result := &Result{}
 		result.Result = false
 		result.Error = err.Error()
-		resp.WriteHeaderAndEntity(http.StatusInternalServerError, result)
+		resp.WriteHeaderAndEntity(workspaceStatus(err), result)
 		return
 	}
-	file := fmt.Sprintf("%s/%s/%s", chartDir, chartName, filePath)
-	dir, _ := filepath.Split(file)
-	// Ensure the chart file path exists
-	err = os.MkdirAll(dir, os.ModePerm)
-	if err != nil && !os.IsExist(err) {
+	resp.WriteHeaderAndEntity(http.StatusOK, origin)
+}
+
+func (h HelmResource) listStarters(req *restful.Request, resp *restful.Response) {
+	starters, err := listStarters()
+	if err != nil {
 		log.Println(err)
 		result := &Result{}
 		result.Result = false
@@ -392,65 +566,84 @@ func (h HelmResource) editChartFile(req *restful.Request, resp *restful.Response
 		resp.WriteHeaderAndEntity(http.StatusInternalServerError, result)
 		return
 	}
-	content, err := ioutil.ReadAll(req.Request.Body)
+	resp.WriteHeaderAndEntity(http.StatusOK, starters)
+}
+
+func (h HelmResource) getStarterFiles(req *restful.Request, resp *restful.Response) {
+	starterName := req.PathParameter("starter-name")
+	files, err := starterFiles(starterName)
 	if err != nil {
+		log.Println(err)
 		result := &Result{}
 		result.Result = false
 		result.Error = err.Error()
-		resp.WriteHeaderAndEntity(http.StatusInternalServerError, result)
+		resp.WriteHeaderAndEntity(workspaceStatus(err), result)
 		return
 	}
-	err = ioutil.WriteFile(file, content, os.ModePerm)
-	if err != nil && !os.IsExist(err) {
+	resp.WriteHeaderAndEntity(http.StatusOK, files)
+}
+
+func (h HelmResource) uploadStarter(req *restful.Request, resp *restful.Response) {
+	starterName := req.PathParameter("starter-name")
+	// read the body first, QueryParameter would parse a multipart form without size limit
+	data, err := readUpload(req, resp)
+	if err == nil {
+		err = uploadStarter(starterName, data, req.QueryParameter("overwrite") == "true")
+	}
+	if err != nil {
 		log.Println(err)
 		result := &Result{}
 		result.Result = false
 		result.Error = err.Error()
-		resp.WriteHeaderAndEntity(http.StatusInternalServerError, result)
+		resp.WriteHeaderAndEntity(workspaceStatus(err), result)
 		return
 	}
 	result := &Result{}
 	result.Result = true
-	result.Message = "update successfully"
-	resp.WriteHeaderAndEntity(http.StatusOK, result)
+	result.Message = "starter uploaded successfully"
+	resp.WriteHeaderAndEntity(http.StatusCreated, result)
 }
 
-func (h HelmResource) removeChartFile(req *restful.Request, resp *restful.Response) {
-	chartName := req.PathParameter("chart-name")
-	filePath := req.PathParameter("file-path")
-	chartDir := ".helm/charts"
-	// Ensure the chart directory exists
-	err := os.MkdirAll(chartDir, os.ModePerm)
-	if err != nil && !os.IsExist(err) {
+func (h HelmResource) promoteChart(req *restful.Request, resp *restful.Response) {
+	starterName := req.PathParameter("starter-name")
+	chartName := req.QueryParameter("chart")
+	placeholder := req.QueryParameter("placeholder") == "true"
+	overwrite := req.QueryParameter("overwrite") == "true"
+	err := promoteChart(chartName, starterName, placeholder, overwrite)
+	if err != nil {
 		log.Println(err)
 		result := &Result{}
 		result.Result = false
 		result.Error = err.Error()
-		resp.WriteHeaderAndEntity(http.StatusInternalServerError, result)
+		resp.WriteHeaderAndEntity(workspaceStatus(err), result)
 		return
 	}
-	file := fmt.Sprintf("%s/%s/%s", chartDir, chartName, filePath)
-	err = os.Remove(file)
-	if err != nil && !os.IsExist(err) {
+	result := &Result{}
+	result.Result = true
+	result.Message = fmt.Sprintf("chart %s promoted to starter %s", chartName, starterName)
+	resp.WriteHeaderAndEntity(http.StatusCreated, result)
+}
+
+func (h HelmResource) removeStarter(req *restful.Request, resp *restful.Response) {
+	starterName := req.PathParameter("starter-name")
+	err := removeStarter(starterName)
+	if err != nil {
 		log.Println(err)
 		result := &Result{}
 		result.Result = false
 		result.Error = err.Error()
-		resp.WriteHeaderAndEntity(http.StatusInternalServerError, result)
+		resp.WriteHeaderAndEntity(workspaceStatus(err), result)
 		return
 	}
 	result := &Result{}
 	result.Result = true
-	result.Message = "remove successfully"
+	result.Message = "remove starter successfully"
 	resp.WriteHeaderAndEntity(http.StatusOK, result)
 }
 
-func (h HelmResource) getChartFiles(req *restful.Request, resp *restful.Response) {
-	chartName := req.PathParameter("chart-name")
-	chartDir := ".helm/charts"
-	// Ensure the chart directory exists
-	err := os.MkdirAll(chartDir, os.ModePerm)
-	if err != nil && !os.IsExist(err) {
+func (h HelmResource) listTrustedKeys(req *restful.Request, resp *restful.Response) {
+	keys, err := listTrustedKeys()
+	if err != nil {
 		log.Println(err)
 		result := &Result{}
 		result.Result = false
@@ -458,75 +651,80 @@ func (h HelmResource) getChartFiles(req *restful.Request, resp *restful.Response
 		resp.WriteHeaderAndEntity(http.StatusInternalServerError, result)
 		return
 	}
-	file := fmt.Sprintf("%s/%s", chartDir, chartName)
-	files := []string{}
-	err = filepath.Walk(file, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		// filepath.SplitList(path)[2:]
-		subPath, err := filepath.Rel(chartDir, path)
-		if err != nil {
-			return err
-		}
-		files = append(files, subPath)
-		return nil
-	})
-	if err != nil && !os.IsExist(err) {
+	resp.WriteHeaderAndEntity(http.StatusOK, keys)
+}
+
+func (h HelmResource) addTrustedKeys(req *restful.Request, resp *restful.Response) {
+	data, err := readUpload(req, resp)
+	var keys []*trustedKey
+	if err == nil {
+		keys, err = addTrustedKeys(data)
+	}
+	if err != nil {
 		log.Println(err)
 		result := &Result{}
 		result.Result = false
 		result.Error = err.Error()
-		resp.WriteHeaderAndEntity(http.StatusInternalServerError, result)
+		resp.WriteHeaderAndEntity(workspaceStatus(err), result)
 		return
 	}
-	resp.WriteHeaderAndEntity(http.StatusOK, files)
+	resp.WriteHeaderAndEntity(http.StatusCreated, keys)
 }
 
-func (h HelmResource) chartList(req *restful.Request, resp *restful.Response) {
-	chartDir := ".helm/charts"
-	// Ensure the chart directory exists
-	err := os.MkdirAll(chartDir, os.ModePerm)
-	if err != nil && !os.IsExist(err) {
+func (h HelmResource) removeTrustedKey(req *restful.Request, resp *restful.Response) {
+	fingerprint := req.PathParameter("fingerprint")
+	err := removeTrustedKey(fingerprint)
+	if err != nil {
 		log.Println(err)
 		result := &Result{}
 		result.Result = false
 		result.Error = err.Error()
-		resp.WriteHeaderAndEntity(http.StatusInternalServerError, result)
+		resp.WriteHeaderAndEntity(workspaceStatus(err), result)
 		return
 	}
-	fileNames := []string{}
-	files, err := os.ReadDir(chartDir)
+	result := &Result{}
+	result.Result = true
+	result.Message = "remove key successfully"
+	resp.WriteHeaderAndEntity(http.StatusOK, result)
+}
+
+func (h HelmResource) verifyPackage(req *restful.Request, resp *restful.Response) {
+	verifyInfo := VerifyInfo{}
+	req.ReadEntity(&verifyInfo)
+	v, err := verifyPackage(&verifyInfo)
 	if err != nil {
 		log.Println(err)
 		result := &Result{}
 		result.Result = false
 		result.Error = err.Error()
-		resp.WriteHeaderAndEntity(http.StatusInternalServerError, result)
+		resp.WriteHeaderAndEntity(workspaceStatus(err), result)
 		return
 	}
-
-	for _, file := range files {
-		fileNames = append(fileNames, file.Name())
-	}
-	resp.WriteHeaderAndEntity(http.StatusOK, fileNames)
+	resp.WriteHeaderAndEntity(http.StatusOK, v)
 }
 
-func (h HelmResource) removeChart(req *restful.Request, resp *restful.Response) {
-	chartDir := ".helm/charts"
-	// Ensure the chart directory exists
-	err := os.MkdirAll(chartDir, os.ModePerm)
-	if err != nil && !os.IsExist(err) {
+func (h HelmResource) packageChart(req *restful.Request, resp *restful.Response) {
+	chartName := req.PathParameter("chart-name")
+	packageInfo := PackageInfo{}
+	req.ReadEntity(&packageInfo)
+	packaged, err := packageChart(chartName, &packageInfo)
+	if err != nil {
 		log.Println(err)
 		result := &Result{}
 		result.Result = false
 		result.Error = err.Error()
-		resp.WriteHeaderAndEntity(http.StatusInternalServerError, result)
+		resp.WriteHeaderAndEntity(workspaceStatus(err), result)
 		return
 	}
+	resp.WriteHeaderAndEntity(http.StatusOK, packaged)
+}
+
+func (h HelmResource) upload(req *restful.Request, resp *restful.Response) {
+	repoName := req.PathParameter("repo-name")
 	chartName := req.PathParameter("chart-name")
-	err = os.RemoveAll(fmt.Sprintf("%s/%s", chartDir, chartName))
-	if err != nil && !os.IsExist(err) {
+	chartPackage := req.PathParameter("chart-package-name")
+	message, err := upload(chartPackage, chartName, repoName)
+	if err != nil {
 		log.Println(err)
 		result := &Result{}
 		result.Result = false
@@ -536,85 +734,577 @@ func (h HelmResource) removeChart(req *restful.Request, resp *restful.Response)
 	}
 	result := &Result{}
 	result.Result = true
-	result.Message = "remove chart successfully"
+	result.Message = message
 	resp.WriteHeaderAndEntity(http.StatusOK, result)
 }
 
-func (h HelmResource) packageList(req *restful.Request, resp *restful.Response) {
-	chartPackgeDir := ".helm/chart-package"
-	// Ensure the chart package directory exists
-	err := os.MkdirAll(chartPackgeDir, os.ModePerm)
-	if err != nil && !os.IsExist(err) {
+func (h HelmResource) getChartFile(req *restful.Request, resp *restful.Response) {
+	chartName := req.PathParameter("chart-name")
+	filePath := req.PathParameter("file-path")
+	err := validName(chartName)
+	var info os.FileInfo
+	var content []byte
+	if err == nil {
+		info, err = chartWorkspace.stat(chartName, filePath)
+	}
+	if err == nil {
+		content, err = chartWorkspace.readFile(chartName, filePath)
+	}
+	if err != nil {
 		log.Println(err)
 		result := &Result{}
 		result.Result = false
 		result.Error = err.Error()
-		resp.WriteHeaderAndEntity(http.StatusInternalServerError, result)
+		resp.WriteHeaderAndEntity(workspaceStatus(err), result)
 		return
 	}
+	// ServeContent answers conditional requests against the ETag header
+	resp.Header().Set("ETag", contentETag(content))
+	http.ServeContent(
+		resp.ResponseWriter,
+		req.Request,
+		info.Name(),
+		info.ModTime(),
+		bytes.NewReader(content))
+}
+
+func (h HelmResource) editChartFile(req *restful.Request, resp *restful.Response) {
 	chartName := req.PathParameter("chart-name")
-	f, err := os.Open(fmt.Sprintf("%s/%s", chartPackgeDir, chartName))
-	defer f.Close()
+	filePath := req.PathParameter("file-path")
+	err := validName(chartName)
+	if err == nil {
+		_, err = cleanPath(filePath)
+	}
 	if err != nil {
 		log.Println(err)
 		result := &Result{}
 		result.Result = false
 		result.Error = err.Error()
-		resp.WriteHeaderAndEntity(http.StatusInternalServerError, result)
+		resp.WriteHeaderAndEntity(workspaceStatus(err), result)
 		return
 	}
-	files, err := f.Readdir(-1)
+	content, err := ioutil.ReadAll(req.Request.Body)
 	if err != nil {
-		log.Println(err)
 		result := &Result{}
 		result.Result = false
 		result.Error = err.Error()
 		resp.WriteHeaderAndEntity(http.StatusInternalServerError, result)
 		return
 	}
-	fileNames := []string{}
-	for _, file := range files {
-		fileNames = append(fileNames, file.Name())
+	result := &ValidationResult{}
+	switch mode := req.QueryParameter("validate"); mode {
+	case "", validateNone:
+	case validateWarn, validateReject:
+		result.Findings, err = validateChartFile(chartName, filePath, content)
+		if err != nil {
+			log.Println(err)
+			result.Result = false
+			result.Error = err.Error()
+			resp.WriteHeaderAndEntity(workspaceStatus(err), result)
+			return
+		}
+		if mode == validateReject && hasErrorFindings(result.Findings) {
+			result.Result = false
+			result.Error = "validation failed, file not saved"
+			resp.WriteHeaderAndEntity(http.StatusUnprocessableEntity, result)
+			return
+		}
+	default:
+		result.Result = false
+		result.Error = fmt.Sprintf("unknown validation mode %q", mode)
+		resp.WriteHeaderAndEntity(http.StatusBadRequest, result)
+		return
 	}
-	resp.WriteHeaderAndEntity(http.StatusOK, fileNames)
-}
-
-func (h HelmResource) removePackage(req *restful.Request, resp *restful.Response) {
-	chartPackgeDir := ".helm/chart-package"
-	// Ensure the chart package directory exists
-	err := os.MkdirAll(chartPackgeDir, os.ModePerm)
-	if err != nil && !os.IsExist(err) {
+	err = changeChart(chartName, "edit "+filePath, func() error {
+		if err := checkPreconditions(req.Request, chartWorkspace, chartName, filePath); err != nil {
+			return err
+		}
+		return chartWorkspace.writeFile(content, chartName, filePath)
+	})
+	if err != nil {
 		log.Println(err)
-		result := &Result{}
 		result.Result = false
 		result.Error = err.Error()
-		resp.WriteHeaderAndEntity(http.StatusInternalServerError, result)
+		resp.WriteHeaderAndEntity(workspaceStatus(err), result)
 		return
 	}
+	result.Result = true
+	result.Message = "update successfully"
+	resp.Header().Set("ETag", contentETag(content))
+	resp.WriteHeaderAndEntity(http.StatusOK, result)
+}
+
+func (h HelmResource) removeChartFile(req *restful.Request, resp *restful.Response) {
 	chartName := req.PathParameter("chart-name")
-	chartPackageName := req.PathParameter("chart-package-name")
-	err = os.Remove(fmt.Sprintf("%s/%s/%s", chartPackgeDir, chartName, chartPackageName))
-	if err != nil && !os.IsExist(err) {
+	filePath := req.PathParameter("file-path")
+	err := validName(chartName)
+	if err == nil {
+		err = changeChart(chartName, "remove "+filePath, func() error {
+			if err := checkPreconditions(req.Request, chartWorkspace, chartName, filePath); err != nil {
+				return err
+			}
+			return chartWorkspace.remove(chartName, filePath)
+		})
+	}
+	if err != nil {
 		log.Println(err)
 		result := &Result{}
 		result.Result = false
 		result.Error = err.Error()
-		resp.WriteHeaderAndEntity(http.StatusInternalServerError, result)
+		resp.WriteHeaderAndEntity(workspaceStatus(err), result)
 		return
 	}
 	result := &Result{}
 	result.Result = true
-	result.Message = "remove chart package successfully"
+	result.Message = "remove successfully"
 	resp.WriteHeaderAndEntity(http.StatusOK, result)
 }
 
-func (h HelmResource) Register() {
-	charttags := []string{"chart"}
-	releasetags := []string{"release"}
-	repotags := []string{"repo"}
-
-	ws := new(restful.WebService)
-	ws.Path("/helm")
+func (h HelmResource) getChartFiles(req *restful.Request, resp *restful.Response) {
+	chartName := req.PathParameter("chart-name")
+	view := req.QueryParameter("view")
+	if view != "" && view != "paths" && view != "list" && view != "tree" {
+		result := &Result{}
+		result.Result = false
+		result.Error = fmt.Sprintf("unknown view %q", view)
+		resp.WriteHeaderAndEntity(http.StatusBadRequest, result)
+		return
+	}
+	entries, etag, err := chartFiles(chartName)
+	if err != nil {
+		log.Println(err)
+		result := &Result{}
+		result.Result = false
+		result.Error = err.Error()
+		resp.WriteHeaderAndEntity(workspaceStatus(err), result)
+		return
+	}
+	resp.Header().Set("ETag", etag)
+	if match := req.HeaderParameter("If-None-Match"); match != "" && etagMatch(match, etag, true) {
+		resp.WriteHeader(http.StatusNotModified)
+		return
+	}
+	switch view {
+	case "list":
+		resp.WriteHeaderAndEntity(http.StatusOK, entries)
+	case "tree":
+		resp.WriteHeaderAndEntity(http.StatusOK, chartFileTree(chartName, entries))
+	default:
+		files := []string{chartName}
+		for _, entry := range entries {
+			files = append(files, chartName+"/"+entry.Path)
+		}
+		resp.WriteHeaderAndEntity(http.StatusOK, files)
+	}
+}
+
+func (h HelmResource) getChartMetadata(req *restful.Request, resp *restful.Response) {
+	chartName := req.PathParameter("chart-name")
+	md, etag, err := getChartMetadata(chartName)
+	if err != nil {
+		log.Println(err)
+		result := &Result{}
+		result.Result = false
+		result.Error = err.Error()
+		resp.WriteHeaderAndEntity(workspaceStatus(err), result)
+		return
+	}
+	resp.Header().Set("ETag", etag)
+	resp.WriteHeaderAndEntity(http.StatusOK, md)
+}
+
+func (h HelmResource) patchChartMetadata(req *restful.Request, resp *restful.Response) {
+	chartName := req.PathParameter("chart-name")
+	patch, err := ioutil.ReadAll(req.Request.Body)
+	if err != nil {
+		result := &Result{}
+		result.Result = false
+		result.Error = err.Error()
+		resp.WriteHeaderAndEntity(http.StatusInternalServerError, result)
+		return
+	}
+	md, etag, err := patchChartMetadata(req.Request, chartName, patch)
+	if err != nil {
+		log.Println(err)
+		result := &Result{}
+		result.Result = false
+		result.Error = err.Error()
+		resp.WriteHeaderAndEntity(workspaceStatus(err), result)
+		return
+	}
+	resp.Header().Set("ETag", etag)
+	resp.WriteHeaderAndEntity(http.StatusOK, md)
+}
+
+func (h HelmResource) bumpChartVersion(req *restful.Request, resp *restful.Response) {
+	chartName := req.PathParameter("chart-name")
+	field := req.QueryParameter("field")
+	if field == "" {
+		field = "version"
+	}
+	part := req.QueryParameter("part")
+	if (field != "version" && field != "appVersion") || (part != "major" && part != "minor" && part != "patch" && part != "prerelease") {
+		result := &Result{}
+		result.Result = false
+		result.Error = fmt.Sprintf("can't bump %q of %q", part, field)
+		resp.WriteHeaderAndEntity(http.StatusBadRequest, result)
+		return
+	}
+	bump, etag, err := bumpChartVersion(req.Request, chartName, field, part, req.QueryParameter("preid"))
+	if err != nil {
+		log.Println(err)
+		result := &Result{}
+		result.Result = false
+		result.Error = err.Error()
+		resp.WriteHeaderAndEntity(workspaceStatus(err), result)
+		return
+	}
+	resp.Header().Set("ETag", etag)
+	resp.WriteHeaderAndEntity(http.StatusOK, bump)
+}
+
+func (h HelmResource) patchValues(req *restful.Request, resp *restful.Response) {
+	chartName := req.PathParameter("chart-name")
+	file := req.QueryParameter("file")
+	if file == "" {
+		file = "values.yaml"
+	}
+	patchType, _, _ := mime.ParseMediaType(req.HeaderParameter("Content-Type"))
+	patch, err := ioutil.ReadAll(req.Request.Body)
+	if err != nil {
+		result := &Result{}
+		result.Result = false
+		result.Error = err.Error()
+		resp.WriteHeaderAndEntity(http.StatusInternalServerError, result)
+		return
+	}
+	content, err := patchValues(req.Request, chartName, file, patchType, patch)
+	if err != nil {
+		log.Println(err)
+		result := &Result{}
+		result.Result = false
+		result.Error = err.Error()
+		resp.WriteHeaderAndEntity(workspaceStatus(err), result)
+		return
+	}
+	resp.Header().Set("ETag", contentETag(content))
+	resp.Header().Set("Content-Type", "application/x-yaml")
+	resp.WriteHeader(http.StatusOK)
+	resp.Write(content)
+}
+
+func (h HelmResource) listDependencies(req *restful.Request, resp *restful.Response) {
+	chartName := req.PathParameter("chart-name")
+	deps, err := listChartDependencies(chartName)
+	if err != nil {
+		log.Println(err)
+		result := &Result{}
+		result.Result = false
+		result.Error = err.Error()
+		resp.WriteHeaderAndEntity(workspaceStatus(err), result)
+		return
+	}
+	resp.WriteHeaderAndEntity(http.StatusOK, deps)
+}
+
+func (h HelmResource) updateDependencies(req *restful.Request, resp *restful.Response) {
+	chartName := req.PathParameter("chart-name")
+	deps, err := updateChartDependencies(chartName, false, req.QueryParameter("skip-refresh") == "true")
+	if err != nil {
+		log.Println(err)
+		result := &Result{}
+		result.Result = false
+		result.Error = err.Error()
+		resp.WriteHeaderAndEntity(workspaceStatus(err), result)
+		return
+	}
+	resp.WriteHeaderAndEntity(http.StatusOK, deps)
+}
+
+func (h HelmResource) buildDependencies(req *restful.Request, resp *restful.Response) {
+	chartName := req.PathParameter("chart-name")
+	deps, err := updateChartDependencies(chartName, true, req.QueryParameter("skip-refresh") == "true")
+	if err != nil {
+		log.Println(err)
+		result := &Result{}
+		result.Result = false
+		result.Error = err.Error()
+		resp.WriteHeaderAndEntity(workspaceStatus(err), result)
+		return
+	}
+	resp.WriteHeaderAndEntity(http.StatusOK, deps)
+}
+
+func (h HelmResource) chartList(req *restful.Request, resp *restful.Response) {
+	fileNames := []string{}
+	files, err := chartWorkspace.readDir()
+	if err != nil {
+		log.Println(err)
+		result := &Result{}
+		result.Result = false
+		result.Error = err.Error()
+		resp.WriteHeaderAndEntity(http.StatusInternalServerError, result)
+		return
+	}
+
+	for _, file := range files {
+		fileNames = append(fileNames, file.Name())
+	}
+	resp.WriteHeaderAndEntity(http.StatusOK, fileNames)
+}
+
+func (h HelmResource) removeChart(req *restful.Request, resp *restful.Response) {
+	chartName := req.PathParameter("chart-name")
+	err := validName(chartName)
+	if err == nil {
+		err = changeChart(chartName, "remove chart", func() error {
+			if err := metaWorkspace.remove(chartName, "origin.json"); err != nil && !os.IsNotExist(errors.Cause(err)) {
+				return err
+			}
+			return chartWorkspace.removeAll(chartName)
+		})
+	}
+	if err != nil {
+		log.Println(err)
+		result := &Result{}
+		result.Result = false
+		result.Error = err.Error()
+		resp.WriteHeaderAndEntity(workspaceStatus(err), result)
+		return
+	}
+	result := &Result{}
+	result.Result = true
+	result.Message = "remove chart successfully"
+	resp.WriteHeaderAndEntity(http.StatusOK, result)
+}
+
+func (h HelmResource) packageList(req *restful.Request, resp *restful.Response) {
+	chartName := req.PathParameter("chart-name")
+	packages, err := listPackages(chartName)
+	if err != nil {
+		log.Println(err)
+		result := &Result{}
+		result.Result = false
+		result.Error = err.Error()
+		resp.WriteHeaderAndEntity(workspaceStatus(err), result)
+		return
+	}
+	resp.WriteHeaderAndEntity(http.StatusOK, packages)
+}
+
+func (h HelmResource) getPackage(req *restful.Request, resp *restful.Response) {
+	chartName := req.PathParameter("chart-name")
+	chartPackageName := req.PathParameter("chart-package-name")
+	err := validName(chartName)
+	if err == nil {
+		err = validName(chartPackageName)
+	}
+	var info os.FileInfo
+	var content []byte
+	if err == nil {
+		info, err = packageWorkspace.stat(chartName, chartPackageName)
+	}
+	if err == nil {
+		content, err = packageWorkspace.readFile(chartName, chartPackageName)
+	}
+	if err != nil {
+		log.Println(err)
+		result := &Result{}
+		result.Result = false
+		result.Error = err.Error()
+		resp.WriteHeaderAndEntity(workspaceStatus(err), result)
+		return
+	}
+	resp.Header().Set("Content-Type", packageContentType(chartPackageName))
+	resp.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", chartPackageName))
+	resp.Header().Set("ETag", contentETag(content))
+	if !strings.HasSuffix(chartPackageName, ".prov") {
+		if _, err := packageWorkspace.stat(chartName, chartPackageName+".prov"); err == nil {
+			resp.Header().Set("Link", fmt.Sprintf("<%s.prov>; rel=\"provenance\"", chartPackageName))
+		}
+	}
+	http.ServeContent(
+		resp.ResponseWriter,
+		req.Request,
+		info.Name(),
+		info.ModTime(),
+		bytes.NewReader(content))
+}
+
+func (h HelmResource) downloadChart(req *restful.Request, resp *restful.Response) {
+	chartName := req.PathParameter("chart-name")
+	ch, files, err := loadWorkspaceChart(chartName)
+	if err != nil {
+		log.Println(err)
+		result := &Result{}
+		result.Result = false
+		result.Error = err.Error()
+		resp.WriteHeaderAndEntity(workspaceStatus(err), result)
+		return
+	}
+	// build the whole archive first, a failure can't be reported once it streams
+	var archive bytes.Buffer
+	if err := writeChartArchive(&archive, ch.Metadata.Name, files); err != nil {
+		log.Println(err)
+		result := &Result{}
+		result.Result = false
+		result.Error = err.Error()
+		resp.WriteHeaderAndEntity(http.StatusInternalServerError, result)
+		return
+	}
+	resp.Header().Set("Content-Type", "application/gzip")
+	resp.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", ch.Metadata.Name+"-"+ch.Metadata.Version+".tgz"))
+	resp.Header().Set("Content-Length", strconv.Itoa(archive.Len()))
+	resp.WriteHeader(http.StatusOK)
+	resp.Write(archive.Bytes())
+}
+
+func (h HelmResource) removePackage(req *restful.Request, resp *restful.Response) {
+	chartName := req.PathParameter("chart-name")
+	chartPackageName := req.PathParameter("chart-package-name")
+	err := validName(chartName)
+	if err == nil {
+		err = validName(chartPackageName)
+	}
+	if err == nil {
+		err = packageWorkspace.remove(chartName, chartPackageName)
+	}
+	if err != nil {
+		log.Println(err)
+		result := &Result{}
+		result.Result = false
+		result.Error = err.Error()
+		resp.WriteHeaderAndEntity(workspaceStatus(err), result)
+		return
+	}
+	result := &Result{}
+	result.Result = true
+	result.Message = "remove chart package successfully"
+	resp.WriteHeaderAndEntity(http.StatusOK, result)
+}
+
+func (h HelmResource) chartRevisions(req *restful.Request, resp *restful.Response) {
+	chartName := req.PathParameter("chart-name")
+	revisions, err := listChartRevisions(chartName)
+	if err != nil {
+		log.Println(err)
+		result := &Result{}
+		result.Result = false
+		result.Error = err.Error()
+		resp.WriteHeaderAndEntity(workspaceStatus(err), result)
+		return
+	}
+	resp.WriteHeaderAndEntity(http.StatusOK, revisions)
+}
+
+func (h HelmResource) getChartRevision(req *restful.Request, resp *restful.Response) {
+	chartName := req.PathParameter("chart-name")
+	revision, errParse := strconv.Atoi(req.PathParameter("revision"))
+	if errParse != nil {
+		log.Println(errParse)
+		result := &Result{}
+		result.Result = false
+		result.Error = errParse.Error()
+		resp.WriteHeaderAndEntity(http.StatusBadRequest, result)
+		return
+	}
+	rev, err := getChartRevision(chartName, revision)
+	if err != nil {
+		log.Println(err)
+		result := &Result{}
+		result.Result = false
+		result.Error = err.Error()
+		resp.WriteHeaderAndEntity(workspaceStatus(err), result)
+		return
+	}
+	resp.WriteHeaderAndEntity(http.StatusOK, rev)
+}
+
+func (h HelmResource) getChartRevisionFile(req *restful.Request, resp *restful.Response) {
+	chartName := req.PathParameter("chart-name")
+	filePath := req.PathParameter("file-path")
+	revision, errParse := strconv.Atoi(req.PathParameter("revision"))
+	if errParse != nil {
+		log.Println(errParse)
+		result := &Result{}
+		result.Result = false
+		result.Error = errParse.Error()
+		resp.WriteHeaderAndEntity(http.StatusBadRequest, result)
+		return
+	}
+	content, err := chartRevisionFile(chartName, revision, filePath)
+	if err != nil {
+		log.Println(err)
+		result := &Result{}
+		result.Result = false
+		result.Error = err.Error()
+		resp.WriteHeaderAndEntity(workspaceStatus(err), result)
+		return
+	}
+	resp.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	resp.WriteHeader(http.StatusOK)
+	resp.Write(content)
+}
+
+func (h HelmResource) restoreChartRevision(req *restful.Request, resp *restful.Response) {
+	chartName := req.PathParameter("chart-name")
+	revision, errParse := strconv.Atoi(req.PathParameter("revision"))
+	if errParse != nil {
+		log.Println(errParse)
+		result := &Result{}
+		result.Result = false
+		result.Error = errParse.Error()
+		resp.WriteHeaderAndEntity(http.StatusBadRequest, result)
+		return
+	}
+	rev, err := restoreChartRevision(chartName, revision)
+	if err != nil {
+		log.Println(err)
+		result := &Result{}
+		result.Result = false
+		result.Error = err.Error()
+		resp.WriteHeaderAndEntity(workspaceStatus(err), result)
+		return
+	}
+	resp.WriteHeaderAndEntity(http.StatusOK, rev)
+}
+
+func (h HelmResource) diffChart(req *restful.Request, resp *restful.Response) {
+	chartName := req.PathParameter("chart-name")
+	from, errParse := strconv.Atoi(req.QueryParameter("from"))
+	to := 0
+	if toParam := req.QueryParameter("to"); toParam != "" && errParse == nil {
+		to, errParse = strconv.Atoi(toParam)
+	}
+	if errParse != nil {
+		log.Println(errParse)
+		result := &Result{}
+		result.Result = false
+		result.Error = errParse.Error()
+		resp.WriteHeaderAndEntity(http.StatusBadRequest, result)
+		return
+	}
+	diff, err := diffChart(chartName, from, to)
+	if err != nil {
+		log.Println(err)
+		result := &Result{}
+		result.Result = false
+		result.Error = err.Error()
+		resp.WriteHeaderAndEntity(workspaceStatus(err), result)
+		return
+	}
+	resp.Header().Set("Content-Type", "text/x-diff")
+	resp.WriteHeader(http.StatusOK)
+	resp.Write([]byte(diff))
+}
+
+func (h HelmResource) Register() {
+	charttags := []string{"chart"}
+	releasetags := []string{"release"}
+	repotags := []string{"repo"}
+
+	ws := new(restful.WebService)
+	ws.Path("/helm")
 	ws.Consumes(restful.MIME_JSON)
 	ws.Produces(restful.MIME_JSON)
 
@@ -626,6 +1316,7 @@ func (h HelmResource) Register() {
 		Returns(http.StatusInternalServerError, "inner error", Result{}))
 	ws.Route(ws.POST("/repo").To(h.addRepo).
 		Doc("add chart repository").
+		Param(ws.QueryParameter("verified-only", "only install verified charts from the repository").DataType("boolean").DefaultValue("false")).
 		Metadata(restfulspec.KeyOpenAPITags, repotags).
 		Reads(repo.Entry{}).
 		Returns(http.StatusCreated, "OK", Result{}).
@@ -643,6 +1334,26 @@ func (h HelmResource) Register() {
 		Returns(http.StatusOK, "OK", Result{}).
 		Returns(http.StatusInternalServerError, "inner error", Result{}))
 
+	ws.Route(ws.GET("/repo/verified-only").To(h.verifiedOnlyRepos).
+		Doc("list the repositories charts are only installed verified from").
+		Metadata(restfulspec.KeyOpenAPITags, repotags).
+		Returns(http.StatusOK, "OK", []string{}).
+		Returns(http.StatusInternalServerError, "inner error", Result{}))
+	ws.Route(ws.PUT("/repo/{repo-name}/verified-only").To(h.setVerifiedOnly).
+		Doc("only install verified charts from a repository").
+		Param(ws.PathParameter("repo-name", "name of the repo").DataType("string")).
+		Reads(EmptyBody{}).
+		Metadata(restfulspec.KeyOpenAPITags, repotags).
+		Returns(http.StatusOK, "OK", Result{}).
+		Returns(http.StatusNotFound, "not found", Result{}).
+		Returns(http.StatusInternalServerError, "inner error", Result{}))
+	ws.Route(ws.DELETE("/repo/{repo-name}/verified-only").To(h.setVerifiedOnly).
+		Doc("install charts from a repository without verification again").
+		Param(ws.PathParameter("repo-name", "name of the repo").DataType("string")).
+		Metadata(restfulspec.KeyOpenAPITags, repotags).
+		Returns(http.StatusOK, "OK", Result{}).
+		Returns(http.StatusInternalServerError, "inner error", Result{}))
+
 	// search
 	ws.Route(ws.GET("/search/repo").To(h.searchRepo).
 		Doc("search chart in repository").
@@ -655,9 +1366,80 @@ func (h HelmResource) Register() {
 	ws.Route(ws.POST("/chart/{chart-name}").To(h.create).
 		Doc("create chart").
 		Param(ws.PathParameter("chart-name", "name of chart").DataType("string")).
+		Param(ws.QueryParameter("starter", "name of the starter to create the chart from, the default starter when empty").DataType("string")).
 		Reads(EmptyBody{}).
 		Metadata(restfulspec.KeyOpenAPITags, charttags).
 		Returns(http.StatusOK, "OK", Result{}).
+		Returns(http.StatusNotFound, "starter not found", Result{}).
+		Returns(http.StatusInternalServerError, "inner error", Result{}))
+	ws.Route(ws.GET("/starter").To(h.listStarters).
+		Doc("list starters").
+		Metadata(restfulspec.KeyOpenAPITags, charttags).
+		Returns(http.StatusOK, "OK", []string{}).
+		Returns(http.StatusInternalServerError, "inner error", Result{}))
+	ws.Route(ws.GET("/starter/{starter-name}").To(h.getStarterFiles).
+		Doc("get starter files").
+		Param(ws.PathParameter("starter-name", "name of starter").DataType("string")).
+		Metadata(restfulspec.KeyOpenAPITags, charttags).
+		Returns(http.StatusOK, "OK", []string{}).
+		Returns(http.StatusNotFound, "not found", Result{}).
+		Returns(http.StatusInternalServerError, "inner error", Result{}))
+	ws.Route(ws.POST("/starter/{starter-name}").Consumes("application/gzip", "application/x-gzip", "application/x-compressed-tar", "application/zip", restful.MIME_OCTET, "multipart/form-data").To(h.uploadStarter).
+		Doc("upload a chart archive (.tgz) or a zip of a chart directory as starter").
+		Param(ws.PathParameter("starter-name", "name of starter").DataType("string")).
+		Param(ws.QueryParameter("overwrite", "replace an existing starter").DataType("boolean").DefaultValue("false")).
+		Param(ws.FormParameter("file", "the archive, when sent as multipart/form-data").DataType("file")).
+		Metadata(restfulspec.KeyOpenAPITags, charttags).
+		Returns(http.StatusCreated, "OK", Result{}).
+		Returns(http.StatusBadRequest, "invalid archive", Result{}).
+		Returns(http.StatusConflict, "starter already exists", Result{}).
+		Returns(http.StatusRequestEntityTooLarge, "archive too large", Result{}).
+		Returns(http.StatusInternalServerError, "inner error", Result{}))
+	ws.Route(ws.POST("/starter/{starter-name}/promote").To(h.promoteChart).
+		Doc("make a starter of a workspace chart").
+		Param(ws.PathParameter("starter-name", "name of starter").DataType("string")).
+		Param(ws.QueryParameter("chart", "name of the workspace chart").DataType("string").Required(true)).
+		Param(ws.QueryParameter("placeholder", "replace the chart name by <CHARTNAME> in the name of Chart.yaml and of the named templates").DataType("boolean").DefaultValue("false")).
+		Param(ws.QueryParameter("overwrite", "replace an existing starter").DataType("boolean").DefaultValue("false")).
+		Reads(EmptyBody{}).
+		Metadata(restfulspec.KeyOpenAPITags, charttags).
+		Returns(http.StatusCreated, "OK", Result{}).
+		Returns(http.StatusNotFound, "chart not found", Result{}).
+		Returns(http.StatusConflict, "starter already exists", Result{}).
+		Returns(http.StatusInternalServerError, "inner error", Result{}))
+	ws.Route(ws.DELETE("/starter/{starter-name}").To(h.removeStarter).
+		Doc("remove starter").
+		Param(ws.PathParameter("starter-name", "name of starter").DataType("string")).
+		Metadata(restfulspec.KeyOpenAPITags, charttags).
+		Returns(http.StatusOK, "OK", Result{}).
+		Returns(http.StatusNotFound, "not found", Result{}).
+		Returns(http.StatusInternalServerError, "inner error", Result{}))
+	ws.Route(ws.POST("/import").To(h.importChart).
+		Doc("import a chart from a repository, URL or OCI registry into the workspace").
+		Reads(ImportInfo{}).
+		Metadata(restfulspec.KeyOpenAPITags, charttags).
+		Returns(http.StatusCreated, "OK", chartOrigin{}).
+		Returns(http.StatusBadRequest, "bad request", Result{}).
+		Returns(http.StatusConflict, "chart already exists", Result{}).
+		Returns(http.StatusInternalServerError, "inner error", Result{}))
+	ws.Route(ws.POST("/chart/archive").Consumes("application/gzip", "application/x-gzip", "application/x-compressed-tar", "application/zip", restful.MIME_OCTET, "multipart/form-data").To(h.uploadArchive).
+		Doc("upload a chart archive (.tgz) or a zip of a chart directory into the workspace").
+		Param(ws.QueryParameter("target", "chart (unpack into the workspace) or package (store as chart package)").DataType("string").DefaultValue("chart")).
+		Param(ws.QueryParameter("name", "name of the chart in the workspace, the name of the chart when empty").DataType("string")).
+		Param(ws.QueryParameter("overwrite", "replace an existing chart or package").DataType("boolean").DefaultValue("false")).
+		Param(ws.FormParameter("file", "the archive, when sent as multipart/form-data").DataType("file")).
+		Metadata(restfulspec.KeyOpenAPITags, charttags).
+		Returns(http.StatusCreated, "OK", archiveUpload{}).
+		Returns(http.StatusBadRequest, "invalid archive", Result{}).
+		Returns(http.StatusConflict, "chart or package already exists", Result{}).
+		Returns(http.StatusRequestEntityTooLarge, "archive too large", Result{}).
+		Returns(http.StatusInternalServerError, "inner error", Result{}))
+	ws.Route(ws.GET("/origin/{chart-name}").To(h.getChartOrigin).
+		Doc("get where a workspace chart was imported from").
+		Param(ws.PathParameter("chart-name", "name of chart").DataType("string")).
+		Metadata(restfulspec.KeyOpenAPITags, charttags).
+		Returns(http.StatusOK, "OK", chartOrigin{}).
+		Returns(http.StatusNotFound, "not imported", Result{}).
 		Returns(http.StatusInternalServerError, "inner error", Result{}))
 	ws.Route(ws.GET("/chart").To(h.chartList).
 		Doc("list chart").
@@ -670,18 +1452,62 @@ func (h HelmResource) Register() {
 		Metadata(restfulspec.KeyOpenAPITags, charttags).
 		Returns(http.StatusOK, "OK", Result{}).
 		Returns(http.StatusInternalServerError, "inner error", Result{}))
+	ws.Route(ws.GET("/keyring").To(h.listTrustedKeys).
+		Doc("list the keys trusted to sign charts").
+		Metadata(restfulspec.KeyOpenAPITags, charttags).
+		Returns(http.StatusOK, "OK", []trustedKey{}).
+		Returns(http.StatusInternalServerError, "inner error", Result{}))
+	ws.Route(ws.POST("/keyring").Consumes("application/pgp-keys", "text/plain", restful.MIME_OCTET, "multipart/form-data").To(h.addTrustedKeys).
+		Doc("trust the public keys of an armored or binary keyring, as exported by gpg --export").
+		Metadata(restfulspec.KeyOpenAPITags, charttags).
+		Returns(http.StatusCreated, "the added keys", []trustedKey{}).
+		Returns(http.StatusBadRequest, "no keys", Result{}).
+		Returns(http.StatusInternalServerError, "inner error", Result{}))
+	ws.Route(ws.DELETE("/keyring/{fingerprint}").To(h.removeTrustedKey).
+		Doc("stop trusting a key").
+		Param(ws.PathParameter("fingerprint", "fingerprint of the key").DataType("string")).
+		Metadata(restfulspec.KeyOpenAPITags, charttags).
+		Returns(http.StatusOK, "OK", Result{}).
+		Returns(http.StatusNotFound, "not found", Result{}).
+		Returns(http.StatusInternalServerError, "inner error", Result{}))
+	ws.Route(ws.POST("/verify").To(h.verifyPackage).
+		Doc("verify a package against the trusted keys with its provenance file").
+		Reads(VerifyInfo{}).
+		Metadata(restfulspec.KeyOpenAPITags, charttags).
+		Returns(http.StatusOK, "OK", verification{}).
+		Returns(http.StatusNotFound, "not found", Result{}).
+		Returns(http.StatusUnprocessableEntity, "no trusted keys", Result{}).
+		Returns(http.StatusInternalServerError, "inner error", Result{}))
 	ws.Route(ws.POST("/package/{chart-name}").To(h.packageChart).
 		Doc("package chart").
 		Param(ws.PathParameter("chart-name", "name of chart").DataType("string")).
 		Metadata(restfulspec.KeyOpenAPITags, charttags).
-		Reads(EmptyBody{}).
-		Returns(http.StatusOK, "OK", Result{}).
+		Reads(PackageInfo{}).
+		Returns(http.StatusOK, "OK", packageResult{}).
+		Returns(http.StatusNotFound, "not found", Result{}).
+		Returns(http.StatusUnprocessableEntity, "invalid version", Result{}).
 		Returns(http.StatusInternalServerError, "inner error", Result{}))
 	ws.Route(ws.GET("/package/{chart-name}").To(h.packageList).
-		Doc("list chart package").
+		Doc("list chart packages, newest version first").
 		Param(ws.PathParameter("chart-name", "name of chart").DataType("string")).
 		Metadata(restfulspec.KeyOpenAPITags, charttags).
-		Returns(http.StatusOK, "OK", []string{}).
+		Returns(http.StatusOK, "OK", []chartPackage{}).
+		Returns(http.StatusNotFound, "not found", Result{}).
+		Returns(http.StatusInternalServerError, "inner error", Result{}))
+	ws.Route(ws.GET("/package/{chart-name}/{chart-package-name}").Produces(restful.MIME_JSON, "application/gzip", "application/pgp-signature", restful.MIME_OCTET).To(h.getPackage).
+		Doc("download chart package or its provenance file").
+		Param(ws.PathParameter("chart-name", "name of chart").DataType("string")).
+		Param(ws.PathParameter("chart-package-name", "name of chart package, or of its .prov file").DataType("string")).
+		Metadata(restfulspec.KeyOpenAPITags, charttags).
+		Returns(http.StatusOK, "the package, with a Link header to its provenance file if there is one", "package").
+		Returns(http.StatusNotFound, "not found", Result{}).
+		Returns(http.StatusInternalServerError, "inner error", Result{}))
+	ws.Route(ws.GET("/archive/{chart-name}").Produces(restful.MIME_JSON, "application/gzip").To(h.downloadChart).
+		Doc("download a workspace chart as chart archive, without packaging it").
+		Param(ws.PathParameter("chart-name", "name of chart").DataType("string")).
+		Metadata(restfulspec.KeyOpenAPITags, charttags).
+		Returns(http.StatusOK, "chart archive", "chart archive").
+		Returns(http.StatusNotFound, "not found", Result{}).
 		Returns(http.StatusInternalServerError, "inner error", Result{}))
 	ws.Route(ws.DELETE("/package/{chart-name}/{chart-package-name}").To(h.removePackage).
 		Doc("remove chart package").
@@ -699,7 +1525,31 @@ func (h HelmResource) Register() {
 		Reads(EmptyBody{}).
 		Returns(http.StatusOK, "OK", Result{}).
 		Returns(http.StatusInternalServerError, "inner error", Result{}))
-	ws.Route(ws.GET("/chart/{chart-name}/{file-path:*}").Produces("text/plain").To(h.getChartFile).
+	ws.Route(ws.POST("/template").Produces(restful.MIME_JSON, "application/x-yaml").To(h.template).
+		Doc("render chart templates locally").
+		Reads(TemplateInfo{}).
+		Metadata(restfulspec.KeyOpenAPITags, charttags).
+		Returns(http.StatusOK, "rendered manifests", "rendered manifests").
+		Returns(http.StatusBadRequest, "bad request", Result{}).
+		Returns(http.StatusNotFound, "chart not found", Result{}).
+		Returns(http.StatusInternalServerError, "inner error", Result{}))
+	ws.Route(ws.POST("/lint/{chart-name}").To(h.lint).
+		Doc("lint chart").
+		Param(ws.PathParameter("chart-name", "name of chart").DataType("string")).
+		Reads(LintInfo{}).
+		Metadata(restfulspec.KeyOpenAPITags, charttags).
+		Returns(http.StatusOK, "OK", lintReport{}).
+		Returns(http.StatusBadRequest, "bad request", Result{}).
+		Returns(http.StatusNotFound, "chart not found", Result{}).
+		Returns(http.StatusInternalServerError, "inner error", Result{}))
+	ws.Route(ws.POST("/lint").To(h.lint).
+		Doc("lint chart archive").
+		Reads(LintInfo{}).
+		Metadata(restfulspec.KeyOpenAPITags, charttags).
+		Returns(http.StatusOK, "OK", lintReport{}).
+		Returns(http.StatusBadRequest, "bad request", Result{}).
+		Returns(http.StatusInternalServerError, "inner error", Result{}))
+	ws.Route(ws.GET("/chart/{chart-name}/{file-path:*}").Produces(restful.MIME_JSON, "text/plain").To(h.getChartFile).
 		Doc("get chart file").
 		Param(ws.PathParameter("chart-name", "name of chart").DataType("string")).
 		Param(ws.PathParameter("file-path", "relative path of file").DataType("string")).
@@ -710,30 +1560,163 @@ func (h HelmResource) Register() {
 		Doc("edit chart file").
 		Param(ws.PathParameter("chart-name", "name of chart").DataType("string")).
 		Param(ws.PathParameter("file-path", "relative path of file").DataType("string")).
+		Param(ws.QueryParameter("validate", "validate the file before saving: none, warn (save and report findings) or reject (don't save on errors)").DataType("string").DefaultValue("none")).
+		Param(ws.HeaderParameter("If-Match", "ETag of the file as it was read, the edit fails if it changed since").DataType("string")).
+		Param(ws.HeaderParameter("If-None-Match", "* to create the file only if it doesn't exist").DataType("string")).
 		Reads(EmptyBody{}).
 		Metadata(restfulspec.KeyOpenAPITags, charttags).
-		Returns(http.StatusOK, "OK", Result{}).
+		Returns(http.StatusOK, "OK", ValidationResult{}).
+		Returns(http.StatusBadRequest, "bad request", Result{}).
+		Returns(http.StatusPreconditionFailed, "the file was changed", Result{}).
+		Returns(http.StatusPreconditionRequired, "If-Match is required", Result{}).
+		Returns(http.StatusUnprocessableEntity, "validation failed", ValidationResult{}).
 		Returns(http.StatusInternalServerError, "inner error", Result{}))
 	ws.Route(ws.DELETE("/chart/{chart-name}/{file-path:*}").To(h.removeChartFile).
 		Doc("remove chart file").
 		Param(ws.PathParameter("chart-name", "name of chart").DataType("string")).
 		Param(ws.PathParameter("file-path", "relative path of file").DataType("string")).
+		Param(ws.HeaderParameter("If-Match", "ETag of the file as it was read, the removal fails if it changed since").DataType("string")).
 		Metadata(restfulspec.KeyOpenAPITags, charttags).
 		Returns(http.StatusOK, "OK", Result{}).
+		Returns(http.StatusPreconditionFailed, "the file was changed", Result{}).
+		Returns(http.StatusPreconditionRequired, "If-Match is required", Result{}).
 		Returns(http.StatusInternalServerError, "inner error", Result{}))
 	ws.Route(ws.GET("/chart/{chart-name}").To(h.getChartFiles).
 		Doc("get chart files").
 		Param(ws.PathParameter("chart-name", "name of chart").DataType("string")).
+		Param(ws.QueryParameter("view", "paths (the relative paths), list (entries with type, size, hash and kind) or tree (the entries nested below their directories)").DataType("string").DefaultValue("paths")).
 		Metadata(restfulspec.KeyOpenAPITags, charttags).
-		Returns(http.StatusOK, "OK", []string{}).
+		Returns(http.StatusOK, "OK", []chartFileEntry{}).
+		Returns(http.StatusInternalServerError, "inner error", Result{}))
+	ws.Route(ws.GET("/metadata/{chart-name}").To(h.getChartMetadata).
+		Doc("get the metadata of a chart, its Chart.yaml").
+		Param(ws.PathParameter("chart-name", "name of chart").DataType("string")).
+		Metadata(restfulspec.KeyOpenAPITags, charttags).
+		Returns(http.StatusOK, "OK", chart.Metadata{}).
+		Returns(http.StatusNotFound, "not found", Result{}).
+		Returns(http.StatusInternalServerError, "inner error", Result{}))
+	ws.Route(ws.PATCH("/metadata/{chart-name}").Consumes(restful.MIME_JSON, "application/merge-patch+json").To(h.patchChartMetadata).
+		Doc("edit the metadata of a chart with a JSON merge patch, keeping the comments of its Chart.yaml").
+		Param(ws.PathParameter("chart-name", "name of chart").DataType("string")).
+		Param(ws.HeaderParameter("If-Match", "ETag of the Chart.yaml the patch is based on").DataType("string")).
+		Reads(chart.Metadata{}).
+		Metadata(restfulspec.KeyOpenAPITags, charttags).
+		Returns(http.StatusOK, "the patched metadata", chart.Metadata{}).
+		Returns(http.StatusNotFound, "not found", Result{}).
+		Returns(http.StatusPreconditionFailed, "the Chart.yaml was changed", Result{}).
+		Returns(http.StatusUnprocessableEntity, "invalid metadata", Result{}).
+		Returns(http.StatusInternalServerError, "inner error", Result{}))
+	ws.Route(ws.POST("/metadata/{chart-name}/bump").To(h.bumpChartVersion).
+		Doc("bump the version or app version of a chart").
+		Param(ws.PathParameter("chart-name", "name of chart").DataType("string")).
+		Param(ws.QueryParameter("part", "major, minor, patch or prerelease").DataType("string").Required(true)).
+		Param(ws.QueryParameter("field", "version or appVersion").DataType("string").DefaultValue("version")).
+		Param(ws.QueryParameter("preid", "identifier of a new prerelease, rc when not given").DataType("string")).
+		Param(ws.HeaderParameter("If-Match", "ETag of the Chart.yaml the bump is based on").DataType("string")).
+		Reads(EmptyBody{}).
+		Metadata(restfulspec.KeyOpenAPITags, charttags).
+		Returns(http.StatusOK, "OK", versionBump{}).
+		Returns(http.StatusBadRequest, "bad request", Result{}).
+		Returns(http.StatusNotFound, "not found", Result{}).
+		Returns(http.StatusPreconditionFailed, "the Chart.yaml was changed", Result{}).
+		Returns(http.StatusUnprocessableEntity, "not a semantic version", Result{}).
+		Returns(http.StatusInternalServerError, "inner error", Result{}))
+	ws.Route(ws.PATCH("/values/{chart-name}").Consumes(jsonPatchType, mergePatchType, restful.MIME_JSON).Produces(restful.MIME_JSON, "application/x-yaml").To(h.patchValues).
+		Doc("patch a values file of a chart with a JSON patch (application/json-patch+json) or a JSON merge patch (application/merge-patch+json), keeping its comments").
+		Param(ws.PathParameter("chart-name", "name of chart").DataType("string")).
+		Param(ws.QueryParameter("file", "relative path of the values file").DataType("string").DefaultValue("values.yaml")).
+		Param(ws.HeaderParameter("If-Match", "ETag of the values file the patch is based on").DataType("string")).
+		Metadata(restfulspec.KeyOpenAPITags, charttags).
+		Returns(http.StatusOK, "the patched values file", "the patched values file").
+		Returns(http.StatusBadRequest, "not a values file", Result{}).
+		Returns(http.StatusNotFound, "not found", Result{}).
+		Returns(http.StatusPreconditionFailed, "the values file was changed", Result{}).
+		Returns(http.StatusUnprocessableEntity, "the patch can't be applied", Result{}).
+		Returns(http.StatusInternalServerError, "inner error", Result{}))
+	ws.Route(ws.GET("/dependency/{chart-name}").To(h.listDependencies).
+		Doc("list the dependencies of a chart with their status").
+		Param(ws.PathParameter("chart-name", "name of chart").DataType("string")).
+		Metadata(restfulspec.KeyOpenAPITags, charttags).
+		Returns(http.StatusOK, "OK", chartDependencies{}).
+		Returns(http.StatusNotFound, "not found", Result{}).
+		Returns(http.StatusInternalServerError, "inner error", Result{}))
+	ws.Route(ws.POST("/dependency/{chart-name}/update").To(h.updateDependencies).
+		Doc("resolve the dependencies of a chart into Chart.lock and download them into charts/").
+		Param(ws.PathParameter("chart-name", "name of chart").DataType("string")).
+		Param(ws.QueryParameter("skip-refresh", "don't refresh the local repository cache").DataType("boolean").DefaultValue("false")).
+		Reads(EmptyBody{}).
+		Metadata(restfulspec.KeyOpenAPITags, charttags).
+		Returns(http.StatusOK, "OK", chartDependencies{}).
+		Returns(http.StatusNotFound, "not found", Result{}).
+		Returns(http.StatusInternalServerError, "inner error", Result{}))
+	ws.Route(ws.POST("/dependency/{chart-name}/build").To(h.buildDependencies).
+		Doc("download the dependencies of a chart into charts/ as locked in Chart.lock").
+		Param(ws.PathParameter("chart-name", "name of chart").DataType("string")).
+		Param(ws.QueryParameter("skip-refresh", "don't refresh the local repository cache").DataType("boolean").DefaultValue("false")).
+		Reads(EmptyBody{}).
+		Metadata(restfulspec.KeyOpenAPITags, charttags).
+		Returns(http.StatusOK, "OK", chartDependencies{}).
+		Returns(http.StatusNotFound, "not found", Result{}).
+		Returns(http.StatusInternalServerError, "inner error", Result{}))
+	ws.Route(ws.GET("/revision/{chart-name}").To(h.chartRevisions).
+		Doc("list the revisions of a chart").
+		Param(ws.PathParameter("chart-name", "name of chart").DataType("string")).
+		Metadata(restfulspec.KeyOpenAPITags, charttags).
+		Returns(http.StatusOK, "OK", []chartRevision{}).
+		Returns(http.StatusInternalServerError, "inner error", Result{}))
+	ws.Route(ws.GET("/revision/{chart-name}/{revision}").To(h.getChartRevision).
+		Doc("get a revision of a chart with the hashes of its files").
+		Param(ws.PathParameter("chart-name", "name of chart").DataType("string")).
+		Param(ws.PathParameter("revision", "revision of chart").DataType("integer")).
+		Metadata(restfulspec.KeyOpenAPITags, charttags).
+		Returns(http.StatusOK, "OK", chartRevision{}).
+		Returns(http.StatusNotFound, "not found", Result{}).
+		Returns(http.StatusInternalServerError, "inner error", Result{}))
+	ws.Route(ws.GET("/revision/{chart-name}/{revision}/{file-path:*}").Produces(restful.MIME_JSON, "text/plain").To(h.getChartRevisionFile).
+		Doc("get chart file at a revision").
+		Param(ws.PathParameter("chart-name", "name of chart").DataType("string")).
+		Param(ws.PathParameter("revision", "revision of chart").DataType("integer")).
+		Param(ws.PathParameter("file-path", "relative path of file").DataType("string")).
+		Metadata(restfulspec.KeyOpenAPITags, charttags).
+		Returns(http.StatusOK, "file content", "file content").
+		Returns(http.StatusNotFound, "not found", Result{}).
+		Returns(http.StatusInternalServerError, "inner error", Result{}))
+	ws.Route(ws.POST("/revision/{chart-name}/{revision}/restore").To(h.restoreChartRevision).
+		Doc("restore the files of a chart to a revision").
+		Param(ws.PathParameter("chart-name", "name of chart").DataType("string")).
+		Param(ws.PathParameter("revision", "revision of chart").DataType("integer")).
+		Reads(EmptyBody{}).
+		Metadata(restfulspec.KeyOpenAPITags, charttags).
+		Returns(http.StatusOK, "the revision recording the restore", chartRevision{}).
+		Returns(http.StatusNotFound, "not found", Result{}).
+		Returns(http.StatusInternalServerError, "inner error", Result{}))
+	ws.Route(ws.GET("/diff/{chart-name}").Produces(restful.MIME_JSON, "text/x-diff").To(h.diffChart).
+		Doc("diff two revisions of a chart").
+		Param(ws.PathParameter("chart-name", "name of chart").DataType("string")).
+		Param(ws.QueryParameter("from", "revision to diff from").DataType("integer").Required(true)).
+		Param(ws.QueryParameter("to", "revision to diff to, the chart in the workspace when not given").DataType("integer")).
+		Metadata(restfulspec.KeyOpenAPITags, charttags).
+		Returns(http.StatusOK, "unified diff", "unified diff").
+		Returns(http.StatusBadRequest, "bad request", Result{}).
+		Returns(http.StatusNotFound, "not found", Result{}).
 		Returns(http.StatusInternalServerError, "inner error", Result{}))
 
 	// release
 	ws.Route(ws.GET("/list").To(h.list).
 		Doc("list releases").
 		Param(ws.QueryParameter("namespace", "namespace of the releases").DataType("string")).
+		Param(ws.QueryParameter("status", "statuses to include(separated with commas): deployed, failed, pending, uninstalled, uninstalling, superseded, all").DataType("string")).
+		Param(ws.QueryParameter("filter", "regular expression the release name must match").DataType("string")).
+		Param(ws.QueryParameter("selector", "label selector of the releases, matching helm and release labels, e.g. team=payments,status=deployed").DataType("string")).
+		Param(ws.QueryParameter("sort", "sort by name, date or chart").DataType("string").DefaultValue("name")).
+		Param(ws.QueryParameter("reverse", "reverse the sort order").DataType("boolean").DefaultValue("false")).
+		Param(ws.QueryParameter("limit", "maximum number of releases to return, 0 for all").DataType("integer").DefaultValue("0")).
+		Param(ws.QueryParameter("offset", "index of the first release to return").DataType("integer").DefaultValue("0")).
+		Param(ws.QueryParameter("continue", "continue token of the previous page, overrides offset").DataType("string")).
+		Param(ws.QueryParameter("full", "return full releases instead of the slim projection").DataType("boolean").DefaultValue("false")).
 		Metadata(restfulspec.KeyOpenAPITags, releasetags).
-		Returns(http.StatusOK, "OK", []release.Release{}).
+		Returns(http.StatusOK, "OK", releaseList{}).
+		Returns(http.StatusBadRequest, "bad request", Result{}).
 		Returns(http.StatusInternalServerError, "inner error", Result{}))
 	ws.Route(ws.GET("/get/all").To(h.getAll).
 		Doc("get release info").
@@ -764,17 +1747,35 @@ func (h HelmResource) Register() {
 		Returns(http.StatusInternalServerError, "inner error", Result{}))
 	ws.Route(ws.PUT("/rollback").To(h.rollback).
 		Doc("rollback release").
-		Reads(ReleaseInfo{}).
+		Reads(RollbackInfo{}).
 		Metadata(restfulspec.KeyOpenAPITags, releasetags).
-		Reads(EmptyBody{}).
-		Returns(http.StatusOK, "OK", Result{}).
+		Returns(http.StatusOK, "OK", release.Release{}).
+		Returns(http.StatusInternalServerError, "inner error", Result{}))
+	ws.Route(ws.POST("/test").To(h.releaseTest).
+		Doc("run the tests of release").
+		Reads(TestInfo{}).
+		Metadata(restfulspec.KeyOpenAPITags, releasetags).
+		Returns(http.StatusOK, "OK", testRun{}).
+		Returns(http.StatusInternalServerError, "inner error", Result{}))
+	ws.Route(ws.GET("/test").To(h.lastTestRun).
+		Doc("get the results of the latest test run of release").
+		Param(ws.QueryParameter("release-name", "name of the release").DataType("string")).
+		Param(ws.QueryParameter("namespace", "namespace of the release").DataType("string")).
+		Metadata(restfulspec.KeyOpenAPITags, releasetags).
+		Returns(http.StatusOK, "OK", testRun{}).
 		Returns(http.StatusInternalServerError, "inner error", Result{}))
 	ws.Route(ws.DELETE("/uninstall").To(h.uninstall).
 		Doc("uninstall releases").
 		Param(ws.QueryParameter("releases", "name of the releases(separated with commas)").DataType("string")).
 		Param(ws.QueryParameter("namespace", "namespace of the releases").DataType("string")).
+		Param(ws.QueryParameter("keep-history", "remove all associated resources and mark the release as deleted, but retain the release history").DataType("boolean").DefaultValue("false")).
+		Param(ws.QueryParameter("dry-run", "simulate an uninstall").DataType("boolean").DefaultValue("false")).
+		Param(ws.QueryParameter("disable-hooks", "prevent hooks from running during uninstallation").DataType("boolean").DefaultValue("false")).
+		Param(ws.QueryParameter("wait", "wait until all resources of the release are deleted").DataType("boolean").DefaultValue("false")).
+		Param(ws.QueryParameter("timeout", "time to wait for any individual kubernetes operation").DataType("string").DefaultValue("5m0s")).
 		Metadata(restfulspec.KeyOpenAPITags, releasetags).
-		Returns(http.StatusOK, "OK", Result{}).
+		Returns(http.StatusOK, "OK", []uninstallResult{}).
+		Returns(http.StatusBadRequest, "bad request", Result{}).
 		Returns(http.StatusInternalServerError, "inner error", Result{}))
 
 	container.Add(ws)
@@ -833,13 +1834,51 @@ type Result struct {
 	Error   string `json:"error" description:"error" default:"string"`
 }
 
+// response result with validation findings
+type ValidationResult struct {
+	Result   bool          `json:"result" description:"result" default:"false"`
+	Message  string        `json:"message" description:"message" default:"string"`
+	Error    string        `json:"error" description:"error" default:"string"`
+	Findings []lintFinding `json:"findings,omitempty" description:"validation findings"`
+}
+
 // information of release
 type ReleaseInfo struct {
 	Name      string   `json:"name" description:"name of release" default:"string"`
 	Namespace string   `json:"namespace" description:"namespace of release" default:"string"`
+	Source    string   `json:"source" description:"where the chart comes from: workspace (name of a workspace chart), package (workspace chart and package), repo (repo/chart), url or oci; without a source oci:// and http(s):// references are pulled as such and anything else must be repo/chart" default:"string"`
 	Chart     string   `json:"chart" description:"chart of release" default:"string"`
+	Package   string   `json:"package" description:"file name of the package of the workspace chart, for the package source" default:"string"`
 	Values    []string `json:"values" description:"values of release" default:"[]"`
 	Version   int      `json:"version" description:"version of release" default:"0"`
+	Verify    bool     `json:"verify" description:"verify the chart against the trusted keys before installing it, always done for verified-only repositories" default:"false"`
+	// where to find the chart, version is the revision of the release
+	ChartVersion string `json:"chart_version" description:"version constraint of the chart for the repo source, the tag of an oci reference" default:"string"`
+	RepoURL      string `json:"repo_url" description:"repository URL to look the chart up in, instead of a configured repository" default:"string"`
+	PlainHTTP    bool   `json:"plain_http" description:"use http instead of https to talk to an OCI registry" default:"false"`
+	// metadata stored on the release, e.g. team or cost-center
+	Labels      map[string]string `json:"labels,omitempty" description:"labels of release, usable in the list selector; kept on the latest revision only, as helm rewrites earlier revisions without them" default:"{}"`
+	Annotations map[string]string `json:"annotations,omitempty" description:"annotations of release, kept on the latest revision only" default:"{}"`
+}
+
+func (r *ReleaseInfo) metadata() *releaseMetadata {
+	return &releaseMetadata{Labels: r.Labels, Annotations: r.Annotations}
+}
+
+// information of rollback
+type RollbackInfo struct {
+	Name          string `json:"name" description:"name of release" default:"string"`
+	Namespace     string `json:"namespace" description:"namespace of release" default:"string"`
+	Version       int    `json:"version" description:"revision to roll back to, 0 for the previous revision" default:"0"`
+	LastDeployed  bool   `json:"last_deployed" description:"roll back to the last successfully deployed revision, skipping failed ones; not with version" default:"false"`
+	Wait          bool   `json:"wait" description:"wait until all resources are ready" default:"false"`
+	WaitForJobs   bool   `json:"wait_for_jobs" description:"wait until all jobs have completed, together with wait" default:"false"`
+	Timeout       string `json:"timeout" description:"time to wait for any individual kubernetes operation" default:"5m0s"`
+	Force         bool   `json:"force" description:"force resource update through delete/recreate if needed" default:"false"`
+	Recreate      bool   `json:"recreate" description:"restart pods of the resources if applicable" default:"false"`
+	CleanupOnFail bool   `json:"cleanup_on_fail" description:"delete new resources created in this rollback when it fails" default:"false"`
+	DisableHooks  bool   `json:"disable_hooks" description:"prevent hooks from running during rollback" default:"false"`
+	MaxHistory    int    `json:"max_history" description:"maximum number of revisions saved per release, 0 for no limit" default:"0"`
 }
 
 // empty body
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	restful "github.com/emicklei/go-restful/v3"
+	"github.com/pkg/errors"
+)
+
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// idempotencyStore keeps the outcome of mutating requests by their Idempotency-Key
+// so that retried requests are answered without running the operation again.
+type idempotencyStore struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]*idempotencyEntry
+}
+
+type idempotencyEntry struct {
+	fingerprint string
+	expires     time.Time
+	done        bool
+	status      int
+	header      http.Header
+	body        []byte
+}
+
+func newIdempotencyStore(ttl time.Duration) *idempotencyStore {
+	return &idempotencyStore{
+		ttl:     ttl,
+		entries: map[string]*idempotencyEntry{},
+	}
+}
+
+// begin looks up key and reserves it for the request with the given fingerprint
+// when it is unknown. It returns the existing entry if the key has been seen before.
+func (s *idempotencyStore) begin(key string, fingerprint string) (*idempotencyEntry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for k, e := range s.entries {
+		if e.done && now.After(e.expires) {
+			delete(s.entries, k)
+		}
+	}
+	if e, ok := s.entries[key]; ok {
+		return e, true
+	}
+	s.entries[key] = &idempotencyEntry{fingerprint: fingerprint}
+	return nil, false
+}
+
+// finish records the outcome of the request that reserved key.
+func (s *idempotencyStore) finish(key string, status int, header http.Header, body []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[key]
+	if !ok {
+		return
+	}
+	e.done = true
+	e.status = status
+	e.header = header
+	e.body = body
+	e.expires = time.Now().Add(s.ttl)
+}
+
+// abort releases key without recording an outcome, e.g. when the handler
+// panicked or failed with a server error.
+func (s *idempotencyStore) abort(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.entries[key]; ok && !e.done {
+		delete(s.entries, key)
+	}
+}
+
+// recordingResponseWriter passes the response through while keeping a copy of it.
+type recordingResponseWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *recordingResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recordingResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+// filter replays the stored outcome of POST, PUT, PATCH and DELETE requests carrying
+// an Idempotency-Key header. Keys are scoped by method and path, so requests to
+// different endpoints never share an outcome. Reusing a key with a different
+// request is rejected with 422, a retry arriving while the first request is
+// still running with 409. Server errors (5xx) aren't kept, so a retry after one
+// runs again. Bodies are read up to maxUploadSize, the largest body any handler
+// accepts. Outcomes are kept in memory, by each replica on its own.
+func (s *idempotencyStore) filter(req *restful.Request, resp *restful.Response, chain *restful.FilterChain) {
+	key := req.HeaderParameter(idempotencyKeyHeader)
+	switch req.Request.Method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+	default:
+		key = ""
+	}
+	if key == "" {
+		chain.ProcessFilter(req, resp)
+		return
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(resp.ResponseWriter, req.Request.Body, maxUploadSize))
+	if err != nil {
+		err = uploadError(err)
+		log.Println(err)
+		status := http.StatusBadRequest
+		if errors.Is(err, errUploadTooLarge) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		result := &Result{}
+		result.Result = false
+		result.Error = err.Error()
+		resp.WriteHeaderAndEntity(status, result)
+		return
+	}
+	req.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+	key = req.Request.Method + " " + req.Request.URL.Path + " " + key
+	hash := sha256.New()
+	hash.Write([]byte(req.Request.Method + " " + req.Request.URL.RequestURI() + "\n"))
+	hash.Write(body)
+	fingerprint := hex.EncodeToString(hash.Sum(nil))
+
+	entry, found := s.begin(key, fingerprint)
+	if found {
+		result := &Result{}
+		result.Result = false
+		switch {
+		case entry.fingerprint != fingerprint:
+			result.Error = "idempotency key has already been used for a different request"
+			resp.WriteHeaderAndEntity(http.StatusUnprocessableEntity, result)
+		case !entry.done:
+			result.Error = "a request with this idempotency key is still in progress"
+			resp.WriteHeaderAndEntity(http.StatusConflict, result)
+		default:
+			for k, v := range entry.header {
+				resp.Header()[k] = v
+			}
+			resp.Header().Set("Idempotent-Replayed", "true")
+			resp.WriteHeader(entry.status)
+			resp.Write(entry.body)
+		}
+		return
+	}
+
+	recorder := &recordingResponseWriter{ResponseWriter: resp.ResponseWriter}
+	resp.ResponseWriter = recorder
+	completed := false
+	defer func() {
+		if !completed {
+			s.abort(key)
+		}
+	}()
+	chain.ProcessFilter(req, resp)
+	completed = true
+	status := recorder.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	// server errors are often transient, a retry runs the request again
+	if status >= http.StatusInternalServerError {
+		s.abort(key)
+		return
+	}
+	s.finish(key, status, recorder.Header().Clone(), recorder.body.Bytes())
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	restful "github.com/emicklei/go-restful/v3"
+)
+
+// idempotentServer serves POST /a and /b, counting the calls of the handlers,
+// behind the idempotency filter. /flaky fails with 503 on its first call. /slow tells started and waits until release
+// is closed.
+func idempotentServer(t *testing.T) (*httptest.Server, *int32, chan struct{}, chan struct{}) {
+	t.Helper()
+	var calls int32
+	started, release := make(chan struct{}, 1), make(chan struct{})
+	handler := func(req *restful.Request, resp *restful.Response) {
+		n := atomic.AddInt32(&calls, 1)
+		body, _ := ioutil.ReadAll(req.Request.Body)
+		resp.Header().Set("X-Call", strconv.Itoa(int(n)))
+		resp.WriteHeader(http.StatusCreated)
+		resp.Write([]byte(req.Request.URL.Path + " " + string(body)))
+	}
+	ws := new(restful.WebService)
+	ws.Produces(restful.MIME_JSON)
+	ws.Route(ws.POST("/a").To(handler))
+	ws.Route(ws.POST("/b").To(handler))
+	ws.Route(ws.POST("/flaky").To(func(req *restful.Request, resp *restful.Response) {
+		// fails the first time, like a timeout of the kubernetes API
+		if atomic.AddInt32(&calls, 1) == 1 {
+			resp.WriteErrorString(http.StatusServiceUnavailable, "try again")
+			return
+		}
+		resp.WriteHeader(http.StatusCreated)
+	}))
+	ws.Route(ws.POST("/slow").To(func(req *restful.Request, resp *restful.Response) {
+		started <- struct{}{}
+		<-release
+		handler(req, resp)
+	}))
+	container := restful.NewContainer()
+	container.Add(ws)
+	container.Filter(newIdempotencyStore(time.Hour).filter)
+	srv := httptest.NewServer(container)
+	t.Cleanup(srv.Close)
+	return srv, &calls, started, release
+}
+
+func idempotentPost(t *testing.T, url string, key string, body string) (*http.Response, string) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept", restful.MIME_JSON)
+	if key != "" {
+		req.Header.Set(idempotencyKeyHeader, key)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, _ := ioutil.ReadAll(resp.Body)
+	return resp, string(data)
+}
+
+func TestIdempotencyReplay(t *testing.T) {
+	srv, calls, _, _ := idempotentServer(t)
+
+	first, firstBody := idempotentPost(t, srv.URL+"/a", "k1", "x")
+	retry, retryBody := idempotentPost(t, srv.URL+"/a", "k1", "x")
+	if atomic.LoadInt32(calls) != 1 {
+		t.Errorf("handler called %d times, want 1", atomic.LoadInt32(calls))
+	}
+	if retry.StatusCode != http.StatusCreated || retryBody != firstBody || retry.Header.Get("X-Call") != first.Header.Get("X-Call") {
+		t.Errorf("replay = %d %q %q, want %d %q %q", retry.StatusCode, retryBody, retry.Header.Get("X-Call"), first.StatusCode, firstBody, first.Header.Get("X-Call"))
+	}
+	if retry.Header.Get("Idempotent-Replayed") != "true" || first.Header.Get("Idempotent-Replayed") != "" {
+		t.Error("Idempotent-Replayed header not set on the replay only")
+	}
+
+	if resp, _ := idempotentPost(t, srv.URL+"/a", "k1", "y"); resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("key reused with another body = %d, want 422", resp.StatusCode)
+	}
+	if resp, _ := idempotentPost(t, srv.URL+"/a?force=true", "k1", "x"); resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("key reused with another query = %d, want 422", resp.StatusCode)
+	}
+	if atomic.LoadInt32(calls) != 1 {
+		t.Errorf("handler called %d times, want 1", atomic.LoadInt32(calls))
+	}
+
+	// without a key every request runs
+	idempotentPost(t, srv.URL+"/a", "", "x")
+	idempotentPost(t, srv.URL+"/a", "", "x")
+	if atomic.LoadInt32(calls) != 3 {
+		t.Errorf("handler called %d times, want 3", atomic.LoadInt32(calls))
+	}
+}
+
+func TestIdempotencyKeyScope(t *testing.T) {
+	srv, calls, _, _ := idempotentServer(t)
+	_, a := idempotentPost(t, srv.URL+"/a", "same", "x")
+	resp, b := idempotentPost(t, srv.URL+"/b", "same", "x")
+	if atomic.LoadInt32(calls) != 2 {
+		t.Errorf("handler called %d times, want 2", atomic.LoadInt32(calls))
+	}
+	if resp.StatusCode != http.StatusCreated || a == b || b != "/b x" {
+		t.Errorf("/b answered %d %q with the key used for /a (%q)", resp.StatusCode, b, a)
+	}
+}
+
+func TestIdempotencyInProgress(t *testing.T) {
+	srv, calls, started, release := idempotentServer(t)
+	done := make(chan string)
+	go func() {
+		_, body := idempotentPost(t, srv.URL+"/slow", "k", "x")
+		done <- body
+	}()
+	<-started
+	resp, _ := idempotentPost(t, srv.URL+"/slow", "k", "x")
+	close(release)
+	if resp.StatusCode != http.StatusConflict {
+		t.Errorf("retry of a running request = %d, want 409", resp.StatusCode)
+	}
+	if body := <-done; body != "/slow x" {
+		t.Errorf("first request = %q", body)
+	}
+	if atomic.LoadInt32(calls) != 1 {
+		t.Errorf("handler called %d times, want 1", atomic.LoadInt32(calls))
+	}
+}
+
+func TestIdempotencyBodyLimit(t *testing.T) {
+	limit := maxUploadSize
+	maxUploadSize = 10
+	defer func() { maxUploadSize = limit }()
+	srv, calls, _, _ := idempotentServer(t)
+
+	resp, _ := idempotentPost(t, srv.URL+"/a", "k", strings.Repeat("x", 11))
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("body over the limit = %d, want 413", resp.StatusCode)
+	}
+	if resp, _ := idempotentPost(t, srv.URL+"/a", "k", "small"); resp.StatusCode != http.StatusCreated {
+		t.Errorf("body within the limit = %d, want 201", resp.StatusCode)
+	}
+	if atomic.LoadInt32(calls) != 1 {
+		t.Errorf("handler called %d times, want 1", atomic.LoadInt32(calls))
+	}
+}
+
+func TestIdempotencyServerError(t *testing.T) {
+	srv, calls, _, _ := idempotentServer(t)
+	if resp, _ := idempotentPost(t, srv.URL+"/flaky", "k", "x"); resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("first call = %d, want 503", resp.StatusCode)
+	}
+	resp, _ := idempotentPost(t, srv.URL+"/flaky", "k", "x")
+	if resp.StatusCode != http.StatusCreated || resp.Header.Get("Idempotent-Replayed") != "" {
+		t.Errorf("retry after a server error = %d, replayed %q, want 201 run again", resp.StatusCode, resp.Header.Get("Idempotent-Replayed"))
+	}
+	// the success is kept
+	resp, _ = idempotentPost(t, srv.URL+"/flaky", "k", "x")
+	if resp.StatusCode != http.StatusCreated || resp.Header.Get("Idempotent-Replayed") != "true" {
+		t.Errorf("retry after the success = %d, replayed %q", resp.StatusCode, resp.Header.Get("Idempotent-Replayed"))
+	}
+	if atomic.LoadInt32(calls) != 2 {
+		t.Errorf("handler called %d times, want 2", atomic.LoadInt32(calls))
+	}
+}
@@ -113,18 +113,36 @@ func install(releaseInfo *ReleaseInfo) (*release.Release, error) {
 		return nil, err
 	}
 
+	if err := validateReleaseMetadata(releaseInfo.metadata()); err != nil {
+		return nil, err
+	}
+	st, err := newReleaseStorage(s)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
 	client := action.NewInstall(cfg)
 	valueOpts := &helmValues.Options{}
 	valueOpts.Values = releaseInfo.Values
 	out := os.Stdout
-	args := []string{releaseInfo.Name, releaseInfo.Chart}
 	client.CreateNamespace = true
 	client.Namespace = releaseInfo.Namespace
+	chartRef, cleanup, err := resolveChartSource(releaseInfo, &client.ChartPathOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+	args := []string{releaseInfo.Name, chartRef}
 	rel, err := runInstall(args, client, valueOpts, out, s)
 	if err != nil {
 		log.Println(err)
 		return nil, err
 	}
+	if err := st.apply(rel, releaseInfo.metadata()); err != nil {
+		log.Println(err)
+		return rel, err
+	}
 	return rel, nil
 }
 
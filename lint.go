@@ -0,0 +1,203 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	helmValues "helm.sh/helm/v3/pkg/cli/values"
+	"helm.sh/helm/v3/pkg/getter"
+	"helm.sh/helm/v3/pkg/lint/support"
+)
+
+var longLintHelp = `
+This command takes a path to a chart and runs a series of tests to verify that
+the chart is well-formed.
+
+If the linter encounters things that will cause the chart to fail installation,
+it will emit [ERROR] messages. If it encounters issues that break with convention
+or recommendation, it will emit [WARNING] messages.
+`
+
+// information of a lint request
+type LintInfo struct {
+	Strict        bool     `json:"strict" description:"fail on lint warnings" default:"false"`
+	Values        []string `json:"values" description:"values used to render the templates" default:"[]"`
+	Namespace     string   `json:"namespace" description:"namespace used to render the templates" default:"string"`
+	KubeVersion   string   `json:"kube_version" description:"kubernetes version the chart must be compatible with" default:"string"`
+	WithSubcharts bool     `json:"with_subcharts" description:"lint dependent charts" default:"false"`
+	Archive       []byte   `json:"archive,omitempty" description:"base64 encoded chart archive, when no chart name is given" default:"string"`
+}
+
+// one finding of the linter
+type lintFinding struct {
+	Severity string `json:"severity" description:"INFO, WARNING or ERROR"`
+	Path     string `json:"path" description:"file the finding is about, relative to the chart"`
+	Line     int    `json:"line,omitempty" description:"line in the file, where available"`
+	Message  string `json:"message"`
+}
+
+// result of linting a chart
+type lintReport struct {
+	Passed   bool          `json:"passed"`
+	Findings []lintFinding `json:"findings"`
+}
+
+var lintSeverities = map[int]string{
+	support.UnknownSev: "UNKNOWN",
+	support.InfoSev:    "INFO",
+	support.WarningSev: "WARNING",
+	support.ErrorSev:   "ERROR",
+}
+
+var errInvalidLintInfo = errors.New("invalid lint request")
+
+var (
+	// e.g. template: mychart/templates/service.yaml:3:12: executing ...
+	// or parse error at (mychart/templates/service.yaml:3): ...
+	lintTemplateLine = regexp.MustCompile(`[^\s:()"]+?/((?:templates|charts)/[^\s:()"]+):(\d+)`)
+	// e.g. error converting YAML to JSON: yaml: line 3: ...
+	lintYAMLLine = regexp.MustCompile(`line (\d+)`)
+)
+
+// lint lints a workspace chart, or the archive in lintInfo when chartName is empty.
+func lint(chartName string, lintInfo *LintInfo) (*lintReport, error) {
+	if lintInfo.KubeVersion != "" {
+		if _, err := chartutil.ParseKubeVersion(lintInfo.KubeVersion); err != nil {
+			return nil, errors.Wrapf(errInvalidLintInfo, "invalid kube version '%s': %v", lintInfo.KubeVersion, err)
+		}
+	}
+	var path string
+	if chartName != "" {
+		if _, err := chartWorkspace.stat(chartName, "Chart.yaml"); err != nil {
+			return nil, err
+		}
+		dir, done, err := checkoutChart(chartName)
+		if err != nil {
+			return nil, err
+		}
+		defer done(false)
+		path = dir
+	} else {
+		if len(lintInfo.Archive) == 0 {
+			return nil, errors.Wrap(errInvalidLintInfo, "chart archive is required")
+		}
+		tmp, err := ioutil.TempDir("", "helm-rest-lint")
+		if err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(tmp)
+		path = filepath.Join(tmp, "chart.tgz")
+		if err := ioutil.WriteFile(path, lintInfo.Archive, 0644); err != nil {
+			return nil, err
+		}
+	}
+	return lintPath(path, lintInfo)
+}
+
+// lintPath lints the chart directory or archive at path.
+func lintPath(path string, lintInfo *LintInfo) (*lintReport, error) {
+	valueOpts := &helmValues.Options{}
+	valueOpts.Values = lintInfo.Values
+	vals, err := valueOpts.MergeValues(getter.All(settingsGlobal))
+	if err != nil {
+		return nil, errors.Wrap(errInvalidLintInfo, err.Error())
+	}
+
+	client := action.NewLint()
+	client.Strict = lintInfo.Strict
+	client.Namespace = lintInfo.Namespace
+	client.WithSubcharts = lintInfo.WithSubcharts
+	paths := []string{path}
+	if client.WithSubcharts {
+		filepath.Walk(filepath.Join(path, "charts"), func(p string, info os.FileInfo, err error) error {
+			if err == nil && info.Name() == "Chart.yaml" {
+				paths = append(paths, filepath.Dir(p))
+			}
+			return nil
+		})
+	}
+	result := client.Run(paths, vals)
+
+	report := &lintReport{Passed: len(result.Errors) == 0, Findings: []lintFinding{}}
+	for _, msg := range result.Messages {
+		report.Findings = append(report.Findings, newLintFinding(msg))
+	}
+	if result.TotalChartsLinted < len(paths) {
+		// charts that couldn't be loaded at all have no messages, only errors
+		for _, err := range result.Errors {
+			report.Findings = append(report.Findings, lintFinding{Severity: "ERROR", Message: err.Error()})
+		}
+	}
+
+	if lintInfo.KubeVersion != "" && result.TotalChartsLinted > 0 {
+		finding, err := lintKubeVersion(path, lintInfo.KubeVersion)
+		if err != nil {
+			return nil, err
+		}
+		if finding != nil {
+			report.Findings = append(report.Findings, *finding)
+			report.Passed = false
+		}
+	}
+	return report, nil
+}
+
+func newLintFinding(msg support.Message) lintFinding {
+	finding := lintFinding{
+		Severity: lintSeverities[msg.Severity],
+		Path:     msg.Path,
+		Message:  msg.Err.Error(),
+	}
+	if m := lintTemplateLine.FindStringSubmatch(finding.Message); m != nil {
+		finding.Path = m[1]
+		finding.Line, _ = strconv.Atoi(m[2])
+	} else if m := lintYAMLLine.FindStringSubmatch(finding.Message); m != nil {
+		finding.Line, _ = strconv.Atoi(m[1])
+	}
+	return finding
+}
+
+// lintKubeVersion checks the kubeVersion constraint of the chart against kubeVersion.
+func lintKubeVersion(path string, kubeVersion string) (*lintFinding, error) {
+	if _, err := chartutil.ParseKubeVersion(kubeVersion); err != nil {
+		return nil, errors.Wrapf(errInvalidLintInfo, "invalid kube version '%s': %v", kubeVersion, err)
+	}
+	ch, err := loader.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	constraint := ch.Metadata.KubeVersion
+	if constraint == "" || chartutil.IsCompatibleRange(constraint, strings.TrimPrefix(kubeVersion, "v")) {
+		return nil, nil
+	}
+	return &lintFinding{
+		Severity: "ERROR",
+		Path:     "Chart.yaml",
+		Message:  "chart requires kubeVersion: " + constraint + " which is incompatible with Kubernetes " + kubeVersion,
+	}, nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/pkg/errors"
+
+	"helm.sh/helm/v3/pkg/lint/support"
+)
+
+func TestNewLintFinding(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  support.Message
+		want lintFinding
+	}{
+		{
+			"template execution",
+			support.NewMessage(support.ErrorSev, "templates/", errors.New(`template: mychart/templates/cm.yaml:1:13: executing "mychart/templates/cm.yaml" at <.Values.x.y.z>: nil pointer evaluating interface {}.y`)),
+			lintFinding{Severity: "ERROR", Path: "templates/cm.yaml", Line: 1},
+		},
+		{
+			"template parse",
+			support.NewMessage(support.ErrorSev, "templates/", errors.New("parse error at (mychart/templates/cm.yaml:2): unclosed action started at mychart/templates/cm.yaml:1")),
+			lintFinding{Severity: "ERROR", Path: "templates/cm.yaml", Line: 2},
+		},
+		{
+			"subchart template",
+			support.NewMessage(support.ErrorSev, "templates/", errors.New(`template: mychart/charts/db/templates/svc.yaml:7:3: executing "mychart/charts/db/templates/svc.yaml" at <fail "no">: error calling fail: no`)),
+			lintFinding{Severity: "ERROR", Path: "charts/db/templates/svc.yaml", Line: 7},
+		},
+		{
+			"rendered yaml",
+			support.NewMessage(support.ErrorSev, "templates/cm.yaml", errors.New("unable to parse YAML: error converting YAML to JSON: yaml: line 4: did not find expected key")),
+			lintFinding{Severity: "ERROR", Path: "templates/cm.yaml", Line: 4},
+		},
+		{
+			"values yaml",
+			support.NewMessage(support.ErrorSev, "values.yaml", errors.New("unable to parse YAML: error converting YAML to JSON: yaml: line 1: did not find expected node content")),
+			lintFinding{Severity: "ERROR", Path: "values.yaml", Line: 1},
+		},
+		{
+			"no line",
+			support.NewMessage(support.InfoSev, "Chart.yaml", errors.New("icon is recommended")),
+			lintFinding{Severity: "INFO", Path: "Chart.yaml"},
+		},
+		{
+			"warning",
+			support.NewMessage(support.WarningSev, "templates/", errors.New("directory not found")),
+			lintFinding{Severity: "WARNING", Path: "templates/"},
+		},
+	}
+	for _, tt := range tests {
+		tt.want.Message = tt.msg.Err.Error()
+		if got := newLintFinding(tt.msg); got != tt.want {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLint(t *testing.T) {
+	tempWorkspaces(t)
+	tempRepositories(t)
+	for name, content := range templateChartFiles {
+		if err := chartWorkspace.writeFile([]byte(content), "mychart", name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := chartWorkspace.writeFile([]byte("apiVersion: v2\nname: broken\nversion: 0.1.0\nkubeVersion: '>=1.25.0'\n"), "broken", "Chart.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	if err := chartWorkspace.writeFile([]byte("a: {{ .Values.x \n"), "broken", "templates", "cm.yaml"); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := lint("mychart", &LintInfo{KubeVersion: "v1.20.0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Passed {
+		t.Errorf("mychart failed: %+v", report.Findings)
+	}
+	report, err = lint("", &LintInfo{Archive: templateChartArchive(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Passed {
+		t.Errorf("mychart archive failed: %+v", report.Findings)
+	}
+
+	report, err = lint("broken", &LintInfo{KubeVersion: "v1.20.0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Passed {
+		t.Error("broken chart passed")
+	}
+	var parse, kube bool
+	for _, f := range report.Findings {
+		switch {
+		case f.Severity == "ERROR" && f.Path == "templates/cm.yaml" && f.Line == 2:
+			parse = true
+		case f.Severity == "ERROR" && f.Path == "Chart.yaml" && f.Message == "chart requires kubeVersion: >=1.25.0 which is incompatible with Kubernetes v1.20.0":
+			kube = true
+		}
+	}
+	if !parse || !kube {
+		t.Errorf("parse error %v, kubeVersion %v in %+v", parse, kube, report.Findings)
+	}
+}
+
+func TestLintRefused(t *testing.T) {
+	tempWorkspaces(t)
+	tempRepositories(t)
+	for name, content := range templateChartFiles {
+		if err := chartWorkspace.writeFile([]byte(content), "mychart", name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		name   string
+		chart  string
+		info   LintInfo
+		status int
+	}{
+		{"unknown chart", "missing", LintInfo{}, http.StatusNotFound},
+		{"chart outside the workspace", "../keyring", LintInfo{}, http.StatusBadRequest},
+		{"bad kube version", "mychart", LintInfo{KubeVersion: "latest"}, http.StatusBadRequest},
+		{"bad values", "mychart", LintInfo{Values: []string{"a={"}}, http.StatusBadRequest},
+		{"no archive", "", LintInfo{}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		_, err := lint(tt.chart, &tt.info)
+		if err == nil {
+			t.Errorf("%s: linted", tt.name)
+			continue
+		}
+		if status := workspaceStatus(err); status != tt.status {
+			t.Errorf("%s: %v, status %d, want %d", tt.name, err, status, tt.status)
+		}
+	}
+}
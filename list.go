@@ -17,10 +17,17 @@ limitations under the License.
 package main
 
 import (
+	"encoding/base64"
 	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
 
 	"helm.sh/helm/v3/pkg/action"
 	"helm.sh/helm/v3/pkg/release"
+	helmtime "helm.sh/helm/v3/pkg/time"
 )
 
 var listHelp = `
@@ -50,29 +57,229 @@ server's default, which may be much higher than 256. Pairing the '--max'
 flag with the '--offset' flag allows you to page through results.
 `
 
-func list(namespace string) ([]*release.Release, error) {
-	s, err := newSettings(namespace)
+// listOptions narrows, orders and pages the releases returned by list.
+type listOptions struct {
+	namespace string
+	statuses  []string
+	filter    string
+	selector  string
+	sortBy    string
+	reverse   bool
+	limit     int
+	offset    int
+	full      bool
+}
+
+// slim projection of a release
+type releaseSummary struct {
+	Name        string            `json:"name"`
+	Namespace   string            `json:"namespace"`
+	Revision    int               `json:"revision"`
+	Status      string            `json:"status"`
+	Chart       string            `json:"chart"`
+	AppVersion  string            `json:"app_version"`
+	Updated     helmtime.Time     `json:"updated"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// one page of releases
+type releaseList struct {
+	Items    []releaseSummary   `json:"items,omitempty" description:"releases in the slim projection"`
+	Releases []*release.Release `json:"releases,omitempty" description:"full releases, only when requested"`
+	Total    int                `json:"total" description:"number of releases matching the filters"`
+	Continue string             `json:"continue,omitempty" description:"token to fetch the next page"`
+}
+
+func list(o *listOptions) (*releaseList, error) {
+	s, err := newSettings(o.namespace)
 	if err != nil {
 		log.Println(err)
 		return nil, err
 	}
-	cfg, err := newConfig(namespace, s)
+	cfg, err := newConfig(o.namespace, s)
 	if err != nil {
 		log.Println(err)
 		return nil, err
 	}
 
 	client := action.NewList(cfg)
-	client.All = true
-	if namespace == "" {
+	if o.namespace == "" {
 		client.AllNamespaces = true
 	}
+	if err := setListStatuses(client, o.statuses); err != nil {
+		return nil, err
+	}
 	client.SetStateMask()
+	client.Filter = o.filter
+	client.Selector = o.selector
 
 	results, err := client.Run()
 	if err != nil {
 		log.Println(err)
 		return nil, err
 	}
-	return results, nil
+	if err := sortReleases(results, o.sortBy, o.reverse); err != nil {
+		return nil, err
+	}
+
+	page := &releaseList{Total: len(results)}
+	start, end := pageBounds(len(results), o.offset, o.limit)
+	if end < len(results) {
+		page.Continue = encodeContinue(end)
+	}
+	results = results[start:end]
+
+	if o.full {
+		page.Releases = results
+		return page, nil
+	}
+	st, err := newReleaseStorage(s)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	annotations, err := st.annotations(results)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	page.Items = []releaseSummary{}
+	for _, r := range results {
+		summary := summarizeRelease(r)
+		summary.Annotations = annotations[r.Namespace+"/"+storageKey(r.Name, r.Version)]
+		page.Items = append(page.Items, summary)
+	}
+	return page, nil
+}
+
+// setListStatuses maps status names onto the state flags of the list action.
+// Without any status every release is listed.
+func setListStatuses(client *action.List, statuses []string) error {
+	if len(statuses) == 0 {
+		client.All = true
+		return nil
+	}
+	for _, status := range statuses {
+		switch strings.ToLower(strings.TrimSpace(status)) {
+		case "all":
+			client.All = true
+		case "deployed":
+			client.Deployed = true
+		case "failed":
+			client.Failed = true
+		case "pending":
+			client.Pending = true
+		case "uninstalled":
+			client.Uninstalled = true
+		case "uninstalling":
+			client.Uninstalling = true
+		case "superseded":
+			client.Superseded = true
+		case "":
+		default:
+			return errors.Errorf("unknown release status %q", status)
+		}
+	}
+	return nil
+}
+
+func sortReleases(rels []*release.Release, sortBy string, reverse bool) error {
+	var less func(a, b *release.Release) bool
+	switch sortBy {
+	case "", "name":
+		less = func(a, b *release.Release) bool {
+			if a.Name == b.Name {
+				return a.Namespace < b.Namespace
+			}
+			return a.Name < b.Name
+		}
+	case "date":
+		less = func(a, b *release.Release) bool {
+			return lastDeployed(a).Before(lastDeployed(b))
+		}
+	case "chart":
+		less = func(a, b *release.Release) bool {
+			return formatChartname(a.Chart) < formatChartname(b.Chart)
+		}
+	default:
+		return errors.Errorf("cannot sort releases by %q", sortBy)
+	}
+	sort.SliceStable(rels, func(i, j int) bool {
+		if reverse {
+			return less(rels[j], rels[i])
+		}
+		return less(rels[i], rels[j])
+	})
+	return nil
+}
+
+// lastDeployed returns when a release was deployed, the zero time for a
+// release without info.
+func lastDeployed(r *release.Release) helmtime.Time {
+	if r.Info == nil {
+		return helmtime.Time{}
+	}
+	return r.Info.LastDeployed
+}
+
+func summarizeRelease(r *release.Release) releaseSummary {
+	summary := releaseSummary{
+		Name:       r.Name,
+		Namespace:  r.Namespace,
+		Revision:   r.Version,
+		Chart:      formatChartname(r.Chart),
+		AppVersion: formatAppVersion(r.Chart),
+		Labels:     userLabels(r.Labels),
+	}
+	if r.Info != nil {
+		summary.Status = r.Info.Status.String()
+		summary.Updated = r.Info.LastDeployed
+	}
+	return summary
+}
+
+// pageBounds returns the slice of n results that a page starting at offset,
+// with at most limit results, covers. A limit of 0 or less means no limit.
+func pageBounds(n int, offset int, limit int) (int, int) {
+	start := offset
+	if start < 0 {
+		start = 0
+	}
+	if start > n {
+		start = n
+	}
+	end := n
+	if limit > 0 && start+limit < end {
+		end = start + limit
+	}
+	return start, end
+}
+
+// parseCount parses the value of a paging query parameter, which must not be
+// negative.
+func parseCount(name string, value string) (int, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, errors.Errorf("invalid %s %q", name, value)
+	}
+	return n, nil
+}
+
+// encodeContinue turns the offset of the next page into an opaque token.
+func encodeContinue(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(offset)))
+}
+
+// decodeContinue is the inverse of encodeContinue.
+func decodeContinue(token string) (int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return 0, errors.New("invalid continue token")
+	}
+	offset, err := strconv.Atoi(string(b))
+	if err != nil || offset < 0 {
+		return 0, errors.New("invalid continue token")
+	}
+	return offset, nil
 }
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/release"
+	helmtime "helm.sh/helm/v3/pkg/time"
+)
+
+func TestPageBounds(t *testing.T) {
+	tests := []struct {
+		n, offset, limit int
+		start, end       int
+	}{
+		{10, 0, 0, 0, 10},
+		{10, 0, 3, 0, 3},
+		{10, 9, 3, 9, 10},
+		{10, 10, 3, 10, 10},
+		{10, 15, 3, 10, 10},
+		{10, -5, 3, 0, 3},
+		{10, 2, -1, 2, 10},
+		{0, 0, 5, 0, 0},
+	}
+	for _, tt := range tests {
+		start, end := pageBounds(tt.n, tt.offset, tt.limit)
+		if start != tt.start || end != tt.end {
+			t.Errorf("pageBounds(%d, %d, %d) = %d, %d, want %d, %d", tt.n, tt.offset, tt.limit, start, end, tt.start, tt.end)
+		}
+	}
+}
+
+// TestContinuePaging follows the continue tokens through all pages, as a
+// client of list does.
+func TestContinuePaging(t *testing.T) {
+	for _, limit := range []int{1, 3, 7, 10, 11} {
+		seen := 0
+		offset, pages := 0, 0
+		for {
+			start, end := pageBounds(10, offset, limit)
+			if start != seen {
+				t.Fatalf("limit %d: page starts at %d, want %d", limit, start, seen)
+			}
+			seen = end
+			pages++
+			if end == 10 {
+				break
+			}
+			var err error
+			if offset, err = decodeContinue(encodeContinue(end)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if want := (10 + limit - 1) / limit; pages != want {
+			t.Errorf("limit %d: %d pages, want %d", limit, pages, want)
+		}
+	}
+}
+
+func TestDecodeContinue(t *testing.T) {
+	if offset, err := decodeContinue(encodeContinue(42)); err != nil || offset != 42 {
+		t.Errorf("decodeContinue(encodeContinue(42)) = %d, %v", offset, err)
+	}
+	for _, token := range []string{
+		"not base64!",
+		base64.RawURLEncoding.EncodeToString([]byte("x")),
+		base64.RawURLEncoding.EncodeToString([]byte("-1")),
+		base64.RawURLEncoding.EncodeToString([]byte("")),
+	} {
+		if _, err := decodeContinue(token); err == nil {
+			t.Errorf("decodeContinue(%q) succeeded", token)
+		}
+	}
+}
+
+func TestParseCount(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int
+		ok    bool
+	}{
+		{"0", 0, true},
+		{"25", 25, true},
+		{"-1", 0, false},
+		{"ten", 0, false},
+		{"", 0, false},
+	}
+	for _, tt := range tests {
+		n, err := parseCount("limit", tt.value)
+		if (err == nil) != tt.ok || n != tt.want {
+			t.Errorf("parseCount(%q) = %d, %v", tt.value, n, err)
+		}
+	}
+}
+
+func TestSetListStatuses(t *testing.T) {
+	client := &action.List{}
+	if err := setListStatuses(client, []string{"Deployed", " failed "}); err != nil {
+		t.Fatal(err)
+	}
+	if !client.Deployed || !client.Failed || client.All || client.Pending {
+		t.Errorf("statuses = %+v", client)
+	}
+	if err := setListStatuses(&action.List{}, []string{"broken"}); err == nil {
+		t.Error("unknown status accepted")
+	}
+	client = &action.List{}
+	if err := setListStatuses(client, nil); err != nil || !client.All {
+		t.Errorf("no status = %v, all %v", err, client.All)
+	}
+}
+
+func TestSortReleases(t *testing.T) {
+	rels := []*release.Release{
+		{Name: "b", Namespace: "ns1"},
+		{Name: "a", Namespace: "ns2"},
+		{Name: "a", Namespace: "ns1"},
+	}
+	if err := sortReleases(rels, "", false); err != nil {
+		t.Fatal(err)
+	}
+	if rels[0].Namespace != "ns1" || rels[1].Namespace != "ns2" || rels[2].Name != "b" {
+		t.Errorf("sorted by name: %s/%s %s/%s %s/%s", rels[0].Namespace, rels[0].Name, rels[1].Namespace, rels[1].Name, rels[2].Namespace, rels[2].Name)
+	}
+	if err := sortReleases(rels, "name", true); err != nil || rels[0].Name != "b" {
+		t.Errorf("reverse sort: %v, first %s", err, rels[0].Name)
+	}
+
+	// releases without info sort as deployed at the zero time
+	now := helmtime.Now()
+	rels = []*release.Release{
+		{Name: "new", Info: &release.Info{LastDeployed: now}},
+		{Name: "no-info"},
+		{Name: "old", Info: &release.Info{LastDeployed: now.Add(-time.Hour)}},
+	}
+	if err := sortReleases(rels, "date", false); err != nil {
+		t.Fatal(err)
+	}
+	if rels[0].Name != "no-info" || rels[1].Name != "old" || rels[2].Name != "new" {
+		t.Errorf("sorted by date: %s %s %s", rels[0].Name, rels[1].Name, rels[2].Name)
+	}
+	if err := sortReleases(rels, "size", false); err == nil {
+		t.Error("unknown sort key accepted")
+	}
+}
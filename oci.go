@@ -0,0 +1,265 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Media types of the chart layer in an OCI artifact, the first one written by
+// helm 3.7 and later, the second one by the experimental support of earlier
+// versions.
+var ociChartLayerMediaTypes = []string{
+	"application/vnd.cncf.helm.chart.content.v1.tar+gzip",
+	"application/tar+gzip",
+}
+
+// ociHTTPClient talks to the registries. A registry that stops answering
+// fails the request instead of holding it.
+var ociHTTPClient = &http.Client{Timeout: 5 * time.Minute}
+
+// a chart in an OCI registry, oci://<registry>/<repository>:<tag>
+type ociReference struct {
+	registry   string
+	repository string
+	tag        string
+}
+
+func (r *ociReference) String() string {
+	return fmt.Sprintf("oci://%s/%s:%s", r.registry, r.repository, r.tag)
+}
+
+// parseOCIReference parses an oci:// reference; version takes the place of a
+// missing tag.
+func parseOCIReference(ref string, version string) (*ociReference, error) {
+	rest := strings.TrimPrefix(ref, "oci://")
+	slash := strings.Index(rest, "/")
+	if slash <= 0 || slash == len(rest)-1 {
+		return nil, errors.Errorf("invalid OCI reference %q", ref)
+	}
+	r := &ociReference{registry: rest[:slash], repository: rest[slash+1:]}
+	if colon := strings.LastIndex(r.repository, ":"); colon != -1 {
+		r.tag = r.repository[colon+1:]
+		r.repository = r.repository[:colon]
+	}
+	if version != "" {
+		if r.tag != "" && r.tag != version {
+			return nil, errors.Errorf("OCI reference %q doesn't match version %q", ref, version)
+		}
+		r.tag = version
+	}
+	if r.tag == "" {
+		return nil, errors.Errorf("OCI reference %q needs a tag or a version", ref)
+	}
+	// semver build metadata isn't allowed in tags, helm pushes it as _
+	r.tag = strings.Replace(r.tag, "+", "_", -1)
+	return r, nil
+}
+
+// ociClient pulls charts from a registry with the distribution API.
+type ociClient struct {
+	username  string
+	password  string
+	plainHTTP bool
+	client    *http.Client
+	token     string
+}
+
+// pull returns the chart archive of ref.
+func (c *ociClient) pull(ref *ociReference) ([]byte, error) {
+	scheme := "https"
+	if c.plainHTTP {
+		scheme = "http"
+	}
+	base := fmt.Sprintf("%s://%s/v2/%s", scheme, ref.registry, ref.repository)
+
+	manifestData, err := c.get(base+"/manifests/"+ref.tag, "application/vnd.oci.image.manifest.v1+json")
+	if err != nil {
+		return nil, err
+	}
+	manifest := struct {
+		Layers []struct {
+			MediaType string `json:"mediaType"`
+			Digest    string `json:"digest"`
+		} `json:"layers"`
+	}{}
+	if err := json.Unmarshal(manifestData, &manifest); err != nil {
+		return nil, errors.Wrapf(err, "invalid manifest of %s", ref)
+	}
+	for _, mediaType := range ociChartLayerMediaTypes {
+		for _, layer := range manifest.Layers {
+			if layer.MediaType != mediaType {
+				continue
+			}
+			data, err := c.get(base+"/blobs/"+layer.Digest, "")
+			if err != nil {
+				return nil, err
+			}
+			sum := sha256.Sum256(data)
+			if "sha256:"+hex.EncodeToString(sum[:]) != layer.Digest {
+				return nil, errors.Errorf("digest of the chart layer of %s doesn't match", ref)
+			}
+			return data, nil
+		}
+	}
+	return nil, errors.Errorf("%s is not a helm chart", ref)
+}
+
+// get fetches a url of the registry, authenticating as the registry asks.
+// Responses are read up to maxUploadSize bytes.
+func (c *ociClient) get(u string, accept string) ([]byte, error) {
+	if c.client == nil {
+		c.client = ociHTTPClient
+	}
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequest(http.MethodGet, u, nil)
+		if err != nil {
+			return nil, err
+		}
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		if c.token != "" {
+			req.Header.Set("Authorization", "Bearer "+c.token)
+		} else if c.username != "" {
+			req.SetBasicAuth(c.username, c.password)
+		}
+		resp, err := c.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		data, err := readRegistryResponse(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to fetch %s", u)
+		}
+		if resp.StatusCode == http.StatusUnauthorized && attempt == 0 {
+			if err := c.authorize(resp.Header.Get("WWW-Authenticate")); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, errors.Errorf("failed to fetch %s: %s", u, resp.Status)
+		}
+		return data, nil
+	}
+}
+
+// authorize gets a token for a bearer challenge of the registry. Basic
+// challenges are answered by sending the credentials.
+func (c *ociClient) authorize(challenge string) error {
+	if !strings.HasPrefix(challenge, "Bearer ") {
+		if c.username == "" {
+			return errors.New("the registry requires credentials")
+		}
+		return nil
+	}
+	params := challengeParams(strings.TrimPrefix(challenge, "Bearer "))
+	realm, err := url.Parse(params["realm"])
+	if err != nil || params["realm"] == "" {
+		return errors.Errorf("invalid authentication challenge %q", challenge)
+	}
+	query := realm.Query()
+	for _, key := range []string{"service", "scope"} {
+		if params[key] != "" {
+			query.Set(key, params[key])
+		}
+	}
+	realm.RawQuery = query.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return err
+	}
+	if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("failed to authenticate with the registry: %s", resp.Status)
+	}
+	token := struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}{}
+	data, err := readRegistryResponse(resp.Body)
+	if err != nil {
+		return errors.Wrap(err, "failed to authenticate with the registry")
+	}
+	if err := json.Unmarshal(data, &token); err != nil {
+		return errors.Wrap(err, "failed to authenticate with the registry")
+	}
+	c.token = token.Token
+	if c.token == "" {
+		c.token = token.AccessToken
+	}
+	if c.token == "" {
+		return errors.New("the registry returned no token")
+	}
+	return nil
+}
+
+// challengeParams parses the parameters of an authentication challenge,
+// key=value or key="quoted value" separated by commas (RFC 7235). Quoted
+// values may hold commas and backslash escapes.
+func challengeParams(s string) map[string]string {
+	params := map[string]string{}
+	for {
+		s = strings.TrimLeft(s, " \t,")
+		eq := strings.Index(s, "=")
+		if eq <= 0 {
+			return params
+		}
+		key := strings.ToLower(strings.TrimSpace(s[:eq]))
+		s = strings.TrimLeft(s[eq+1:], " \t")
+		var value strings.Builder
+		if strings.HasPrefix(s, `"`) {
+			i := 1
+			for ; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+				value.WriteByte(s[i])
+			}
+			if i < len(s) {
+				i++
+			}
+			s = s[i:]
+		} else {
+			end := strings.IndexAny(s, ", \t")
+			if end == -1 {
+				end = len(s)
+			}
+			value.WriteString(s[:end])
+			s = s[end:]
+		}
+		params[key] = value.String()
+	}
+}
+
+// readRegistryResponse reads the body of a registry response, failing for
+// more than maxUploadSize bytes.
+func readRegistryResponse(body io.Reader) ([]byte, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(body, maxUploadSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxUploadSize {
+		return nil, errors.Wrapf(errUploadTooLarge, "the limit is %d bytes", maxUploadSize)
+	}
+	return data, nil
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// fakeRegistry is a stand-in for an OCI registry with token authentication,
+// serving the manifests and blobs the distribution API pull of a chart needs.
+type fakeRegistry struct {
+	mu        sync.Mutex
+	manifests map[string][]byte // by repository:tag
+	blobs     map[string][]byte // by digest
+	// scope the token endpoint was asked for
+	scope string
+	delay time.Duration
+}
+
+const fakeRegistryToken = "secret-token"
+
+func newFakeRegistry(t *testing.T) (*fakeRegistry, *httptest.Server) {
+	t.Helper()
+	f := &fakeRegistry{manifests: map[string][]byte{}, blobs: map[string][]byte{}}
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		time.Sleep(f.delay)
+		if r.URL.Path == "/token" {
+			if user, password, ok := r.BasicAuth(); !ok || user != "user" || password != "pass" {
+				http.Error(w, "bad credentials", http.StatusUnauthorized)
+				return
+			}
+			f.scope = r.URL.Query().Get("scope")
+			json.NewEncoder(w).Encode(map[string]string{"token": fakeRegistryToken})
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer "+fakeRegistryToken {
+			// the scope holds a comma, which a naive parser splits
+			w.Header().Set("WWW-Authenticate", `Bearer realm="`+srv.URL+`/token",service="registry",scope="repository:charts/mychart:pull,push"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		path := strings.TrimPrefix(r.URL.Path, "/v2/")
+		if i := strings.Index(path, "/manifests/"); i != -1 {
+			manifest, ok := f.manifests[path[:i]+":"+path[i+len("/manifests/"):]]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write(manifest)
+			return
+		}
+		if i := strings.Index(path, "/blobs/"); i != -1 {
+			blob, ok := f.blobs[path[i+len("/blobs/"):]]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write(blob)
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return f, srv
+}
+
+// push stores a chart layer of mediaType as repository:tag, under digest when
+// that isn't empty instead of the digest of the layer.
+func (f *fakeRegistry) push(repository string, tag string, mediaType string, layer []byte, digest string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if digest == "" {
+		sum := sha256.Sum256(layer)
+		digest = "sha256:" + hex.EncodeToString(sum[:])
+	}
+	f.blobs[digest] = layer
+	manifest, _ := json.Marshal(map[string]interface{}{
+		"schemaVersion": 2,
+		"layers":        []map[string]string{{"mediaType": mediaType, "digest": digest}},
+	})
+	f.manifests[repository+":"+tag] = manifest
+}
+
+func TestParseOCIReference(t *testing.T) {
+	tests := []struct {
+		ref     string
+		version string
+		want    string
+		err     bool
+	}{
+		{"oci://registry.example.com/charts/mychart:0.1.0", "", "oci://registry.example.com/charts/mychart:0.1.0", false},
+		{"oci://registry.example.com/charts/mychart", "0.1.0", "oci://registry.example.com/charts/mychart:0.1.0", false},
+		{"oci://localhost:5000/mychart:0.1.0", "0.1.0", "oci://localhost:5000/mychart:0.1.0", false},
+		{"oci://registry.example.com/mychart:1.0.0+build.1", "", "oci://registry.example.com/mychart:1.0.0_build.1", false},
+		{"oci://registry.example.com/mychart", "", "", true},
+		{"oci://registry.example.com/mychart:0.1.0", "0.2.0", "", true},
+		{"oci://registry.example.com/", "0.1.0", "", true},
+		{"oci:///mychart:0.1.0", "", "", true},
+		{"oci://registry.example.com", "0.1.0", "", true},
+	}
+	for _, tt := range tests {
+		ref, err := parseOCIReference(tt.ref, tt.version)
+		if tt.err {
+			if err == nil {
+				t.Errorf("parseOCIReference(%q, %q) = %s, want error", tt.ref, tt.version, ref)
+			}
+			continue
+		}
+		if err != nil || ref.String() != tt.want {
+			t.Errorf("parseOCIReference(%q, %q) = %v, %v, want %s", tt.ref, tt.version, ref, err, tt.want)
+		}
+	}
+}
+
+func TestChallengeParams(t *testing.T) {
+	tests := []struct {
+		challenge string
+		want      map[string]string
+	}{
+		{`realm="https://auth.example.com/token",service="registry",scope="repository:a/b:pull,push"`, map[string]string{
+			"realm": "https://auth.example.com/token", "service": "registry", "scope": "repository:a/b:pull,push",
+		}},
+		{`realm="https://auth.example.com/token", Service=registry, scope="repository:a/b:pull"`, map[string]string{
+			"realm": "https://auth.example.com/token", "service": "registry", "scope": "repository:a/b:pull",
+		}},
+		{`realm="a\"b",error="invalid_token"`, map[string]string{"realm": `a"b`, "error": "invalid_token"}},
+		{`realm="unterminated`, map[string]string{"realm": "unterminated"}},
+		{``, map[string]string{}},
+	}
+	for _, tt := range tests {
+		if got := challengeParams(tt.challenge); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("challengeParams(%q) = %v, want %v", tt.challenge, got, tt.want)
+		}
+	}
+}
+
+func registryReference(t *testing.T, srv *httptest.Server, repository string, tag string) *ociReference {
+	t.Helper()
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref, err := parseOCIReference("oci://"+u.Host+"/"+repository, tag)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ref
+}
+
+func TestOCIPull(t *testing.T) {
+	registry, srv := newFakeRegistry(t)
+	archive := chartArchive(t, map[string]string{"mychart/Chart.yaml": "apiVersion: v2\nname: mychart\nversion: 0.1.0\n"})
+	registry.push("charts/mychart", "0.1.0", ociChartLayerMediaTypes[0], archive, "")
+	registry.push("charts/mychart", "0.0.1", ociChartLayerMediaTypes[1], archive, "")
+	registry.push("charts/mychart", "tampered", ociChartLayerMediaTypes[0], archive, "sha256:"+strings.Repeat("0", 64))
+	registry.push("charts/image", "latest", "application/vnd.oci.image.layer.v1.tar+gzip", archive, "")
+
+	for _, tag := range []string{"0.1.0", "0.0.1"} {
+		client := &ociClient{username: "user", password: "pass", plainHTTP: true}
+		data, err := client.pull(registryReference(t, srv, "charts/mychart", tag))
+		if err != nil {
+			t.Errorf("pull %s: %v", tag, err)
+			continue
+		}
+		if string(data) != string(archive) {
+			t.Errorf("pull %s: the archive differs", tag)
+		}
+	}
+	if registry.scope != "repository:charts/mychart:pull,push" {
+		t.Errorf("token scope = %q", registry.scope)
+	}
+
+	tests := []struct {
+		name       string
+		client     *ociClient
+		repository string
+		tag        string
+		err        string
+	}{
+		{"wrong credentials", &ociClient{username: "user", password: "wrong", plainHTTP: true}, "charts/mychart", "0.1.0", "failed to authenticate"},
+		{"no credentials", &ociClient{plainHTTP: true}, "charts/mychart", "0.1.0", "failed to authenticate"},
+		{"missing tag", &ociClient{username: "user", password: "pass", plainHTTP: true}, "charts/mychart", "9.9.9", "404"},
+		{"digest mismatch", &ociClient{username: "user", password: "pass", plainHTTP: true}, "charts/mychart", "tampered", "doesn't match"},
+		{"not a chart", &ociClient{username: "user", password: "pass", plainHTTP: true}, "charts/image", "latest", "not a helm chart"},
+	}
+	for _, tt := range tests {
+		if _, err := tt.client.pull(registryReference(t, srv, tt.repository, tt.tag)); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestOCIPullLimits(t *testing.T) {
+	registry, srv := newFakeRegistry(t)
+	registry.push("charts/big", "1.0.0", ociChartLayerMediaTypes[0], []byte(strings.Repeat("x", 4<<10)), "")
+
+	limit := maxUploadSize
+	maxUploadSize = 1 << 10
+	defer func() { maxUploadSize = limit }()
+	client := &ociClient{username: "user", password: "pass", plainHTTP: true}
+	if _, err := client.pull(registryReference(t, srv, "charts/big", "1.0.0")); !errors.Is(err, errUploadTooLarge) {
+		t.Errorf("pull of a blob over the limit = %v, want errUploadTooLarge", err)
+	}
+
+	registry.mu.Lock()
+	registry.delay = 200 * time.Millisecond
+	registry.mu.Unlock()
+	client = &ociClient{username: "user", password: "pass", plainHTTP: true, client: &http.Client{Timeout: 50 * time.Millisecond}}
+	if _, err := client.pull(registryReference(t, srv, "charts/big", "1.0.0")); err == nil || !strings.Contains(err.Error(), "Client.Timeout") {
+		t.Errorf("pull from a slow registry = %v, want a timeout", err)
+	}
+}
@@ -17,16 +17,23 @@ limitations under the License.
 package main
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
 
+	"github.com/Masterminds/semver/v3"
+	"github.com/pkg/errors"
+
 	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chart/loader"
 	"helm.sh/helm/v3/pkg/cli/values"
-	"helm.sh/helm/v3/pkg/downloader"
 	"helm.sh/helm/v3/pkg/getter"
+	"helm.sh/helm/v3/pkg/provenance"
 )
 
 const packageDesc = `
@@ -45,60 +52,154 @@ If '--keyring' is not specified, Helm usually defaults to the public keyring
 unless your environment is otherwise configured.
 `
 
-func packageChart(chartName string) error {
-	chartDir := ".helm/charts"
-	// Ensure the chart directory exists
-	err := os.MkdirAll(chartDir, os.ModePerm)
-	if err != nil && !os.IsExist(err) {
+// keyring and signingKey sign packages when asked to; passphraseFile holds
+// the passphrase of an encrypted key.
+var (
+	keyring        string
+	signingKey     string
+	passphraseFile string
+)
+
+// information of a package request
+type PackageInfo struct {
+	Version          string `json:"version" description:"version of the package instead of the one in Chart.yaml" default:"string"`
+	AppVersion       string `json:"app_version" description:"app version of the package instead of the one in Chart.yaml" default:"string"`
+	DependencyUpdate bool   `json:"dependency_update" description:"update the dependencies of the chart before packaging" default:"false"`
+	Sign             bool   `json:"sign" description:"sign the package with the keyring of the server" default:"false"`
+	Key              string `json:"key" description:"name of the key to sign with, the default key of the server when empty" default:"string"`
+}
+
+// result of packaging a chart
+type packageResult struct {
+	Chart      string `json:"chart" description:"name of the chart in the workspace"`
+	Name       string `json:"name" description:"name of the chart in its Chart.yaml"`
+	Version    string `json:"version"`
+	AppVersion string `json:"app_version,omitempty"`
+	Package    string `json:"package" description:"file name of the package"`
+	Path       string `json:"path" description:"path to download the package from"`
+	Digest     string `json:"digest" description:"sha256 of the package"`
+	Provenance string `json:"provenance,omitempty" description:"path to download the provenance file of a signed package from"`
+	SignedBy   string `json:"signed_by,omitempty" description:"identity of the key the package is signed with"`
+}
+
+func packageChart(chartName string, packageInfo *PackageInfo) (*packageResult, error) {
+	if packageInfo.Version != "" {
+		if _, err := semver.NewVersion(packageInfo.Version); err != nil {
+			return nil, errors.Wrapf(errInvalidMetadata, "version %q is not a semantic version", packageInfo.Version)
+		}
+	}
+	if packageInfo.Sign && keyring == "" {
+		return nil, errors.New("the server has no keyring to sign with, see --keyring")
+	}
+	if packageInfo.DependencyUpdate {
+		if _, err := updateChartDependencies(chartName, false, false); err != nil {
+			return nil, err
+		}
+	}
+	chartPath, chartDone, err := checkoutChart(chartName)
+	if err != nil {
 		log.Println(err)
-		return err
+		return nil, err
 	}
-	chartPackgeDir := ".helm/chart-package"
-	// Ensure the chart package directory exists
-	err = os.MkdirAll(chartPackgeDir, os.ModePerm)
-	if err != nil && !os.IsExist(err) {
+	defer chartDone(false)
+	destination, done, err := packageWorkspace.checkout(chartName)
+	if err != nil {
 		log.Println(err)
-		return err
+		return nil, err
 	}
-	chartPath := fmt.Sprintf("%s/%s", chartDir, chartName)
+	committed := false
+	defer func() {
+		if !committed {
+			done(false)
+		}
+	}()
 	client := action.NewPackage()
-	client.Destination = fmt.Sprintf("%s/%s", chartPackgeDir, chartName)
+	client.Destination = destination
+	client.Version = packageInfo.Version
+	client.AppVersion = packageInfo.AppVersion
 	valueOpts := &values.Options{}
 	client.RepositoryConfig = settingsGlobal.RepositoryConfig
 	client.RepositoryCache = settingsGlobal.RepositoryCache
 	p := getter.All(settingsGlobal)
 	vals, err := valueOpts.MergeValues(p)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	path, err := filepath.Abs(chartPath)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	if _, err := os.Stat(chartPath); err != nil {
-		return err
+	if _, err := chartWorkspace.stat(chartName); err != nil {
+		return nil, err
 	}
 
-	if client.DependencyUpdate {
-		downloadManager := &downloader.Manager{
-			Out:              ioutil.Discard,
-			ChartPath:        path,
-			Keyring:          client.Keyring,
-			Getters:          p,
-			Debug:            settingsGlobal.Debug,
-			RepositoryConfig: settingsGlobal.RepositoryConfig,
-			RepositoryCache:  settingsGlobal.RepositoryCache,
+	packagePath, err := client.Run(path, vals)
+	if err != nil {
+		return nil, err
+	}
+	result := &packageResult{Chart: chartName, Package: filepath.Base(packagePath)}
+	result.Path = fmt.Sprintf("/helm/package/%s/%s", chartName, result.Package)
+	if packageInfo.Sign {
+		if result.SignedBy, err = signPackage(packagePath, packageInfo.Key); err != nil {
+			os.Remove(packagePath)
+			return nil, err
 		}
+		result.Provenance = result.Path + ".prov"
+	} else if err := os.Remove(packagePath + ".prov"); err != nil && !os.IsNotExist(err) {
+		// a provenance file of an earlier package of the same version doesn't fit
+		return nil, err
+	}
+	archive, err := ioutil.ReadFile(packagePath)
+	if err != nil {
+		return nil, err
+	}
+	ch, err := loader.LoadArchive(bytes.NewReader(archive))
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(archive)
+	result.Digest = "sha256:" + hex.EncodeToString(sum[:])
+	result.Name = ch.Metadata.Name
+	result.Version = ch.Metadata.Version
+	result.AppVersion = ch.Metadata.AppVersion
+	committed = true
+	if err := done(true); err != nil {
+		return nil, err
+	}
+	out := os.Stdout
+	fmt.Fprintf(out, "Successfully packaged chart and saved it to: %s\n", packagePath)
+	return result, nil
+}
 
-		if err := downloadManager.Update(); err != nil {
-			return err
+// signPackage writes the provenance file of a package, signed with key from
+// the keyring of the server, and returns the identity of the key.
+func signPackage(archive string, key string) (string, error) {
+	if key == "" {
+		key = signingKey
+	}
+	signer, err := provenance.NewFromKeyring(keyring, key)
+	if err != nil {
+		return "", err
+	}
+	err = signer.DecryptKey(func(name string) ([]byte, error) {
+		if passphraseFile == "" {
+			return nil, errors.Errorf("key %q is encrypted, but the server has no passphrase for it, see --passphrase-file", name)
 		}
+		passphrase, err := ioutil.ReadFile(passphraseFile)
+		if err != nil {
+			return nil, err
+		}
+		return bytes.TrimRight(passphrase, "\r\n"), nil
+	})
+	if err != nil {
+		return "", err
 	}
-	packageInfo, err := client.Run(path, vals)
+	sig, err := signer.ClearSign(archive)
 	if err != nil {
-		return err
+		return "", err
 	}
-	out := os.Stdout
-	fmt.Fprintf(out, "Successfully packaged chart and saved it to: %s\n", packageInfo)
-	return nil
+	if err := ioutil.WriteFile(archive+".prov", []byte(sig), 0644); err != nil {
+		return "", err
+	}
+	return primaryIdentity(signer.Entity), nil
 }
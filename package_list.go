@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/pkg/errors"
+
+	"helm.sh/helm/v3/pkg/chart/loader"
+)
+
+// uploadsLock serializes changes of the upload records of the charts. It only
+// covers this replica, concurrent uploads of other replicas may lose records.
+var uploadsLock sync.Mutex
+
+// a package of a workspace chart, as read from the archive
+type chartPackage struct {
+	Package    string           `json:"package" description:"file name of the package"`
+	Name       string           `json:"name,omitempty"`
+	Version    string           `json:"version,omitempty"`
+	AppVersion string           `json:"app_version,omitempty"`
+	Created    time.Time        `json:"created"`
+	Size       int64            `json:"size"`
+	Digest     string           `json:"digest" description:"sha256 of the package"`
+	Signed     bool             `json:"signed" description:"there is a provenance file for the package"`
+	Uploads    []*packageUpload `json:"uploads" description:"repositories the package has been uploaded to"`
+	Error      string           `json:"error,omitempty" description:"why the archive couldn't be read"`
+}
+
+// an upload of a package to a repository
+type packageUpload struct {
+	Repo       string    `json:"repo"`
+	Digest     string    `json:"digest" description:"sha256 of the package uploaded"`
+	UploadedAt time.Time `json:"uploaded_at"`
+}
+
+// listPackages reads the packages of a workspace chart, newest version first.
+// Packages that aren't valid chart archives are listed with the error, after
+// the others.
+func listPackages(chartName string) ([]*chartPackage, error) {
+	if err := validName(chartName); err != nil {
+		return nil, err
+	}
+	files, err := packageWorkspace.readDir(chartName)
+	if err != nil {
+		return nil, err
+	}
+	uploads, err := readUploads(chartName)
+	if err != nil {
+		return nil, err
+	}
+	names := map[string]bool{}
+	for _, file := range files {
+		names[file.Name()] = true
+	}
+	packages := []*chartPackage{}
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".tgz") {
+			continue
+		}
+		p := &chartPackage{
+			Package: file.Name(),
+			Created: file.ModTime(),
+			Size:    file.Size(),
+			Signed:  names[file.Name()+".prov"],
+			Uploads: []*packageUpload{},
+		}
+		archive, err := packageWorkspace.readFile(chartName, file.Name())
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(archive)
+		p.Digest = "sha256:" + hex.EncodeToString(sum[:])
+		// uploads of an earlier package with the same name don't count
+		for _, u := range uploads[file.Name()] {
+			if u.Digest == p.Digest {
+				p.Uploads = append(p.Uploads, u)
+			}
+		}
+		ch, err := loader.LoadArchive(bytes.NewReader(archive))
+		if err != nil {
+			p.Error = err.Error()
+		} else {
+			p.Name = ch.Metadata.Name
+			p.Version = ch.Metadata.Version
+			p.AppVersion = ch.Metadata.AppVersion
+		}
+		packages = append(packages, p)
+	}
+	sort.SliceStable(packages, func(i, j int) bool {
+		return newerPackage(packages[i], packages[j])
+	})
+	return packages, nil
+}
+
+// newerPackage orders packages by semantic version, newest first. Versions
+// that aren't semantic come after, by package name.
+func newerPackage(a *chartPackage, b *chartPackage) bool {
+	va, errA := semver.NewVersion(a.Version)
+	vb, errB := semver.NewVersion(b.Version)
+	switch {
+	case errA == nil && errB == nil && !va.Equal(vb):
+		return va.GreaterThan(vb)
+	case errA == nil && errB != nil:
+		return true
+	case errA != nil && errB == nil:
+		return false
+	}
+	return a.Package < b.Package
+}
+
+// readUploads returns the uploads recorded for the packages of a chart, by
+// package name.
+func readUploads(chartName string) (map[string][]*packageUpload, error) {
+	uploads := map[string][]*packageUpload{}
+	data, err := metaWorkspace.readFile(chartName, "uploads.json")
+	if os.IsNotExist(errors.Cause(err)) {
+		return uploads, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &uploads); err != nil {
+		return nil, err
+	}
+	return uploads, nil
+}
+
+// recordUpload records that a package has been uploaded to a repository,
+// replacing an earlier upload of it there.
+func recordUpload(chartName string, chartPackage string, repoName string, archive []byte) error {
+	uploadsLock.Lock()
+	defer uploadsLock.Unlock()
+	uploads, err := readUploads(chartName)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(archive)
+	kept := []*packageUpload{}
+	for _, u := range uploads[chartPackage] {
+		if u.Repo != repoName {
+			kept = append(kept, u)
+		}
+	}
+	uploads[chartPackage] = append(kept, &packageUpload{
+		Repo:       repoName,
+		Digest:     "sha256:" + hex.EncodeToString(sum[:]),
+		UploadedAt: time.Now(),
+	})
+	data, err := json.MarshalIndent(uploads, "", "  ")
+	if err != nil {
+		return err
+	}
+	return metaWorkspace.writeFile(data, chartName, "uploads.json")
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+)
+
+func TestListPackages(t *testing.T) {
+	tempWorkspaces(t)
+	archives := map[string][]byte{}
+	for _, version := range []string{"0.1.0", "1.0.0", "1.0.0-rc.1"} {
+		archive := chartArchive(t, map[string]string{
+			"mychart/Chart.yaml": "apiVersion: v2\nname: mychart\nversion: " + version + "\nappVersion: \"" + version + "\"\n",
+		})
+		name := "mychart-" + version + ".tgz"
+		archives[name] = archive
+		if err := packageWorkspace.writeFile(archive, "mychart", name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := packageWorkspace.writeFile([]byte("signature"), "mychart", "mychart-1.0.0.tgz.prov"); err != nil {
+		t.Fatal(err)
+	}
+	if err := packageWorkspace.writeFile([]byte("not an archive"), "mychart", "broken.tgz"); err != nil {
+		t.Fatal(err)
+	}
+	uploads := []struct {
+		pkg     string
+		repo    string
+		archive []byte
+	}{
+		{"mychart-1.0.0.tgz", "stable", archives["mychart-1.0.0.tgz"]},
+		{"mychart-1.0.0.tgz", "staging", []byte("an earlier package of the same name")},
+		{"mychart-1.0.0.tgz", "staging", archives["mychart-1.0.0.tgz"]},
+		{"mychart-0.1.0.tgz", "stable", []byte("an earlier package of the same name")},
+	}
+	for _, u := range uploads {
+		if err := recordUpload("mychart", u.pkg, u.repo, u.archive); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	packages, err := listPackages("mychart")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		pkg     string
+		version string
+		signed  bool
+		repos   []string
+	}{
+		{"mychart-1.0.0.tgz", "1.0.0", true, []string{"stable", "staging"}},
+		{"mychart-1.0.0-rc.1.tgz", "1.0.0-rc.1", false, nil},
+		{"mychart-0.1.0.tgz", "0.1.0", false, nil},
+		{"broken.tgz", "", false, nil},
+	}
+	if len(packages) != len(want) {
+		t.Fatalf("got %d packages, want %d", len(packages), len(want))
+	}
+	for i, w := range want {
+		p := packages[i]
+		if p.Package != w.pkg || p.Version != w.version || p.Signed != w.signed {
+			t.Errorf("%d: got %+v, want %s %s signed %v", i, p, w.pkg, w.version, w.signed)
+			continue
+		}
+		if archive, ok := archives[p.Package]; ok {
+			sum := sha256.Sum256(archive)
+			digest := "sha256:" + hex.EncodeToString(sum[:])
+			if p.Name != "mychart" || p.AppVersion != w.version || p.Digest != digest || p.Size != int64(len(archive)) || p.Error != "" {
+				t.Errorf("%s: got %+v", p.Package, p)
+			}
+		} else if p.Error == "" {
+			t.Errorf("%s: no error", p.Package)
+		}
+		if len(p.Uploads) != len(w.repos) {
+			t.Errorf("%s: got %d uploads, want %v", p.Package, len(p.Uploads), w.repos)
+			continue
+		}
+		for j, repo := range w.repos {
+			if p.Uploads[j].Repo != repo || p.Uploads[j].Digest != p.Digest {
+				t.Errorf("%s: upload %d %+v, want %s with %s", p.Package, j, p.Uploads[j], repo, p.Digest)
+			}
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+// packageTestChart writes templateChartFiles as the workspace chart mychart.
+func packageTestChart(t *testing.T) {
+	t.Helper()
+	tempWorkspaces(t)
+	tempRepositories(t)
+	for name, content := range templateChartFiles {
+		if err := chartWorkspace.writeFile([]byte(content), "mychart", name); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestPackageChart(t *testing.T) {
+	packageTestChart(t)
+	// left by an earlier signed package of the same version
+	if err := packageWorkspace.writeFile([]byte("stale"), "mychart", "mychart-0.1.0.tgz.prov"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		info       PackageInfo
+		pkg        string
+		version    string
+		appVersion string
+	}{
+		{"Chart.yaml version", PackageInfo{}, "mychart-0.1.0.tgz", "0.1.0", ""},
+		{"version override", PackageInfo{Version: "1.0.0-rc.1", AppVersion: "2.0"}, "mychart-1.0.0-rc.1.tgz", "1.0.0-rc.1", "2.0"},
+	}
+	for _, tt := range tests {
+		result, err := packageChart("mychart", &tt.info)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if result.Package != tt.pkg || result.Name != "mychart" || result.Version != tt.version || result.AppVersion != tt.appVersion {
+			t.Errorf("%s: got %+v", tt.name, result)
+		}
+		if result.Path != "/helm/package/mychart/"+tt.pkg || result.Provenance != "" || result.SignedBy != "" {
+			t.Errorf("%s: got %+v", tt.name, result)
+		}
+		archive, err := packageWorkspace.readFile("mychart", tt.pkg)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		sum := sha256.Sum256(archive)
+		if want := "sha256:" + hex.EncodeToString(sum[:]); result.Digest != want {
+			t.Errorf("%s: digest %s, want %s", tt.name, result.Digest, want)
+		}
+	}
+	if _, err := packageWorkspace.stat("mychart", "mychart-0.1.0.tgz.prov"); !os.IsNotExist(err) {
+		t.Errorf("stale provenance file of an unsigned package: %v", err)
+	}
+
+	if _, err := packageChart("mychart", &PackageInfo{Version: "next"}); !errors.Is(err, errInvalidMetadata) {
+		t.Errorf("non-semver version: %v", err)
+	}
+	if _, err := packageChart("mychart", &PackageInfo{Sign: true}); err == nil {
+		t.Error("signed without a keyring")
+	}
+	if _, err := packageChart("missing", &PackageInfo{}); !os.IsNotExist(errors.Cause(err)) {
+		t.Errorf("unknown chart: %v", err)
+	}
+}
+
+func TestPackageChartSigned(t *testing.T) {
+	packageTestChart(t)
+	defer func(k, s string) { keyring, signingKey = k, s }(keyring, signingKey)
+	// a throwaway key without passphrase
+	keyring, signingKey = "testdata/helm-rest-test.secring.gpg", "helm-rest test"
+
+	result, err := packageChart("mychart", &PackageInfo{Sign: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.SignedBy != "helm-rest test <test@example.com>" || result.Provenance != "/helm/package/mychart/mychart-0.1.0.tgz.prov" {
+		t.Errorf("got %+v", result)
+	}
+	if _, err := packageWorkspace.stat("mychart", "mychart-0.1.0.tgz.prov"); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := ioutil.ReadFile(keyring)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := addTrustedKeys(keys); err != nil {
+		t.Fatal(err)
+	}
+	v, err := verifyPackage(&VerifyInfo{Chart: "mychart", Package: result.Package})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !v.Verified || v.FileHash != result.Digest {
+		t.Errorf("got %+v, want verified with %s", v, result.Digest)
+	}
+
+	if _, err := packageChart("mychart", &PackageInfo{Sign: true, Key: "nobody"}); err == nil {
+		t.Error("signed with an unknown key")
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/metadata"
+
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/cli"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage"
+	"helm.sh/helm/v3/pkg/storage/driver"
+)
+
+// Labels helm itself sets on the storage objects of a release. They can't be
+// overridden and are not reported as release labels.
+var reservedReleaseLabels = map[string]bool{
+	"name":       true,
+	"owner":      true,
+	"status":     true,
+	"version":    true,
+	"createdAt":  true,
+	"modifiedAt": true,
+}
+
+// errReleaseMetadataNotStored tells that a release was deployed, but storing
+// its labels and annotations failed: a partial success.
+var errReleaseMetadataNotStored = errors.New("the release is deployed, but its labels and annotations weren't stored")
+
+// releaseMetadata holds the labels and annotations attached to a release revision.
+type releaseMetadata struct {
+	Labels      map[string]string
+	Annotations map[string]string
+}
+
+func (m *releaseMetadata) empty() bool {
+	return len(m.Labels) == 0 && len(m.Annotations) == 0
+}
+
+// merge overlays the labels and annotations of other on top of m.
+func (m *releaseMetadata) merge(other *releaseMetadata) *releaseMetadata {
+	merged := &releaseMetadata{Labels: map[string]string{}, Annotations: map[string]string{}}
+	for _, src := range []*releaseMetadata{m, other} {
+		if src == nil {
+			continue
+		}
+		for k, v := range src.Labels {
+			merged.Labels[k] = v
+		}
+		for k, v := range src.Annotations {
+			merged.Annotations[k] = v
+		}
+	}
+	return merged
+}
+
+func validateReleaseMetadata(m *releaseMetadata) error {
+	for k, v := range m.Labels {
+		if reservedReleaseLabels[k] {
+			return errors.Errorf("label %q is reserved by helm", k)
+		}
+		if errs := validation.IsQualifiedName(k); len(errs) > 0 {
+			return errors.Errorf("invalid label key %q: %s", k, strings.Join(errs, "; "))
+		}
+		if errs := validation.IsValidLabelValue(v); len(errs) > 0 {
+			return errors.Errorf("invalid value for label %q: %s", k, strings.Join(errs, "; "))
+		}
+	}
+	for k := range m.Annotations {
+		if errs := validation.IsQualifiedName(k); len(errs) > 0 {
+			return errors.Errorf("invalid annotation key %q: %s", k, strings.Join(errs, "; "))
+		}
+	}
+	return nil
+}
+
+// userLabels drops the labels helm maintains itself.
+func userLabels(labels map[string]string) map[string]string {
+	res := map[string]string{}
+	for k, v := range labels {
+		if !reservedReleaseLabels[k] {
+			res[k] = v
+		}
+	}
+	return res
+}
+
+// releaseStorage gives access to the kubernetes objects the storage driver
+// keeps the release revisions in.
+type releaseStorage struct {
+	clientset kubernetes.Interface
+	// metadata reads the storage objects without the release payloads
+	metadata metadata.Interface
+	driver   string
+}
+
+func newReleaseStorage(settings *cli.EnvSettings) (*releaseStorage, error) {
+	config, err := settings.RESTClientGetter().ToRESTConfig()
+	if err != nil {
+		return nil, err
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	metadataClient, err := metadata.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	st := &releaseStorage{clientset: clientset, metadata: metadataClient}
+	switch os.Getenv("HELM_DRIVER") {
+	case "secret", "secrets", "":
+		st.driver = "secret"
+	case "configmap", "configmaps":
+		st.driver = "configmap"
+	default:
+		st.driver = os.Getenv("HELM_DRIVER")
+	}
+	return st, nil
+}
+
+func (st *releaseStorage) supported() bool {
+	return st.driver == "secret" || st.driver == "configmap"
+}
+
+func storageKey(name string, version int) string {
+	return fmt.Sprintf("%s.%s.v%d", storage.HelmStorageType, name, version)
+}
+
+// get reads the user labels and annotations of a release revision.
+func (st *releaseStorage) get(namespace string, name string, version int) (*releaseMetadata, error) {
+	if !st.supported() {
+		return &releaseMetadata{}, nil
+	}
+	var meta metav1.ObjectMeta
+	ctx := context.Background()
+	if st.driver == "secret" {
+		obj, err := st.clientset.CoreV1().Secrets(namespace).Get(ctx, storageKey(name, version), metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		meta = obj.ObjectMeta
+	} else {
+		obj, err := st.clientset.CoreV1().ConfigMaps(namespace).Get(ctx, storageKey(name, version), metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		meta = obj.ObjectMeta
+	}
+	return &releaseMetadata{Labels: userLabels(meta.Labels), Annotations: meta.Annotations}, nil
+}
+
+// annotations returns the annotations of the storage objects of releases,
+// keyed by namespace and storage object name. Only the metadata of the objects
+// is read, not the releases they hold.
+func (st *releaseStorage) annotations(releases []*release.Release) (map[string]map[string]string, error) {
+	res := map[string]map[string]string{}
+	if !st.supported() || len(releases) == 0 {
+		return res, nil
+	}
+	resource := schema.GroupVersionResource{Version: "v1", Resource: "secrets"}
+	if st.driver == "configmap" {
+		resource.Resource = "configmaps"
+	}
+	names := map[string]map[string]bool{}
+	for _, r := range releases {
+		if names[r.Namespace] == nil {
+			names[r.Namespace] = map[string]bool{}
+		}
+		names[r.Namespace][r.Name] = true
+	}
+	ctx := context.Background()
+	for namespace, byName := range names {
+		list := []string{}
+		for name := range byName {
+			list = append(list, name)
+		}
+		sort.Strings(list)
+		opts := metav1.ListOptions{LabelSelector: fmt.Sprintf("owner=helm,name in (%s)", strings.Join(list, ","))}
+		objs, err := st.metadata.Resource(resource).Namespace(namespace).List(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range objs.Items {
+			if len(obj.Annotations) > 0 {
+				res[obj.Namespace+"/"+obj.Name] = obj.Annotations
+			}
+		}
+	}
+	return res, nil
+}
+
+// apply merges the labels and annotations into the storage object of rel. Its
+// errors are errReleaseMetadataNotStored, rel is there all the same.
+func (st *releaseStorage) apply(rel *release.Release, m *releaseMetadata) error {
+	if m == nil || m.empty() {
+		return nil
+	}
+	if !st.supported() {
+		return errors.Wrapf(errReleaseMetadataNotStored, "release labels are not supported by the %q storage driver", st.driver)
+	}
+	patch := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"labels":      m.Labels,
+			"annotations": m.Annotations,
+		},
+	}
+	data, err := json.Marshal(patch)
+	if err != nil {
+		return errors.Wrap(errReleaseMetadataNotStored, err.Error())
+	}
+	ctx := context.Background()
+	key := storageKey(rel.Name, rel.Version)
+	if st.driver == "secret" {
+		_, err = st.clientset.CoreV1().Secrets(rel.Namespace).Patch(ctx, key, types.MergePatchType, data, metav1.PatchOptions{})
+	} else {
+		_, err = st.clientset.CoreV1().ConfigMaps(rel.Namespace).Patch(ctx, key, types.MergePatchType, data, metav1.PatchOptions{})
+	}
+	if err != nil {
+		return errors.Wrapf(errReleaseMetadataNotStored, "failed to store labels of release %q: %v", rel.Name, err)
+	}
+	for k, v := range m.Labels {
+		if rel.Labels == nil {
+			rel.Labels = map[string]string{}
+		}
+		rel.Labels[k] = v
+	}
+	return nil
+}
+
+// previousReleaseMetadata reads the labels and annotations of the latest
+// revision of a release, if there is one.
+func previousReleaseMetadata(cfg *action.Configuration, st *releaseStorage, namespace string, name string) (*releaseMetadata, error) {
+	last, err := cfg.Releases.Last(name)
+	if err != nil {
+		if errors.Is(err, driver.ErrReleaseNotFound) {
+			return &releaseMetadata{}, nil
+		}
+		return nil, err
+	}
+	meta, err := st.get(namespace, name, last.Version)
+	if apierrors.IsNotFound(err) {
+		return &releaseMetadata{}, nil
+	}
+	return meta, err
+}
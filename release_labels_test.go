@@ -0,0 +1,163 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	restful "github.com/emicklei/go-restful/v3"
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	metadatafake "k8s.io/client-go/metadata/fake"
+
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+	fakekube "helm.sh/helm/v3/pkg/kube/fake"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	helmtime "helm.sh/helm/v3/pkg/time"
+)
+
+func storageObject(namespace string, name string, version int, annotations map[string]string) runtime.Object {
+	return &metav1.PartialObjectMetadata{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   namespace,
+			Name:        storageKey(name, version),
+			Labels:      map[string]string{"owner": "helm", "name": name},
+			Annotations: annotations,
+		},
+	}
+}
+
+func TestReleaseStorageAnnotations(t *testing.T) {
+	scheme := runtime.NewScheme()
+	metav1.AddMetaToScheme(scheme)
+	client := metadatafake.NewSimpleMetadataClient(scheme,
+		storageObject("ns1", "a", 1, map[string]string{"team": "x"}),
+		storageObject("ns1", "a", 2, map[string]string{"team": "y"}),
+		storageObject("ns1", "b", 1, map[string]string{"team": "z"}),
+		storageObject("ns1", "off-page", 1, map[string]string{"team": "w"}),
+		storageObject("ns2", "a", 1, map[string]string{"team": "v"}),
+	)
+	st := &releaseStorage{metadata: client, driver: "secret"}
+
+	page := []*release.Release{
+		{Name: "a", Namespace: "ns1", Version: 2},
+		{Name: "b", Namespace: "ns1", Version: 1},
+		{Name: "a", Namespace: "ns2", Version: 1},
+	}
+	got, err := st.annotations(page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]map[string]string{
+		"ns1/sh.helm.release.v1.a.v1": {"team": "x"},
+		"ns1/sh.helm.release.v1.a.v2": {"team": "y"},
+		"ns1/sh.helm.release.v1.b.v1": {"team": "z"},
+		"ns2/sh.helm.release.v1.a.v1": {"team": "v"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("annotations = %v, want %v", got, want)
+	}
+
+	got, err = st.annotations(nil)
+	if err != nil || len(got) != 0 {
+		t.Errorf("annotations of an empty page = %v, %v", got, err)
+	}
+}
+
+func TestMetadataWarning(t *testing.T) {
+	rel := &release.Release{Name: "a"}
+	partial := errors.Wrap(errReleaseMetadataNotStored, "forbidden")
+	tests := []struct {
+		rel     *release.Release
+		err     error
+		remains bool
+		warning bool
+	}{
+		{rel, nil, false, false},
+		{rel, partial, false, true},
+		{nil, partial, true, false},
+		{rel, errors.New("UPGRADE FAILED"), true, false},
+	}
+	for _, tt := range tests {
+		recorder := httptest.NewRecorder()
+		resp := restful.NewResponse(recorder)
+		err := metadataWarning(resp, tt.rel, tt.err)
+		if (err != nil) != tt.remains {
+			t.Errorf("metadataWarning(%v) = %v", tt.err, err)
+		}
+		if warning := recorder.Header().Get("Warning"); (warning != "") != tt.warning {
+			t.Errorf("metadataWarning(%v) set Warning %q", tt.err, warning)
+		}
+	}
+}
+
+// secretsReleaseConfig stores releases in secrets of a fake cluster, the way
+// the default storage driver does, and deploys to nowhere.
+func secretsReleaseConfig(t *testing.T) (*action.Configuration, *releaseStorage) {
+	t.Helper()
+	clientset := kubefake.NewSimpleClientset()
+	cfg := &action.Configuration{
+		Releases:     storage.Init(driver.NewSecrets(clientset.CoreV1().Secrets("default"))),
+		KubeClient:   &fakekube.PrintingKubeClient{Out: ioutil.Discard},
+		Capabilities: chartutil.DefaultCapabilities,
+		Log:          func(string, ...interface{}) {},
+	}
+	return cfg, &releaseStorage{clientset: clientset, driver: "secret"}
+}
+
+func storeTestRelease(t *testing.T, cfg *action.Configuration, name string, version int, status release.Status) *release.Release {
+	t.Helper()
+	rel := &release.Release{
+		Name:      name,
+		Namespace: "default",
+		Version:   version,
+		Info:      &release.Info{Status: status, LastDeployed: helmtime.Now()},
+		Chart:     &chart.Chart{Metadata: &chart.Metadata{APIVersion: "v2", Name: "mychart", Version: "0.1.0"}},
+	}
+	if err := cfg.Releases.Create(rel); err != nil {
+		t.Fatal(err)
+	}
+	return rel
+}
+
+func TestLabelsSurviveRollback(t *testing.T) {
+	cfg, st := secretsReleaseConfig(t)
+	storeTestRelease(t, cfg, "myrelease", 1, release.StatusSuperseded)
+	current := storeTestRelease(t, cfg, "myrelease", 2, release.StatusDeployed)
+	labels := &releaseMetadata{Labels: map[string]string{"team": "web"}, Annotations: map[string]string{"owner": "alice"}}
+	if err := st.apply(current, labels); err != nil {
+		t.Fatal(err)
+	}
+
+	rel, err := rollbackRelease(cfg, st, &RollbackInfo{Name: "myrelease", Namespace: "default", Version: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rel.Version != 3 || rel.Labels["team"] != "web" {
+		t.Errorf("rolled back to revision %d with labels %v", rel.Version, rel.Labels)
+	}
+	meta, err := st.get("default", "myrelease", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Labels["team"] != "web" || meta.Annotations["owner"] != "alice" {
+		t.Errorf("labels of the new revision = %v, annotations %v", meta.Labels, meta.Annotations)
+	}
+
+	// the driver rewrote the revision rolled back from when superseding it
+	meta, err = st.get("default", "myrelease", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(meta.Labels) != 0 || len(meta.Annotations) != 0 {
+		t.Errorf("superseded revision kept labels %v, annotations %v; update the API docs", meta.Labels, meta.Annotations)
+	}
+}
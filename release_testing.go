@@ -0,0 +1,265 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/release"
+	helmtime "helm.sh/helm/v3/pkg/time"
+)
+
+const releaseTestHelp = `
+The test command runs the tests for a release.
+
+The argument this command takes is the name of a deployed release.
+The tests to be run are defined in the chart that was installed.
+`
+
+// information of a test run request
+type TestInfo struct {
+	Name      string   `json:"name" description:"name of release" default:"string"`
+	Namespace string   `json:"namespace" description:"namespace of release" default:"string"`
+	Timeout   string   `json:"timeout" description:"time to wait for any individual kubernetes operation" default:"5m0s"`
+	Filter    []string `json:"filter" description:"names of the tests to run, prefix a name with ! to skip it" default:"[]"`
+	Logs      bool     `json:"logs" description:"include the logs of the test pods" default:"false"`
+}
+
+// phase of a test hook that has never run
+const testNotRun = "NotRun"
+
+// result of one test hook
+type testHookResult struct {
+	Name        string        `json:"name"`
+	Kind        string        `json:"kind"`
+	Phase       string        `json:"phase" description:"Running, Succeeded, Failed, Unknown or NotRun"`
+	Skipped     bool          `json:"skipped,omitempty"`
+	StartedAt   helmtime.Time `json:"started_at"`
+	CompletedAt helmtime.Time `json:"completed_at"`
+	Logs        string        `json:"logs,omitempty"`
+}
+
+// result of running the tests of a release
+type testRun struct {
+	Release   string           `json:"release"`
+	Namespace string           `json:"namespace"`
+	Revision  int              `json:"revision"`
+	Passed    bool             `json:"passed"`
+	Error     string           `json:"error,omitempty"`
+	Tests     []testHookResult `json:"tests"`
+}
+
+// testRuns keeps the latest test run of every release, including pod logs,
+// so results can be fetched after the request that ran them.
+var testRuns = struct {
+	sync.Mutex
+	runs map[string]*testRun
+}{runs: map[string]*testRun{}}
+
+func releaseTest(testInfo *TestInfo) (*testRun, error) {
+	s, err := newSettings(testInfo.Namespace)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	cfg, err := newConfig(testInfo.Namespace, s)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	st, err := newReleaseStorage(s)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	// the test results are written back to the storage object of the release
+	meta, err := previousReleaseMetadata(cfg, st, testInfo.Namespace, testInfo.Name)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	client := action.NewReleaseTesting(cfg)
+	client.Namespace = testInfo.Namespace
+	client.Timeout = 300 * time.Second
+	if testInfo.Timeout != "" {
+		client.Timeout, err = time.ParseDuration(testInfo.Timeout)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid timeout")
+		}
+	}
+	for _, f := range testInfo.Filter {
+		if strings.HasPrefix(f, "!") {
+			client.Filters["!name"] = append(client.Filters["!name"], strings.TrimPrefix(f, "!"))
+		} else {
+			client.Filters["name"] = append(client.Filters["name"], f)
+		}
+	}
+
+	rel, runErr := client.Run(testInfo.Name)
+	if rel == nil {
+		log.Println(runErr)
+		return nil, runErr
+	}
+	if err := st.apply(rel, meta); err != nil {
+		log.Println(err)
+	}
+
+	run := &testRun{
+		Release:   rel.Name,
+		Namespace: rel.Namespace,
+		Revision:  rel.Version,
+		Passed:    runErr == nil,
+		Tests:     []testHookResult{},
+	}
+	if runErr != nil {
+		run.Error = runErr.Error()
+	}
+	for _, h := range testHooks(rel) {
+		result := hookResult(h)
+		result.Skipped = skippedTest(client.Filters, h.Name)
+		if testInfo.Logs && !result.Skipped && h.Kind == "Pod" {
+			result.Logs, err = testPodLogs(cfg, testInfo.Namespace, h.Name)
+			if err != nil {
+				log.Println(err)
+				result.Logs = err.Error()
+			}
+		}
+		run.Tests = append(run.Tests, result)
+	}
+
+	testRuns.Lock()
+	testRuns.runs[rel.Namespace+"/"+rel.Name] = run
+	testRuns.Unlock()
+	return run, nil
+}
+
+// lastTestRun returns the results of the latest test run of a release. Runs
+// made by another server instance are rebuilt from the hooks of the release,
+// without pod logs.
+func lastTestRun(releaseName string, namespace string) (*testRun, error) {
+	testRuns.Lock()
+	run, ok := testRuns.runs[namespace+"/"+releaseName]
+	testRuns.Unlock()
+	if ok {
+		return run, nil
+	}
+
+	rel, err := getAll(releaseName, namespace)
+	if err != nil {
+		return nil, err
+	}
+	return hooksTestRun(rel), nil
+}
+
+// hooksTestRun rebuilds the latest test run of a release from the last runs of
+// its test hooks.
+func hooksTestRun(rel *release.Release) *testRun {
+	run := &testRun{
+		Release:   rel.Name,
+		Namespace: rel.Namespace,
+		Revision:  rel.Version,
+		Passed:    true,
+		Tests:     []testHookResult{},
+	}
+	ran := false
+	for _, h := range testHooks(rel) {
+		result := hookResult(h)
+		switch h.LastRun.Phase {
+		case "":
+			// filtered out or never tested, that isn't a failure
+		case release.HookPhaseSucceeded:
+			ran = true
+		default:
+			ran = true
+			run.Passed = false
+		}
+		run.Tests = append(run.Tests, result)
+	}
+	if !ran {
+		run.Passed = false
+		run.Error = "the tests of the release haven't been run"
+	}
+	return run
+}
+
+func testHooks(rel *release.Release) []*release.Hook {
+	var hooks []*release.Hook
+	for _, h := range rel.Hooks {
+		if isTestHook(h) {
+			hooks = append(hooks, h)
+		}
+	}
+	return hooks
+}
+
+func hookResult(h *release.Hook) testHookResult {
+	phase := h.LastRun.Phase.String()
+	if phase == "" {
+		phase = testNotRun
+	}
+	return testHookResult{
+		Name:        h.Name,
+		Kind:        h.Kind,
+		Phase:       phase,
+		StartedAt:   h.LastRun.StartedAt,
+		CompletedAt: h.LastRun.CompletedAt,
+	}
+}
+
+func skippedTest(filters map[string][]string, name string) bool {
+	for _, n := range filters["!name"] {
+		if n == name {
+			return true
+		}
+	}
+	if len(filters["name"]) == 0 {
+		return false
+	}
+	for _, n := range filters["name"] {
+		if n == name {
+			return false
+		}
+	}
+	return true
+}
+
+func testPodLogs(cfg *action.Configuration, namespace string, pod string) (string, error) {
+	client, err := cfg.KubernetesClientSet()
+	if err != nil {
+		return "", errors.Wrap(err, "unable to get kubernetes client to fetch pod logs")
+	}
+	logReader, err := client.CoreV1().Pods(namespace).GetLogs(pod, &v1.PodLogOptions{}).Stream(context.Background())
+	if err != nil {
+		return "", errors.Wrapf(err, "unable to get pod logs for %s", pod)
+	}
+	defer logReader.Close()
+	logs, err := ioutil.ReadAll(logReader)
+	if err != nil {
+		return "", errors.Wrapf(err, "unable to read pod logs for %s", pod)
+	}
+	return string(logs), nil
+}
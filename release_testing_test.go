@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+
+	"helm.sh/helm/v3/pkg/release"
+)
+
+func testHook(name string, phase release.HookPhase) *release.Hook {
+	return &release.Hook{
+		Name:    name,
+		Kind:    "Pod",
+		Events:  []release.HookEvent{release.HookTest},
+		LastRun: release.HookExecution{Phase: phase},
+	}
+}
+
+func TestHooksTestRun(t *testing.T) {
+	tests := []struct {
+		name   string
+		hooks  []*release.Hook
+		ran    bool
+		passed bool
+		phases []string
+	}{
+		{"never run", []*release.Hook{testHook("a", ""), testHook("b", "")}, false, false, []string{testNotRun, testNotRun}},
+		{"passed", []*release.Hook{testHook("a", release.HookPhaseSucceeded), testHook("b", "")}, true, true, []string{"Succeeded", testNotRun}},
+		{"failed", []*release.Hook{testHook("a", release.HookPhaseSucceeded), testHook("b", release.HookPhaseFailed)}, true, false, []string{"Succeeded", "Failed"}},
+		{"no tests", nil, false, false, nil},
+	}
+	for _, tt := range tests {
+		rel := &release.Release{Name: "myrelease", Namespace: "default", Version: 3, Hooks: tt.hooks}
+		run := hooksTestRun(rel)
+		if run.Passed != tt.passed {
+			t.Errorf("%s: passed = %v, want %v", tt.name, run.Passed, tt.passed)
+		}
+		if (run.Error == "") != tt.ran {
+			t.Errorf("%s: error = %q", tt.name, run.Error)
+		}
+		if len(run.Tests) != len(tt.phases) {
+			t.Fatalf("%s: %d tests, want %d", tt.name, len(run.Tests), len(tt.phases))
+		}
+		for i, result := range run.Tests {
+			if result.Phase != tt.phases[i] {
+				t.Errorf("%s: phase of %s = %q, want %q", tt.name, result.Name, result.Phase, tt.phases[i])
+			}
+		}
+	}
+}
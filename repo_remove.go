@@ -56,6 +56,9 @@ func removeRepo(names []string) error {
 		if err := removeRepoCache(o.repoCache, name); err != nil {
 			return err
 		}
+		if err := setVerifiedOnly(name, false); err != nil {
+			return err
+		}
 		fmt.Fprintf(out, "%q has been removed from your repositories\n", name)
 	}
 
@@ -18,8 +18,14 @@ package main
 
 import (
 	"log"
+	"time"
+
+	"github.com/pkg/errors"
 
 	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/releaseutil"
+	"helm.sh/helm/v3/pkg/storage/driver"
 )
 
 const rollbackDesc = `
@@ -32,25 +38,98 @@ roll back to the previous release.
 To see revision numbers, run 'helm history RELEASE'.
 `
 
-func rollback(releaseInfo *ReleaseInfo) error {
-	s, err := newSettings(releaseInfo.Namespace)
+func rollback(rollbackInfo *RollbackInfo) (*release.Release, error) {
+	s, err := newSettings(rollbackInfo.Namespace)
 	if err != nil {
 		log.Println(err)
-		return err
+		return nil, err
 	}
-	cfg, err := newConfig(releaseInfo.Namespace, s)
+	cfg, err := newConfig(rollbackInfo.Namespace, s)
 	if err != nil {
 		log.Println(err)
-		return err
+		return nil, err
+	}
+
+	st, err := newReleaseStorage(s)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	return rollbackRelease(cfg, st, rollbackInfo)
+}
+
+// rollbackRelease rolls a release back and carries its labels and annotations
+// over to the new revision.
+func rollbackRelease(cfg *action.Configuration, st *releaseStorage, rollbackInfo *RollbackInfo) (*release.Release, error) {
+	meta, err := previousReleaseMetadata(cfg, st, rollbackInfo.Namespace, rollbackInfo.Name)
+	if err != nil {
+		log.Println(err)
+		return nil, err
 	}
 
 	client := action.NewRollback(cfg)
-	client.Version = releaseInfo.Version
+	client.Version = rollbackInfo.Version
+	client.Wait = rollbackInfo.Wait
+	client.WaitForJobs = rollbackInfo.WaitForJobs
+	client.Force = rollbackInfo.Force
+	client.Recreate = rollbackInfo.Recreate
+	client.CleanupOnFail = rollbackInfo.CleanupOnFail
+	client.DisableHooks = rollbackInfo.DisableHooks
+	client.MaxHistory = rollbackInfo.MaxHistory
+	client.Timeout = 300 * time.Second
+	if rollbackInfo.Timeout != "" {
+		client.Timeout, err = time.ParseDuration(rollbackInfo.Timeout)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid timeout")
+		}
+	}
+
+	if rollbackInfo.LastDeployed {
+		client.Version, err = lastDeployedRevision(cfg, rollbackInfo.Name)
+		if err != nil {
+			log.Println(err)
+			return nil, err
+		}
+	}
 
-	if err := client.Run(releaseInfo.Name); err != nil {
+	if err := client.Run(rollbackInfo.Name); err != nil {
 		log.Println(err)
-		return err
+		return nil, err
 	}
 
-	return nil
+	// the rollback is stored as a new revision, keep the labels of the release on it
+	rel, err := cfg.Releases.Last(rollbackInfo.Name)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	if err := st.apply(rel, meta); err != nil {
+		log.Println(err)
+		return rel, err
+	}
+
+	return rel, nil
+}
+
+// lastDeployedRevision finds the latest revision before the current one that was
+// deployed successfully, skipping failed and pending revisions.
+func lastDeployedRevision(cfg *action.Configuration, name string) (int, error) {
+	hist, err := cfg.Releases.History(name)
+	if err != nil {
+		return 0, err
+	}
+	if len(hist) == 0 {
+		return 0, driver.ErrReleaseNotFound
+	}
+	releaseutil.Reverse(hist, releaseutil.SortByRevision)
+	for _, r := range hist[1:] {
+		if r.Info == nil {
+			continue
+		}
+		switch r.Info.Status {
+		case release.StatusDeployed, release.StatusSuperseded:
+			return r.Version, nil
+		}
+	}
+	return 0, errors.Errorf("release %q has no successfully deployed revision to roll back to", name)
 }
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage"
+	"helm.sh/helm/v3/pkg/storage/driver"
+)
+
+func TestLastDeployedRevision(t *testing.T) {
+	cfg := &action.Configuration{Releases: storage.Init(driver.NewMemory())}
+	revisions := []*release.Info{
+		{Status: release.StatusSuperseded},
+		{Status: release.StatusSuperseded},
+		{Status: release.StatusFailed},
+		{Status: release.StatusFailed},
+		{Status: release.StatusDeployed},
+	}
+	for i, info := range revisions {
+		rel := &release.Release{Name: "myrelease", Namespace: "default", Version: i + 1, Info: info}
+		if err := cfg.Releases.Create(rel); err != nil {
+			t.Fatal(err)
+		}
+		// the memory driver keeps the release, a record without info is read back as such
+		if i == 2 {
+			rel.Info = nil
+		}
+	}
+	version, err := lastDeployedRevision(cfg, "myrelease")
+	if err != nil || version != 2 {
+		t.Errorf("lastDeployedRevision = %d, %v, want 2", version, err)
+	}
+
+	if _, err := lastDeployedRevision(cfg, "missing"); err == nil {
+		t.Error("lastDeployedRevision of a missing release succeeded")
+	}
+}
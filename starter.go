@@ -0,0 +1,154 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"helm.sh/helm/v3/pkg/chart/loader"
+)
+
+// starterWorkspace keeps the starters new charts can be created from. Like
+// with helm create --starter, the name of the new chart replaces <CHARTNAME>
+// in the files of the starter.
+var starterWorkspace = &workspace{store: &localStore{root: ".helm/starters"}}
+
+// defaultStarter is used by create when no starter is asked for.
+var defaultStarter string
+
+// checkoutStarter returns a local directory with a starter, see
+// workspaceStore.checkout.
+func checkoutStarter(name string) (string, func(save bool) error, error) {
+	if err := validName(name); err != nil {
+		return "", nil, err
+	}
+	if _, err := starterWorkspace.stat(name); err != nil {
+		return "", nil, errors.Wrapf(err, "starter %q", name)
+	}
+	return starterWorkspace.checkout(name)
+}
+
+func listStarters() ([]string, error) {
+	infos, err := starterWorkspace.readDir()
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, info := range infos {
+		if info.IsDir() {
+			names = append(names, info.Name())
+		}
+	}
+	return names, nil
+}
+
+// starterFiles lists the files of a starter, relative to it.
+func starterFiles(name string) ([]string, error) {
+	if err := validName(name); err != nil {
+		return nil, err
+	}
+	files := []string{}
+	err := starterWorkspace.walk(func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			files = append(files, strings.TrimPrefix(file, name+"/"))
+		}
+		return nil
+	}, name)
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// uploadStarter stores an uploaded chart archive or zip as a starter.
+func uploadStarter(name string, data []byte, overwrite bool) error {
+	files, err := archiveFiles(data)
+	if err != nil {
+		return err
+	}
+	if _, err := loader.LoadFiles(files); err != nil {
+		return invalidArchive(err)
+	}
+	return replaceTree(starterWorkspace, name, files, overwrite)
+}
+
+// promoteChart makes a starter of a workspace chart. With placeholder set,
+// the chart name is replaced by <CHARTNAME> in the name of Chart.yaml and in
+// the names of the named templates, so charts created from the starter get
+// their own names there. Other occurrences of the name are kept.
+func promoteChart(chartName string, starterName string, placeholder bool, overwrite bool) error {
+	if err := validName(starterName); err != nil {
+		return err
+	}
+	_, files, err := loadWorkspaceChart(chartName)
+	if err != nil {
+		return err
+	}
+	if placeholder {
+		for _, f := range files {
+			f.Data = placeholderName(f.Name, f.Data, chartName)
+		}
+	}
+	return replaceTree(starterWorkspace, starterName, files, overwrite)
+}
+
+// placeholderName replaces the chart name by <CHARTNAME> in a file of a chart:
+// the name field of Chart.yaml, and in templates the names given to define,
+// template and include, like "mychart.fullname".
+func placeholderName(file string, data []byte, chartName string) []byte {
+	name := regexp.QuoteMeta(chartName)
+	switch {
+	case file == "Chart.yaml":
+		field := regexp.MustCompile(`(?m)^(name:[ \t]*["']?)` + name + `(["']?[ \t]*(?:#.*)?)$`)
+		return field.ReplaceAll(data, []byte("${1}<CHARTNAME>${2}"))
+	case strings.HasPrefix(file, "templates/"):
+		template := regexp.MustCompile(`((?:define|template|include)\s+")` + name + `([."])`)
+		return template.ReplaceAll(data, []byte("${1}<CHARTNAME>${2}"))
+	}
+	return data
+}
+
+func removeStarter(name string) error {
+	if err := validName(name); err != nil {
+		return err
+	}
+	if _, err := starterWorkspace.stat(name); err != nil {
+		return err
+	}
+	return starterWorkspace.removeAll(name)
+}
+
+// replaceTree writes files below name in a workspace, replacing what was there
+// before only with overwrite set.
+func replaceTree(w *workspace, name string, files []*loader.BufferedFile, overwrite bool) error {
+	if err := validName(name); err != nil {
+		return err
+	}
+	if _, err := w.stat(name); err == nil {
+		if !overwrite {
+			return errors.Wrapf(errAlreadyExists, "%q", name)
+		}
+		if err := w.removeAll(name); err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(errors.Cause(err)) {
+		return err
+	}
+	for _, file := range files {
+		if err := w.writeFile(file.Data, name, file.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// starterDir splits a checked out starter into the options of createOptions.
+func starterDir(dir string) (starterDir string, starter string) {
+	return filepath.Dir(dir), filepath.Base(dir)
+}
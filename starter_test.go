@@ -0,0 +1,26 @@
+package main
+
+import "testing"
+
+func TestPlaceholderName(t *testing.T) {
+	tests := []struct {
+		file string
+		data string
+		want string
+	}{
+		{"Chart.yaml", "apiVersion: v2\nname: web\ndescription: the web chart\n", "apiVersion: v2\nname: <CHARTNAME>\ndescription: the web chart\n"},
+		{"Chart.yaml", "name: \"web\" # the name\n", "name: \"<CHARTNAME>\" # the name\n"},
+		{"Chart.yaml", "name: webapp\n", "name: webapp\n"},
+		{"templates/_helpers.tpl", `{{- define "web.fullname" -}}`, `{{- define "<CHARTNAME>.fullname" -}}`},
+		{"templates/deployment.yaml", `name: {{ include "web.fullname" . }}`, `name: {{ include "<CHARTNAME>.fullname" . }}`},
+		{"templates/service.yaml", `{{ template "web" . }}`, `{{ template "<CHARTNAME>" . }}`},
+		{"templates/deployment.yaml", "image: nginx/web:1.0\nport: web\n", "image: nginx/web:1.0\nport: web\n"},
+		{"templates/deployment.yaml", `{{ include "webapp.name" . }}`, `{{ include "webapp.name" . }}`},
+		{"values.yaml", "nameOverride: web\n", "nameOverride: web\n"},
+	}
+	for _, tt := range tests {
+		if got := string(placeholderName(tt.file, []byte(tt.data), "web")); got != tt.want {
+			t.Errorf("placeholderName(%s, %q) = %q, want %q", tt.file, tt.data, got, tt.want)
+		}
+	}
+}
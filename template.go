@@ -0,0 +1,217 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	helmValues "helm.sh/helm/v3/pkg/cli/values"
+	"helm.sh/helm/v3/pkg/getter"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/releaseutil"
+)
+
+const templateDesc = `
+Render chart templates locally and display the output.
+
+Any values that would normally be looked up or retrieved in-cluster will be
+faked locally. Additionally, none of the server-side testing of chart validity
+(e.g. whether an API is supported) is done.
+`
+
+// information of a template request
+type TemplateInfo struct {
+	Name        string   `json:"name" description:"name of release" default:"release-name"`
+	Namespace   string   `json:"namespace" description:"namespace of release" default:"default"`
+	Chart       string   `json:"chart" description:"chart reference (repo/chart), chart URL or oci:// reference, not a local path" default:"string"`
+	Version     string   `json:"version" description:"version constraint of the chart reference" default:"string"`
+	Workspace   string   `json:"workspace" description:"name of a chart in the workspace" default:"string"`
+	Archive     []byte   `json:"archive" description:"base64 encoded chart archive" default:"string"`
+	Values      []string `json:"values" description:"values of release" default:"[]"`
+	KubeVersion string   `json:"kube_version" description:"kubernetes version used for Capabilities.KubeVersion" default:"string"`
+	APIVersions []string `json:"api_versions" description:"kubernetes api versions used for Capabilities.APIVersions" default:"[]"`
+	ShowOnly    []string `json:"show_only" description:"only show manifests rendered from the given templates" default:"[]"`
+	IncludeCRDs bool     `json:"include_crds" description:"include CRDs in the templated output" default:"false"`
+	SkipTests   bool     `json:"skip_tests" description:"skip tests from templated output" default:"false"`
+}
+
+// template renders a chart without talking to the cluster and returns the manifests.
+func template(templateInfo *TemplateInfo) (string, error) {
+	ch, err := loadTemplateChart(templateInfo)
+	if err != nil {
+		return "", err
+	}
+	if err := checkIfInstallable(ch); err != nil {
+		return "", err
+	}
+	if req := ch.Metadata.Dependencies; req != nil {
+		if err := action.CheckDependencies(ch, req); err != nil {
+			return "", err
+		}
+	}
+
+	valueOpts := &helmValues.Options{}
+	valueOpts.Values = templateInfo.Values
+	vals, err := valueOpts.MergeValues(getter.All(settingsGlobal))
+	if err != nil {
+		return "", err
+	}
+
+	cfg := &action.Configuration{Log: debug}
+	client := action.NewInstall(cfg)
+	client.DryRun = true
+	client.ReleaseName = "release-name"
+	if templateInfo.Name != "" {
+		client.ReleaseName = templateInfo.Name
+	}
+	client.Namespace = "default"
+	if templateInfo.Namespace != "" {
+		client.Namespace = templateInfo.Namespace
+	}
+	client.Replace = true // Skip the name check
+	client.ClientOnly = true
+	client.APIVersions = chartutil.VersionSet(templateInfo.APIVersions)
+	client.IncludeCRDs = templateInfo.IncludeCRDs
+	if templateInfo.KubeVersion != "" {
+		client.KubeVersion, err = chartutil.ParseKubeVersion(templateInfo.KubeVersion)
+		if err != nil {
+			return "", errors.Wrapf(err, "invalid kube version '%s'", templateInfo.KubeVersion)
+		}
+	}
+
+	rel, err := client.Run(ch, vals)
+	if err != nil {
+		return "", err
+	}
+
+	var manifests bytes.Buffer
+	fmt.Fprintln(&manifests, strings.TrimSpace(rel.Manifest))
+	for _, m := range rel.Hooks {
+		if templateInfo.SkipTests && isTestHook(m) {
+			continue
+		}
+		fmt.Fprintf(&manifests, "---\n# Source: %s\n%s\n", m.Path, m.Manifest)
+	}
+	if len(templateInfo.ShowOnly) == 0 {
+		return manifests.String(), nil
+	}
+	return showOnly(manifests.String(), templateInfo.ShowOnly)
+}
+
+// loadTemplateChart loads the chart of a template request from the workspace,
+// the supplied archive, or a chart reference. References are resolved like
+// the charts of releases without a source, local paths of the server are
+// refused.
+func loadTemplateChart(templateInfo *TemplateInfo) (*chart.Chart, error) {
+	switch {
+	case templateInfo.Workspace != "":
+		dir, done, err := checkoutChart(templateInfo.Workspace)
+		if err != nil {
+			return nil, err
+		}
+		defer done(false)
+		if _, err := chartWorkspace.stat(templateInfo.Workspace); err != nil {
+			return nil, err
+		}
+		return loader.LoadDir(dir)
+	case len(templateInfo.Archive) > 0:
+		ch, err := loader.LoadArchive(bytes.NewReader(templateInfo.Archive))
+		if err != nil {
+			return nil, invalidArchive(err)
+		}
+		return ch, nil
+	case templateInfo.Chart != "":
+		opts := action.ChartPathOptions{}
+		chartRef, cleanup, err := resolveChartSource(&ReleaseInfo{Chart: templateInfo.Chart, ChartVersion: templateInfo.Version}, &opts)
+		if err != nil {
+			return nil, err
+		}
+		defer cleanup()
+		cp, err := opts.LocateChart(chartRef, settingsGlobal)
+		if err != nil {
+			return nil, errors.Wrap(errChartNotFound, err.Error())
+		}
+		return loader.Load(cp)
+	}
+	return nil, errors.Wrap(errInvalidChartRef, "one of chart, workspace or archive is required")
+}
+
+// checkoutChart returns a local directory with a chart of the workspace, see
+// workspaceStore.checkout.
+func checkoutChart(name string) (string, func(save bool) error, error) {
+	if err := validName(name); err != nil {
+		return "", nil, err
+	}
+	return chartWorkspace.checkout(name)
+}
+
+// showOnly keeps the manifests rendered from templates matching the given paths or globs.
+func showOnly(manifests string, showFiles []string) (string, error) {
+	// This is necessary to ensure consistent manifest ordering when using show-only
+	// with globs or directory names.
+	splitManifests := releaseutil.SplitManifests(manifests)
+	manifestsKeys := make([]string, 0, len(splitManifests))
+	for k := range splitManifests {
+		manifestsKeys = append(manifestsKeys, k)
+	}
+	sort.Sort(releaseutil.BySplitManifestsOrder(manifestsKeys))
+
+	manifestNameRegex := regexp.MustCompile("# Source: [^/]+/(.+)")
+	var out bytes.Buffer
+	for _, f := range showFiles {
+		missing := true
+		f = filepath.ToSlash(f)
+		for _, manifestKey := range manifestsKeys {
+			manifest := splitManifests[manifestKey]
+			submatch := manifestNameRegex.FindStringSubmatch(manifest)
+			if len(submatch) == 0 {
+				continue
+			}
+			// if the filepath provided matches a manifest path in the
+			// chart, render that manifest
+			if matched, _ := filepath.Match(f, submatch[1]); !matched {
+				continue
+			}
+			fmt.Fprintf(&out, "---\n%s\n", manifest)
+			missing = false
+		}
+		if missing {
+			return "", errors.Errorf("could not find template %s in chart", f)
+		}
+	}
+	return out.String(), nil
+}
+
+func isTestHook(h *release.Hook) bool {
+	for _, e := range h.Events {
+		if e == release.HookTest {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var templateChartFiles = map[string]string{
+	"Chart.yaml":          "apiVersion: v2\nname: mychart\nversion: 0.1.0\n",
+	"values.yaml":         "greeting: hello\n",
+	"templates/cm.yaml":   "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: {{ .Release.Name }}\ndata:\n  greeting: {{ .Values.greeting }}\n",
+	"templates/test.yaml": "apiVersion: v1\nkind: Pod\nmetadata:\n  name: {{ .Release.Name }}-test\n  annotations:\n    helm.sh/hook: test\n",
+}
+
+// templateChartArchive packs templateChartFiles as helm package does.
+func templateChartArchive(t *testing.T) []byte {
+	t.Helper()
+	files := map[string]string{}
+	for name, content := range templateChartFiles {
+		files["mychart/"+name] = content
+	}
+	return chartArchive(t, files)
+}
+
+// tempRepositories points the repository settings at an empty temporary directory.
+func tempRepositories(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	config, cache := settingsGlobal.RepositoryConfig, settingsGlobal.RepositoryCache
+	settingsGlobal.RepositoryConfig = filepath.Join(dir, "repositories.yaml")
+	settingsGlobal.RepositoryCache = filepath.Join(dir, "cache")
+	t.Cleanup(func() {
+		settingsGlobal.RepositoryConfig, settingsGlobal.RepositoryCache = config, cache
+	})
+}
+
+func TestTemplate(t *testing.T) {
+	tempWorkspaces(t)
+	tempRepositories(t)
+	for name, content := range templateChartFiles {
+		if err := chartWorkspace.writeFile([]byte(content), "mychart", name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	archive := templateChartArchive(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/mychart-0.1.0.tgz" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(archive)
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name string
+		info TemplateInfo
+	}{
+		{"workspace", TemplateInfo{Workspace: "mychart"}},
+		{"archive", TemplateInfo{Archive: archive}},
+		{"url", TemplateInfo{Chart: srv.URL + "/mychart-0.1.0.tgz"}},
+	}
+	for _, tt := range tests {
+		tt.info.Name = "web"
+		tt.info.Values = []string{"greeting=hi"}
+		manifests, err := template(&tt.info)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		for _, want := range []string{"# Source: mychart/templates/cm.yaml", "name: web\n", "greeting: hi", "name: web-test"} {
+			if !strings.Contains(manifests, want) {
+				t.Errorf("%s: %q missing from\n%s", tt.name, want, manifests)
+			}
+		}
+	}
+
+	info := TemplateInfo{Workspace: "mychart", SkipTests: true, ShowOnly: []string{"templates/cm.yaml"}}
+	manifests, err := template(&info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(manifests, "-test") || !strings.Contains(manifests, "kind: ConfigMap") {
+		t.Errorf("show only cm.yaml without tests:\n%s", manifests)
+	}
+}
+
+func TestTemplateRefused(t *testing.T) {
+	dir := tempWorkspaces(t)
+	tempRepositories(t)
+	tests := []struct {
+		name   string
+		info   TemplateInfo
+		status int
+	}{
+		{"absolute path", TemplateInfo{Chart: "/etc"}, http.StatusBadRequest},
+		{"relative path", TemplateInfo{Chart: "./mychart"}, http.StatusBadRequest},
+		{"parent path", TemplateInfo{Chart: "../mychart"}, http.StatusBadRequest},
+		{"existing path", TemplateInfo{Chart: dir}, http.StatusBadRequest},
+		{"file URL", TemplateInfo{Chart: "file:///etc/passwd"}, http.StatusBadRequest},
+		{"bare name", TemplateInfo{Chart: "mychart"}, http.StatusBadRequest},
+		{"unknown repo", TemplateInfo{Chart: "norepo/mychart"}, http.StatusNotFound},
+		{"missing workspace chart", TemplateInfo{Workspace: "missing"}, http.StatusNotFound},
+		{"workspace chart outside the workspace", TemplateInfo{Workspace: "../keyring"}, http.StatusBadRequest},
+		{"broken archive", TemplateInfo{Archive: []byte("not an archive")}, http.StatusBadRequest},
+		{"nothing", TemplateInfo{}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		_, err := template(&tt.info)
+		if err == nil {
+			t.Errorf("%s: rendered", tt.name)
+			continue
+		}
+		if status := workspaceStatus(err); status != tt.status {
+			t.Errorf("%s: %v, status %d, want %d", tt.name, err, status, tt.status)
+		}
+	}
+}
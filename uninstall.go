@@ -20,8 +20,18 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/util/wait"
 
 	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/kube"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage/driver"
 )
 
 const uninstallDesc = `
@@ -34,33 +44,132 @@ Use the '--dry-run' flag to see which releases will be uninstalled without actua
 uninstalling them.
 `
 
-func uninstall(releaseNames []string, namespace string) error {
-	out := os.Stdout
+type uninstallOptions struct {
+	namespace    string
+	keepHistory  bool
+	dryRun       bool
+	disableHooks bool
+	wait         bool
+	timeout      time.Duration
+}
 
-	s, err := newSettings(namespace)
+// outcome of uninstalling one release
+type uninstallResult struct {
+	Name   string `json:"name" description:"name of release"`
+	Status string `json:"status" description:"uninstalled, dry-run, not found or failed"`
+	Error  string `json:"error,omitempty" description:"reason of the failure, or why the labels of a kept release weren't restored"`
+	Info   string `json:"info,omitempty" description:"resources kept due to the resource policy"`
+}
+
+// uninstall removes every release in releaseNames, carrying on past releases
+// that fail, and reports the outcome of each one.
+func uninstall(releaseNames []string, o *uninstallOptions) ([]uninstallResult, error) {
+	s, err := newSettings(o.namespace)
 	if err != nil {
 		log.Println(err)
-		return err
+		return nil, err
 	}
-	cfg, err := newConfig(namespace, s)
+	cfg, err := newConfig(o.namespace, s)
 	if err != nil {
 		log.Println(err)
-		return err
+		return nil, err
 	}
+	st, err := newReleaseStorage(s)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	return uninstallReleases(cfg, st, releaseNames, o), nil
+}
 
+// uninstallReleases uninstalls releases one by one with cfg. Releases whose
+// history is kept get their labels and annotations back on the uninstalled
+// revision, which the storage driver rewrites without them.
+func uninstallReleases(cfg *action.Configuration, st *releaseStorage, releaseNames []string, o *uninstallOptions) []uninstallResult {
+	out := os.Stdout
 	client := action.NewUninstall(cfg)
-	for i := 0; i < len(releaseNames); i++ {
+	client.KeepHistory = o.keepHistory
+	client.DryRun = o.dryRun
+	client.DisableHooks = o.disableHooks
+	client.Timeout = o.timeout
 
-		res, err := client.Run(releaseNames[i])
-		if err != nil {
-			log.Println(err)
-			return err
+	results := []uninstallResult{}
+	for _, name := range releaseNames {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
 		}
-		if res != nil && res.Info != "" {
-			fmt.Fprintln(out, res.Info)
+		result := uninstallResult{Name: name}
+		var meta *releaseMetadata
+		if o.keepHistory && !o.dryRun {
+			var err error
+			if meta, err = previousReleaseMetadata(cfg, st, o.namespace, name); err != nil {
+				log.Println(err)
+			}
 		}
+		res, err := client.Run(name)
+		switch {
+		case errors.Is(err, driver.ErrReleaseNotFound):
+			result.Status = "not found"
+			result.Error = err.Error()
+		case err != nil:
+			log.Println(err)
+			result.Status = "failed"
+			result.Error = err.Error()
+		case o.dryRun:
+			result.Status = "dry-run"
+		default:
+			result.Status = "uninstalled"
+			if res != nil && res.Info != "" {
+				fmt.Fprintln(out, res.Info)
+				result.Info = res.Info
+			}
+			if meta != nil && res != nil && res.Release != nil {
+				if err := st.apply(res.Release, meta); err != nil {
+					log.Println(err)
+					result.Error = err.Error()
+				}
+			}
+			if o.wait && res != nil && res.Release != nil {
+				if err := waitForDeletion(cfg, res.Release, o.timeout); err != nil {
+					log.Println(err)
+					result.Status = "failed"
+					result.Error = err.Error()
+				}
+			}
+			fmt.Fprintf(out, "release \"%s\" uninstalled\n", name)
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// deletionPollInterval is how often waitForDeletion looks for the resources.
+var deletionPollInterval = 2 * time.Second
 
-		fmt.Fprintf(out, "release \"%s\" uninstalled\n", releaseNames[i])
+// waitForDeletion polls the resources of rel until all of them are gone from
+// the cluster, ignoring the ones kept due to the resource policy.
+func waitForDeletion(cfg *action.Configuration, rel *release.Release, timeout time.Duration) error {
+	resources, err := cfg.KubeClient.Build(strings.NewReader(rel.Manifest), false)
+	if err != nil {
+		return err
 	}
-	return nil
+	err = wait.PollImmediate(deletionPollInterval, timeout, func() (bool, error) {
+		for _, info := range resources {
+			err := info.Get()
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			if err != nil {
+				return false, err
+			}
+			accessor, err := meta.Accessor(info.Object)
+			if err == nil && accessor.GetAnnotations()[kube.ResourcePolicyAnno] == kube.KeepPolicy {
+				continue
+			}
+			return false, nil
+		}
+		return true, nil
+	})
+	return errors.Wrapf(err, "waiting for resources of release %q to be deleted", rel.Name)
 }
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/cli-runtime/pkg/resource"
+	"k8s.io/client-go/kubernetes/scheme"
+	restfake "k8s.io/client-go/rest/fake"
+
+	"helm.sh/helm/v3/pkg/kube"
+	fakekube "helm.sh/helm/v3/pkg/kube/fake"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage/driver"
+)
+
+func TestUninstallReleases(t *testing.T) {
+	tests := []struct {
+		name string
+		o    uninstallOptions
+		want map[string]string
+		// releases left in the storage afterwards
+		kept []string
+	}{
+		{"purge", uninstallOptions{namespace: "default"}, map[string]string{"web": "uninstalled", "missing": "not found", "gone": "uninstalled"}, nil},
+		{"keep history", uninstallOptions{namespace: "default", keepHistory: true}, map[string]string{"web": "uninstalled", "missing": "not found", "gone": "failed"}, []string{"web", "gone"}},
+		{"dry run", uninstallOptions{namespace: "default", dryRun: true}, map[string]string{"web": "dry-run", "missing": "not found", "gone": "dry-run"}, []string{"web", "gone"}},
+	}
+	for _, tt := range tests {
+		cfg, st := secretsReleaseConfig(t)
+		web := storeTestRelease(t, cfg, "web", 1, release.StatusDeployed)
+		storeTestRelease(t, cfg, "gone", 1, release.StatusUninstalled)
+		if err := st.apply(web, &releaseMetadata{Labels: map[string]string{"team": "web"}}); err != nil {
+			t.Fatal(err)
+		}
+
+		results := uninstallReleases(cfg, st, []string{"web", " missing", "gone", ""}, &tt.o)
+		if len(results) != len(tt.want) {
+			t.Errorf("%s: %d results, want %d", tt.name, len(results), len(tt.want))
+		}
+		for _, result := range results {
+			if result.Status != tt.want[result.Name] {
+				t.Errorf("%s: %s %s (%s), want %s", tt.name, result.Name, result.Status, result.Error, tt.want[result.Name])
+			}
+			if (result.Error != "") != (result.Status == "failed" || result.Status == "not found") {
+				t.Errorf("%s: %s %s with error %q", tt.name, result.Name, result.Status, result.Error)
+			}
+		}
+		for _, name := range []string{"web", "gone"} {
+			_, err := cfg.Releases.Last(name)
+			kept := false
+			for _, k := range tt.kept {
+				kept = kept || k == name
+			}
+			if kept && err != nil || !kept && !errors.Is(err, driver.ErrReleaseNotFound) {
+				t.Errorf("%s: release %s kept %v: %v", tt.name, name, kept, err)
+			}
+		}
+
+		if tt.o.keepHistory {
+			rel, err := cfg.Releases.Last("web")
+			if err != nil {
+				t.Fatal(err)
+			}
+			meta, err := st.get("default", "web", 1)
+			if err != nil || rel.Info.Status != release.StatusUninstalled || meta.Labels["team"] != "web" {
+				t.Errorf("%s: kept release %s with labels %v: %v", tt.name, rel.Info.Status, meta, err)
+			}
+		}
+	}
+}
+
+// resourcesKubeClient builds the given resources from any manifest.
+type resourcesKubeClient struct {
+	fakekube.PrintingKubeClient
+	resources kube.ResourceList
+}
+
+func (c *resourcesKubeClient) Build(io.Reader, bool) (kube.ResourceList, error) {
+	return c.resources, nil
+}
+
+// fakeConfigMaps answers GETs of config maps by name: found until gets[name]
+// reaches 0, with the keep resource policy when keep is set.
+type fakeConfigMaps struct {
+	mu     sync.Mutex
+	gets   map[string]int
+	keep   map[string]bool
+	failed map[string]bool
+}
+
+func (f *fakeConfigMaps) info(name string) *resource.Info {
+	client := &restfake.RESTClient{
+		NegotiatedSerializer: scheme.Codecs.WithoutConversion(),
+		GroupVersion:         schema.GroupVersion{Version: "v1"},
+		Client: restfake.CreateHTTPClient(func(req *http.Request) (*http.Response, error) {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			header := http.Header{"Content-Type": []string{"application/json"}}
+			if f.failed[name] {
+				return &http.Response{StatusCode: http.StatusInternalServerError, Header: header, Body: io.NopCloser(strings.NewReader(`{}`))}, nil
+			}
+			if f.gets[name] <= 0 && !f.keep[name] {
+				status := `{"kind": "Status", "apiVersion": "v1", "status": "Failure", "reason": "NotFound", "code": 404}`
+				return &http.Response{StatusCode: http.StatusNotFound, Header: header, Body: io.NopCloser(strings.NewReader(status))}, nil
+			}
+			f.gets[name]--
+			annotations := "{}"
+			if f.keep[name] {
+				annotations = fmt.Sprintf(`{%q: %q}`, kube.ResourcePolicyAnno, kube.KeepPolicy)
+			}
+			body := fmt.Sprintf(`{"kind": "ConfigMap", "apiVersion": "v1", "metadata": {"name": %q, "namespace": "default", "annotations": %s}}`, name, annotations)
+			return &http.Response{StatusCode: http.StatusOK, Header: header, Body: io.NopCloser(strings.NewReader(body))}, nil
+		}),
+	}
+	return &resource.Info{
+		Client:    client,
+		Namespace: "default",
+		Name:      name,
+		Mapping: &meta.RESTMapping{
+			Resource:         schema.GroupVersionResource{Version: "v1", Resource: "configmaps"},
+			GroupVersionKind: schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"},
+			Scope:            meta.RESTScopeNamespace,
+		},
+	}
+}
+
+func TestWaitForDeletion(t *testing.T) {
+	interval := deletionPollInterval
+	deletionPollInterval = 10 * time.Millisecond
+	defer func() { deletionPollInterval = interval }()
+
+	tests := []struct {
+		name    string
+		gets    map[string]int
+		keep    map[string]bool
+		failed  map[string]bool
+		timeout bool
+		err     bool
+	}{
+		{"gone at once", map[string]int{}, nil, nil, false, false},
+		{"gone after a while", map[string]int{"a": 3, "b": 1}, nil, nil, false, false},
+		{"kept by the resource policy", map[string]int{}, map[string]bool{"b": true}, nil, false, false},
+		{"never gone", map[string]int{"a": 1 << 20}, nil, nil, true, true},
+		{"failing API", map[string]int{}, nil, map[string]bool{"a": true}, false, true},
+	}
+	for _, tt := range tests {
+		f := &fakeConfigMaps{gets: tt.gets, keep: tt.keep, failed: tt.failed}
+		cfg, _ := secretsReleaseConfig(t)
+		cfg.KubeClient = &resourcesKubeClient{resources: kube.ResourceList{f.info("a"), f.info("b")}}
+		err := waitForDeletion(cfg, &release.Release{Name: "web"}, 200*time.Millisecond)
+		if (err != nil) != tt.err {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if tt.timeout && (err == nil || !strings.Contains(err.Error(), "timed out")) {
+			t.Errorf("%s: %v, want a timeout", tt.name, err)
+		}
+		for name, n := range tt.gets {
+			if n > 0 && !tt.timeout {
+				t.Errorf("%s: %s still found %d times", tt.name, name, n)
+			}
+		}
+	}
+}
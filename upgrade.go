@@ -69,14 +69,28 @@ func upgrade(releaseInfo *ReleaseInfo) (*release.Release, error) {
 		return nil, err
 	}
 
+	if err := validateReleaseMetadata(releaseInfo.metadata()); err != nil {
+		return nil, err
+	}
+	st, err := newReleaseStorage(s)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
 	valueOpts := &helmValues.Options{}
 	valueOpts.Values = releaseInfo.Values
 	out := os.Stdout
-	args := []string{releaseInfo.Name, releaseInfo.Chart}
 	client := action.NewUpgrade(cfg)
 	createNamespace := true
 
 	client.Namespace = releaseInfo.Namespace
+	chartRef, cleanup, err := resolveChartSource(releaseInfo, &client.ChartPathOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+	args := []string{releaseInfo.Name, chartRef}
 
 	// Fixes #7002 - Support reading values from STDIN for `upgrade` command
 	// Must load values AFTER determining if we have to call install so that values loaded from stdin are are not read twice
@@ -106,6 +120,9 @@ func upgrade(releaseInfo *ReleaseInfo) (*release.Release, error) {
 			if err != nil {
 				return nil, err
 			}
+			if err := st.apply(rel, releaseInfo.metadata()); err != nil {
+				return rel, err
+			}
 			return rel, nil
 		} else if err != nil {
 			return nil, err
@@ -142,10 +159,21 @@ func upgrade(releaseInfo *ReleaseInfo) (*release.Release, error) {
 		warning("This chart is deprecated")
 	}
 
+	// The storage object of the previous revision is rewritten by the upgrade,
+	// so its labels and annotations are read beforehand and carried forward.
+	meta, err := previousReleaseMetadata(cfg, st, client.Namespace, args[0])
+	if err != nil {
+		return nil, err
+	}
+
 	rel, err := client.Run(args[0], ch, vals)
 	if err != nil {
 		return nil, errors.Wrap(err, "UPGRADE FAILED")
 	}
+	if err := st.apply(rel, meta.merge(releaseInfo.metadata())); err != nil {
+		log.Println(err)
+		return rel, err
+	}
 
 	return rel, nil
 }
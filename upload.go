@@ -1,11 +1,11 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
-	"os"
 
 	restful "github.com/emicklei/go-restful/v3"
 	"github.com/pkg/errors"
@@ -19,21 +19,19 @@ func upload(chartPackage string, chartName string, repoName string) (string, err
 	}
 	repoEntry := repos.Get(repoName)
 	if repoEntry != nil {
-		chartPackgeDir := ".helm/chart-package"
-		// Ensure the chart package directory exists
-		err := os.MkdirAll(chartPackgeDir, os.ModePerm)
-		if err != nil && !os.IsExist(err) {
-			log.Println(err)
+		if err := validName(chartName); err != nil {
+			return "", err
+		}
+		if err := validName(chartPackage); err != nil {
 			return "", err
 		}
-		chartPath := fmt.Sprintf("%s/%s/%s", chartPackgeDir, chartName, chartPackage)
-		file, err := os.Open(chartPath)
+		file, err := packageWorkspace.readFile(chartName, chartPackage)
 		if err != nil {
+			log.Println(err)
 			return "", err
 		}
-		defer file.Close()
 		uploadUrl := fmt.Sprintf("%s/api/charts", repoEntry.URL)
-		resp, err := http.Post(uploadUrl, restful.MIME_OCTET, file)
+		resp, err := http.Post(uploadUrl, restful.MIME_OCTET, bytes.NewReader(file))
 		if err != nil {
 			return "", err
 		}
@@ -42,6 +40,11 @@ func upload(chartPackage string, chartName string, repoName string) (string, err
 		if err != nil {
 			return "", err
 		}
+		if resp.StatusCode < http.StatusMultipleChoices {
+			if err := recordUpload(chartName, chartPackage, repoName, file); err != nil {
+				log.Println(err)
+			}
+		}
 		return string(message), nil
 	} else {
 		return "", errors.New("repo dose not exist")
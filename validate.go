@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	texttemplate "text/template"
+
+	"github.com/Masterminds/sprig/v3"
+	"github.com/pkg/errors"
+	"github.com/xeipuuv/gojsonschema"
+	"sigs.k8s.io/yaml"
+
+	"helm.sh/helm/v3/pkg/lint/support"
+)
+
+// Validation modes of chart file edits.
+const (
+	validateNone   = "none"
+	validateWarn   = "warn"
+	validateReject = "reject"
+)
+
+// helmTemplateFuncs stubs the functions helm adds on top of sprig, so that
+// templates using them can be parsed without rendering.
+var helmTemplateFuncs = texttemplate.FuncMap{
+	"include":       func(string, interface{}) (string, error) { return "", nil },
+	"tpl":           func(string, interface{}) (string, error) { return "", nil },
+	"required":      func(string, interface{}) (interface{}, error) { return nil, nil },
+	"lookup":        func(string, string, string, string) (map[string]interface{}, error) { return nil, nil },
+	"toToml":        func(interface{}) string { return "" },
+	"toYaml":        func(interface{}) string { return "" },
+	"fromYaml":      func(string) map[string]interface{} { return nil },
+	"fromYamlArray": func(string) []interface{} { return nil },
+	"toJson":        func(interface{}) string { return "" },
+	"fromJson":      func(string) map[string]interface{} { return nil },
+	"fromJsonArray": func(string) []interface{} { return nil },
+}
+
+// validateChartFile checks content as the new version of filePath in a workspace
+// chart: the syntax of the file itself, then a lint of the chart with the file
+// replaced. The chart on disk is left untouched.
+func validateChartFile(chartName string, filePath string, content []byte) ([]lintFinding, error) {
+	findings := []lintFinding{}
+	if finding := validateFileSyntax(chartName, filePath, content); finding != nil {
+		findings = append(findings, *finding)
+	}
+
+	chartDir, done, err := checkoutChart(chartName)
+	if err != nil {
+		return nil, err
+	}
+	defer done(false)
+	tmp, err := ioutil.TempDir("", "helm-rest-validate")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+	copyDir := filepath.Join(tmp, chartName)
+	if err := copyTree(chartDir, copyDir); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	name, err := cleanPath(filePath)
+	if err != nil {
+		return nil, err
+	}
+	file := filepath.Join(copyDir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(file, content, 0644); err != nil {
+		return nil, err
+	}
+	report, err := lintPath(copyDir, &LintInfo{})
+	if err != nil {
+		return nil, err
+	}
+	return append(findings, report.Findings...), nil
+}
+
+// validateFileSyntax checks that a chart file parses according to its kind.
+func validateFileSyntax(chartName string, filePath string, content []byte) *lintFinding {
+	filePath = path.Clean(filepath.ToSlash(filePath))
+	base := path.Base(filePath)
+	var err error
+	switch {
+	case base == "values.schema.json":
+		err = validateJSONSchema(content)
+	case strings.HasPrefix(filePath, "templates/"):
+		_, err = texttemplate.New(path.Join(chartName, filePath)).Funcs(sprig.TxtFuncMap()).Funcs(helmTemplateFuncs).Parse(string(content))
+	case base == "Chart.yaml" || strings.HasSuffix(base, ".yaml") && strings.HasPrefix(base, "values"):
+		var v map[string]interface{}
+		err = yaml.Unmarshal(content, &v)
+	default:
+		return nil
+	}
+	if err == nil {
+		return nil
+	}
+	finding := newLintFinding(support.NewMessage(support.ErrorSev, filePath, err))
+	finding.Path = filePath
+	return &finding
+}
+
+func validateJSONSchema(content []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(content, &v); err != nil {
+		return errors.Wrap(err, "invalid JSON")
+	}
+	if _, err := gojsonschema.NewSchema(gojsonschema.NewBytesLoader(content)); err != nil {
+		return errors.Wrap(err, "invalid JSON schema")
+	}
+	return nil
+}
+
+func hasErrorFindings(findings []lintFinding) bool {
+	for _, f := range findings {
+		if f.Severity == "ERROR" {
+			return true
+		}
+	}
+	return false
+}
+
+// copyTree copies the regular files under src into dst.
+func copyTree(src string, dst string) error {
+	return filepath.Walk(src, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if info.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		data, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(target, data, 0644)
+	})
+}
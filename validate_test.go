@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	restful "github.com/emicklei/go-restful/v3"
+)
+
+func TestValidateFileSyntax(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		content string
+		line    int
+		valid   bool
+	}{
+		{"values", "values.yaml", "replicas: 1\nimage:\n  tag: latest\n", 0, true},
+		{"broken values", "values.yaml", "replicas: 1\nimage: [\n", 2, false},
+		{"values override", "values-prod.yaml", "a: b: c\n", 0, false},
+		{"broken chart metadata", "Chart.yaml", "apiVersion: v2\n name: x\n", 2, false},
+		{"template", "templates/cm.yaml", "data:\n{{ include \"x\" . | indent 2 }}\n{{ toYaml .Values | nindent 2 }}\n{{ required \"a\" .Values.a }}\n", 0, true},
+		{"unclosed action", "templates/cm.yaml", "data:\n  a: {{ .Values.a\n", 3, false},
+		{"unknown function", "templates/cm.yaml", "a: {{ nosuchfunc .Values.a }}\n", 1, false},
+		{"helper", "templates/_helpers.tpl", "{{- define \"x\" -}}{{ .Chart.Name }}{{- end }}\n", 0, true},
+		{"schema", "values.schema.json", `{"type": "object", "properties": {"replicas": {"type": "integer"}}}`, 0, true},
+		{"schema not JSON", "values.schema.json", `{"type": "object",`, 0, false},
+		{"invalid schema", "values.schema.json", `{"type": 5}`, 0, false},
+		{"other file", "README.md", "{{ [ not checked", 0, true},
+	}
+	for _, tt := range tests {
+		finding := validateFileSyntax("mychart", tt.path, []byte(tt.content))
+		if tt.valid {
+			if finding != nil {
+				t.Errorf("%s: %+v", tt.name, finding)
+			}
+			continue
+		}
+		if finding == nil {
+			t.Errorf("%s: accepted", tt.name)
+			continue
+		}
+		if finding.Severity != "ERROR" || finding.Path != tt.path || finding.Line != tt.line {
+			t.Errorf("%s: got %+v, want ERROR at %s:%d", tt.name, finding, tt.path, tt.line)
+		}
+	}
+}
+
+func TestEditChartFileValidation(t *testing.T) {
+	tempWorkspaces(t)
+	tempRepositories(t)
+	for name, content := range templateChartFiles {
+		if err := chartWorkspace.writeFile([]byte(content), "mychart", name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ws := new(restful.WebService)
+	ws.Produces(restful.MIME_JSON)
+	ws.Route(ws.PUT("/chart/{chart-name}/{file-path:*}").Consumes("text/plain").To(HelmResource{}.editChartFile))
+	container := restful.NewContainer()
+	container.Add(ws)
+	srv := httptest.NewServer(container)
+	defer srv.Close()
+
+	tests := []struct {
+		name    string
+		mode    string
+		path    string
+		content string
+		status  int
+		saved   bool
+	}{
+		{"valid values", validateReject, "values.yaml", "greeting: hi\n", http.StatusOK, true},
+		{"broken values", validateReject, "values.yaml", "greeting: [\n", http.StatusUnprocessableEntity, false},
+		{"broken template", validateReject, "templates/cm.yaml", "data: {{ .Values.greeting\n", http.StatusUnprocessableEntity, false},
+		{"template failing to render", validateReject, "templates/cm.yaml", "data: {{ .Values.greeting.missing.key }}\n", http.StatusUnprocessableEntity, false},
+		{"schema rejecting the values", validateReject, "values.schema.json", `{"type": "object", "properties": {"greeting": {"type": "integer"}}}`, http.StatusUnprocessableEntity, false},
+		{"broken values with warnings only", validateWarn, "values.yaml", "greeting: [\n", http.StatusOK, true},
+		{"unknown mode", "strict", "values.yaml", "greeting: hey\n", http.StatusBadRequest, false},
+	}
+	for _, tt := range tests {
+		before, _ := chartWorkspace.readFile("mychart", tt.path)
+		req, err := http.NewRequest(http.MethodPut, srv.URL+"/chart/mychart/"+tt.path+"?validate="+tt.mode, strings.NewReader(tt.content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "text/plain")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, resp.StatusCode, tt.status, body)
+		}
+		var result ValidationResult
+		if err := json.Unmarshal(body, &result); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if tt.status == http.StatusUnprocessableEntity && !hasErrorFindings(result.Findings) {
+			t.Errorf("%s: rejected without errors: %+v", tt.name, result)
+		}
+		after, _ := chartWorkspace.readFile("mychart", tt.path)
+		if saved := string(after) == tt.content; saved != tt.saved {
+			t.Errorf("%s: saved %v, want %v", tt.name, saved, tt.saved)
+		}
+		if !tt.saved && string(after) != string(before) {
+			t.Errorf("%s: file changed to %q", tt.name, after)
+		}
+	}
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/openpgp"
+	"sigs.k8s.io/yaml"
+
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/downloader"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+// keyringWorkspace keeps the public keys trusted to sign charts, one
+// <fingerprint>.gpg per key.
+var keyringWorkspace = &workspace{store: &localStore{root: ".helm/keyring"}}
+
+var (
+	errVerificationFailed = errors.New("chart verification failed")
+	errInvalidKey         = errors.New("invalid OpenPGP key")
+)
+
+// verifiedOnlyLock serializes changes of the verified-only repositories.
+var verifiedOnlyLock sync.Mutex
+
+// a key trusted to sign charts
+type trustedKey struct {
+	Fingerprint string    `json:"fingerprint"`
+	KeyID       string    `json:"key_id"`
+	Identities  []string  `json:"identities"`
+	Created     time.Time `json:"created"`
+}
+
+// information of a package verification request
+type VerifyInfo struct {
+	Chart   string `json:"chart" description:"name of the chart in the workspace" default:"string"`
+	Package string `json:"package" description:"file name of the package, e.g. mychart-0.1.0.tgz" default:"string"`
+}
+
+// result of verifying a package against the trusted keys
+type verification struct {
+	Verified    bool     `json:"verified"`
+	SignedBy    []string `json:"signed_by,omitempty" description:"identities of the key the package is signed with"`
+	Fingerprint string   `json:"fingerprint,omitempty" description:"fingerprint of the key the package is signed with"`
+	FileHash    string   `json:"file_hash,omitempty" description:"hash of the package the provenance file vouches for"`
+	Error       string   `json:"error,omitempty" description:"why the package couldn't be verified"`
+}
+
+func newTrustedKey(e *openpgp.Entity) *trustedKey {
+	key := &trustedKey{
+		Fingerprint: fmt.Sprintf("%X", e.PrimaryKey.Fingerprint),
+		KeyID:       e.PrimaryKey.KeyIdString(),
+		Identities:  []string{},
+		Created:     e.PrimaryKey.CreationTime,
+	}
+	for identity := range e.Identities {
+		key.Identities = append(key.Identities, identity)
+	}
+	sort.Strings(key.Identities)
+	return key
+}
+
+// primaryIdentity returns the identity of a key marked as primary, or the
+// first one by name if none is.
+func primaryIdentity(e *openpgp.Entity) string {
+	names := []string{}
+	for name, identity := range e.Identities {
+		if identity.SelfSignature != nil && identity.SelfSignature.IsPrimaryId != nil && *identity.SelfSignature.IsPrimaryId {
+			return name
+		}
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		return ""
+	}
+	sort.Strings(names)
+	return names[0]
+}
+
+// trustedEntities reads the keys of the keyring workspace.
+func trustedEntities() (openpgp.EntityList, error) {
+	infos, err := keyringWorkspace.readDir()
+	if err != nil && !os.IsNotExist(errors.Cause(err)) {
+		return nil, err
+	}
+	var entities openpgp.EntityList
+	for _, info := range infos {
+		if info.IsDir() || !strings.HasSuffix(info.Name(), ".gpg") {
+			continue
+		}
+		data, err := keyringWorkspace.readFile(info.Name())
+		if err != nil {
+			return nil, err
+		}
+		keys, err := openpgp.ReadKeyRing(bytes.NewReader(data))
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid key %s", info.Name())
+		}
+		entities = append(entities, keys...)
+	}
+	return entities, nil
+}
+
+func listTrustedKeys() ([]*trustedKey, error) {
+	entities, err := trustedEntities()
+	if err != nil {
+		return nil, err
+	}
+	keys := []*trustedKey{}
+	for _, e := range entities {
+		keys = append(keys, newTrustedKey(e))
+	}
+	return keys, nil
+}
+
+// addTrustedKeys adds the public keys of an armored or binary keyring to the
+// trusted keys. Only the public part of secret keys is kept.
+func addTrustedKeys(data []byte) ([]*trustedKey, error) {
+	var entities openpgp.EntityList
+	var err error
+	if bytes.Contains(data, []byte("-----BEGIN PGP")) {
+		entities, err = openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+	} else {
+		entities, err = openpgp.ReadKeyRing(bytes.NewReader(data))
+	}
+	if err != nil || len(entities) == 0 {
+		return nil, errors.Wrapf(errInvalidKey, "no keys found: %v", err)
+	}
+	keys := []*trustedKey{}
+	for _, e := range entities {
+		var buf bytes.Buffer
+		if err := e.Serialize(&buf); err != nil {
+			return nil, err
+		}
+		key := newTrustedKey(e)
+		if err := keyringWorkspace.writeFile(buf.Bytes(), key.Fingerprint+".gpg"); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func removeTrustedKey(fingerprint string) error {
+	if err := validName(fingerprint); err != nil {
+		return err
+	}
+	return keyringWorkspace.remove(strings.ToUpper(fingerprint) + ".gpg")
+}
+
+// trustedKeyring writes the trusted keys into a keyring file for helm to verify
+// with. The file is removed by calling cleanup.
+func trustedKeyring() (string, func(), error) {
+	entities, err := trustedEntities()
+	if err != nil {
+		return "", nil, err
+	}
+	if len(entities) == 0 {
+		return "", nil, errors.Wrap(errVerificationFailed, "there are no trusted keys to verify with")
+	}
+	f, err := ioutil.TempFile("", "helm-rest-keyring")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() { os.Remove(f.Name()) }
+	for _, e := range entities {
+		if err := e.Serialize(f); err != nil {
+			f.Close()
+			cleanup()
+			return "", nil, err
+		}
+	}
+	if err := f.Close(); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	return f.Name(), cleanup, nil
+}
+
+// verifyPackage verifies a package of the workspace with its provenance file.
+// A package that fails verification is no error, the result tells why.
+func verifyPackage(verifyInfo *VerifyInfo) (*verification, error) {
+	if err := validName(verifyInfo.Chart); err != nil {
+		return nil, err
+	}
+	if err := validName(verifyInfo.Package); err != nil {
+		return nil, err
+	}
+	if _, err := packageWorkspace.stat(verifyInfo.Chart, verifyInfo.Package); err != nil {
+		return nil, err
+	}
+	keyring, cleanup, err := trustedKeyring()
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+	dir, done, err := packageWorkspace.checkout(verifyInfo.Chart)
+	if err != nil {
+		return nil, err
+	}
+	defer done(false)
+
+	v, err := downloader.VerifyChart(filepath.Join(dir, verifyInfo.Package), keyring)
+	if err != nil {
+		return &verification{Error: err.Error()}, nil
+	}
+	key := newTrustedKey(v.SignedBy)
+	return &verification{
+		Verified:    true,
+		SignedBy:    key.Identities,
+		Fingerprint: key.Fingerprint,
+		FileHash:    v.FileHash,
+	}, nil
+}
+
+// verifyChartOptions makes LocateChart verify the chart it fetches against the
+// trusted keys when asked to or when the chart comes from a verified-only
+// repository. Call cleanup once the chart is located.
+func verifyChartOptions(opts *action.ChartPathOptions, chartRef string, verify bool) (func(), error) {
+	if !verify {
+		var err error
+		if verify, err = fromVerifiedOnlyRepo(chartRef, opts.RepoURL); err != nil {
+			return nil, err
+		}
+	}
+	if !verify {
+		return func() {}, nil
+	}
+	keyring, cleanup, err := trustedKeyring()
+	if err != nil {
+		return nil, err
+	}
+	opts.Verify = true
+	opts.Keyring = keyring
+	return cleanup, nil
+}
+
+// fromVerifiedOnlyRepo tells if a chart reference, repo/chart or a URL, or the
+// repository URL it's looked up in belongs to a verified-only repository.
+func fromVerifiedOnlyRepo(chartRef string, repoURL string) (bool, error) {
+	names, err := verifiedOnlyRepos()
+	if err != nil || len(names) == 0 {
+		return false, err
+	}
+	rf, err := repo.LoadFile(settingsGlobal.RepositoryConfig)
+	if err != nil && !os.IsNotExist(errors.Cause(err)) {
+		return false, err
+	}
+	for _, name := range names {
+		if repoURL == "" && strings.HasPrefix(chartRef, name+"/") {
+			return true, nil
+		}
+		entry := rf.Get(name)
+		if entry == nil {
+			continue
+		}
+		url := strings.TrimSuffix(entry.URL, "/")
+		if strings.TrimSuffix(repoURL, "/") == url || strings.HasPrefix(chartRef, url+"/") {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// verifiedOnlyFile lists the repositories whose charts are only installed
+// verified, next to the repositories file.
+func verifiedOnlyFile() string {
+	return filepath.Join(filepath.Dir(settingsGlobal.RepositoryConfig), "verified-only.yaml")
+}
+
+func verifiedOnlyRepos() ([]string, error) {
+	data, err := ioutil.ReadFile(verifiedOnlyFile())
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	if err := yaml.Unmarshal(data, &names); err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+// setVerifiedOnly marks a repository as verified-only or clears the mark.
+func setVerifiedOnly(repoName string, verifiedOnly bool) error {
+	verifiedOnlyLock.Lock()
+	defer verifiedOnlyLock.Unlock()
+	if verifiedOnly {
+		rf, err := repo.LoadFile(settingsGlobal.RepositoryConfig)
+		if err != nil && !os.IsNotExist(errors.Cause(err)) {
+			return err
+		}
+		if !rf.Has(repoName) {
+			return errors.Wrapf(os.ErrNotExist, "repository %q", repoName)
+		}
+	}
+	names, err := verifiedOnlyRepos()
+	if err != nil {
+		return err
+	}
+	kept := []string{}
+	for _, name := range names {
+		if name != repoName {
+			kept = append(kept, name)
+		}
+	}
+	if verifiedOnly {
+		kept = append(kept, repoName)
+	}
+	data, err := yaml.Marshal(kept)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(verifiedOnlyFile(), data, 0644)
+}
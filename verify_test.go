@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+func TestPrimaryIdentity(t *testing.T) {
+	primary, other := true, false
+	identity := func(name string, isPrimary *bool) *openpgp.Identity {
+		return &openpgp.Identity{Name: name, SelfSignature: &packet.Signature{IsPrimaryId: isPrimary}}
+	}
+	tests := []struct {
+		identities []*openpgp.Identity
+		want       string
+	}{
+		{nil, ""},
+		{[]*openpgp.Identity{identity("b <b@example.com>", nil), identity("a <a@example.com>", nil)}, "a <a@example.com>"},
+		{[]*openpgp.Identity{identity("a <a@example.com>", &other), identity("z <z@example.com>", &primary), identity("m <m@example.com>", nil)}, "z <z@example.com>"},
+	}
+	for _, tt := range tests {
+		e := &openpgp.Entity{Identities: map[string]*openpgp.Identity{}}
+		for _, i := range tt.identities {
+			e.Identities[i.Name] = i
+		}
+		// maps are iterated in random order, the answer must not depend on it
+		for n := 0; n < 20; n++ {
+			if got := primaryIdentity(e); got != tt.want {
+				t.Fatalf("primaryIdentity = %q, want %q", got, tt.want)
+			}
+		}
+	}
+}
@@ -0,0 +1,478 @@
+package main
+
+import (
+	"crypto/sha256"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// workspace gives access to the files under one root of the server, e.g. the
+// charts being edited or the packaged charts. Every path handed to it is
+// relative to the root and can't point outside of it. The files themselves are
+// kept by a workspaceStore, on the local disk or in an object store shared by
+// several replicas of the server.
+type workspace struct {
+	store workspaceStore
+}
+
+// workspaceStore keeps the files of a workspace. Names are clean, slash
+// separated paths relative to the root of the store, "" being the root itself.
+type workspaceStore interface {
+	readFile(name string) ([]byte, error)
+	writeFile(name string, data []byte) error
+	// createFile writes a file only if it doesn't exist yet, failing with an
+	// error os.IsExist tells otherwise.
+	createFile(name string, data []byte) error
+	stat(name string) (os.FileInfo, error)
+	readDir(name string) ([]os.FileInfo, error)
+	// walk walks the tree below name like filepath.Walk, passing names
+	// relative to the root of the store.
+	walk(name string, fn filepath.WalkFunc) error
+	remove(name string) error
+	removeAll(name string) error
+	// checkout returns a directory on the local disk with the tree below name,
+	// for the helm functions that only work on the filesystem. done must be
+	// called once the directory isn't used anymore; with save set, the changes
+	// made in the directory are stored.
+	checkout(name string) (dir string, done func(save bool) error, err error)
+}
+
+var (
+	chartWorkspace   = &workspace{store: &localStore{root: ".helm/charts"}}
+	packageWorkspace = &workspace{store: &localStore{root: ".helm/chart-package"}}
+)
+
+// options of the store behind the workspaces
+type workspaceStorageOptions struct {
+	kind        string
+	s3Bucket    string
+	s3Prefix    string
+	s3Endpoint  string
+	s3Region    string
+	s3PathStyle bool
+}
+
+// setupWorkspaces puts the workspaces on the store selected by the options.
+func setupWorkspaces(o *workspaceStorageOptions) error {
+	switch o.kind {
+	case "", "local":
+		return nil
+	case "s3":
+		charts, err := newS3Store(o, "charts")
+		if err != nil {
+			return err
+		}
+		packages, err := newS3Store(o, "chart-package")
+		if err != nil {
+			return err
+		}
+		history, err := newS3Store(o, "chart-history")
+		if err != nil {
+			return err
+		}
+		meta, err := newS3Store(o, "chart-meta")
+		if err != nil {
+			return err
+		}
+		starters, err := newS3Store(o, "starters")
+		if err != nil {
+			return err
+		}
+		keys, err := newS3Store(o, "keyring")
+		if err != nil {
+			return err
+		}
+		chartWorkspace.store = charts
+		packageWorkspace.store = packages
+		historyWorkspace.store = history
+		metaWorkspace.store = meta
+		starterWorkspace.store = starters
+		keyringWorkspace.store = keys
+		return nil
+	}
+	return errors.Errorf("unknown workspace storage %q", o.kind)
+}
+
+var (
+	errInvalidPath          = errors.New("invalid path")
+	errPathOutsideWorkspace = errors.New("path is outside of the workspace")
+)
+
+// validName checks a name used as a single path segment, like a chart name.
+func validName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/\\\x00") {
+		return errors.Wrapf(errInvalidPath, "%q", name)
+	}
+	return nil
+}
+
+// workspaceStatus maps an error of a workspace operation to a http status code.
+func workspaceStatus(err error) int {
+	switch {
+	case errors.Is(err, errInvalidPath), errors.Is(err, errPathOutsideWorkspace):
+		return http.StatusBadRequest
+	case os.IsNotExist(errors.Cause(err)):
+		return http.StatusNotFound
+	case errors.Is(err, errInvalidArchive), errors.Is(err, errInvalidKey), errors.Is(err, errInvalidChartRef),
+		errors.Is(err, errInvalidLintInfo):
+		return http.StatusBadRequest
+	case errors.Is(err, errChartNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, errUploadTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, errAlreadyExists):
+		return http.StatusConflict
+	case errors.Is(err, errPreconditionFailed):
+		return http.StatusPreconditionFailed
+	case errors.Is(err, errPreconditionRequired):
+		return http.StatusPreconditionRequired
+	case errors.Is(err, errInvalidMetadata), errors.Is(err, errInvalidPatch), errors.Is(err, errVerificationFailed):
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusInternalServerError
+}
+
+// cleanPath turns the slash separated, relative path name into its clean form,
+// rejecting absolute paths and ".." segments.
+func cleanPath(name string) (string, error) {
+	if strings.ContainsAny(name, "\\\x00") {
+		return "", errors.Wrapf(errInvalidPath, "%q", name)
+	}
+	if path.IsAbs(name) || filepath.IsAbs(name) {
+		return "", errors.Wrapf(errPathOutsideWorkspace, "%q", name)
+	}
+	for _, segment := range strings.Split(name, "/") {
+		if segment == ".." {
+			return "", errors.Wrapf(errPathOutsideWorkspace, "%q", name)
+		}
+	}
+	return path.Clean("/" + name)[1:], nil
+}
+
+// name resolves the elements, joined with slashes, to a name in the store.
+func (w *workspace) name(elem ...string) (string, error) {
+	return cleanPath(strings.Join(elem, "/"))
+}
+
+func (w *workspace) readFile(elem ...string) ([]byte, error) {
+	name, err := w.name(elem...)
+	if err != nil {
+		return nil, err
+	}
+	return w.store.readFile(name)
+}
+
+// writeFile writes a file, creating its parent directories as needed.
+func (w *workspace) writeFile(data []byte, elem ...string) error {
+	name, err := w.name(elem...)
+	if err != nil {
+		return err
+	}
+	if name == "" {
+		return errors.Wrapf(errInvalidPath, "%q", name)
+	}
+	return w.store.writeFile(name, data)
+}
+
+// createFile writes a file that doesn't exist yet, see workspaceStore.
+func (w *workspace) createFile(data []byte, elem ...string) error {
+	name, err := w.name(elem...)
+	if err != nil {
+		return err
+	}
+	if name == "" {
+		return errors.Wrapf(errInvalidPath, "%q", name)
+	}
+	return w.store.createFile(name, data)
+}
+
+func (w *workspace) stat(elem ...string) (os.FileInfo, error) {
+	name, err := w.name(elem...)
+	if err != nil {
+		return nil, err
+	}
+	return w.store.stat(name)
+}
+
+func (w *workspace) readDir(elem ...string) ([]os.FileInfo, error) {
+	name, err := w.name(elem...)
+	if err != nil {
+		return nil, err
+	}
+	return w.store.readDir(name)
+}
+
+// walk walks the tree below the elements, passing paths relative to the root
+// with slash separators to fn.
+func (w *workspace) walk(fn filepath.WalkFunc, elem ...string) error {
+	name, err := w.name(elem...)
+	if err != nil {
+		return err
+	}
+	return w.store.walk(name, fn)
+}
+
+func (w *workspace) remove(elem ...string) error {
+	name, err := w.removableName(elem...)
+	if err != nil {
+		return err
+	}
+	return w.store.remove(name)
+}
+
+func (w *workspace) removeAll(elem ...string) error {
+	name, err := w.removableName(elem...)
+	if err != nil {
+		return err
+	}
+	return w.store.removeAll(name)
+}
+
+// removableName resolves a name like name, but never to the root itself.
+func (w *workspace) removableName(elem ...string) (string, error) {
+	name, err := w.name(elem...)
+	if err != nil {
+		return "", err
+	}
+	if name == "" {
+		return "", errors.New("refusing to remove the workspace root")
+	}
+	return name, nil
+}
+
+// checkout returns a local directory with the tree below the elements, see
+// workspaceStore.
+func (w *workspace) checkout(elem ...string) (string, func(save bool) error, error) {
+	name, err := w.name(elem...)
+	if err != nil {
+		return "", nil, err
+	}
+	if name == "" {
+		return "", nil, errors.Wrapf(errInvalidPath, "%q", name)
+	}
+	return w.store.checkout(name)
+}
+
+// localStore keeps the files of a workspace in a directory on the local disk.
+// Symbolic links leading out of the directory are refused.
+type localStore struct {
+	root string
+}
+
+// path resolves a name to a path on disk below the root.
+func (s *localStore) path(name string) (string, error) {
+	// Ensure the workspace directory exists
+	if err := os.MkdirAll(s.root, os.ModePerm); err != nil && !os.IsExist(err) {
+		return "", err
+	}
+	root, err := filepath.Abs(s.root)
+	if err != nil {
+		return "", err
+	}
+	if root, err = filepath.EvalSymlinks(root); err != nil {
+		return "", err
+	}
+	full := filepath.Join(root, filepath.FromSlash(name))
+
+	// the deepest part of the path that exists must not lead out of the root
+	existing := full
+	for {
+		if _, err := os.Lstat(existing); err == nil {
+			break
+		}
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			break
+		}
+		existing = parent
+	}
+	real, err := filepath.EvalSymlinks(existing)
+	if err != nil {
+		return "", err
+	}
+	if real != root && !strings.HasPrefix(real, root+string(filepath.Separator)) {
+		return "", errors.Wrapf(errPathOutsideWorkspace, "%q", name)
+	}
+	return full, nil
+}
+
+func (s *localStore) readFile(name string) ([]byte, error) {
+	p, err := s.path(name)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(p)
+}
+
+func (s *localStore) writeFile(name string, data []byte) error {
+	p, err := s.path(name)
+	if err != nil {
+		return err
+	}
+	// Ensure the file path exists
+	if err := os.MkdirAll(filepath.Dir(p), os.ModePerm); err != nil && !os.IsExist(err) {
+		return err
+	}
+	return ioutil.WriteFile(p, data, 0644)
+}
+
+func (s *localStore) createFile(name string, data []byte) error {
+	p, err := s.path(name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), os.ModePerm); err != nil && !os.IsExist(err) {
+		return err
+	}
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(p)
+		return err
+	}
+	return f.Close()
+}
+
+func (s *localStore) stat(name string) (os.FileInfo, error) {
+	p, err := s.path(name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Stat(p)
+}
+
+func (s *localStore) readDir(name string) ([]os.FileInfo, error) {
+	p, err := s.path(name)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadDir(p)
+}
+
+func (s *localStore) walk(name string, fn filepath.WalkFunc) error {
+	p, err := s.path(name)
+	if err != nil {
+		return err
+	}
+	base, err := s.path("")
+	if err != nil {
+		return err
+	}
+	return filepath.Walk(p, func(file string, info os.FileInfo, err error) error {
+		rel, relErr := filepath.Rel(base, file)
+		if relErr != nil {
+			return relErr
+		}
+		return fn(filepath.ToSlash(rel), info, err)
+	})
+}
+
+func (s *localStore) remove(name string) error {
+	p, err := s.path(name)
+	if err != nil {
+		return err
+	}
+	return os.Remove(p)
+}
+
+func (s *localStore) removeAll(name string) error {
+	p, err := s.path(name)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(p)
+}
+
+// checkout hands out the directory itself, so changes are stored right away.
+func (s *localStore) checkout(name string) (string, func(save bool) error, error) {
+	p, err := s.path(name)
+	if err != nil {
+		return "", nil, err
+	}
+	return p, func(bool) error { return nil }, nil
+}
+
+// checkoutCopy implements checkout for stores that aren't on the local disk,
+// by copying the tree below name into a temporary directory. Saving writes
+// back the files that were added or changed and removes the files that were
+// deleted.
+func checkoutCopy(s workspaceStore, name string) (string, func(save bool) error, error) {
+	tmp, err := ioutil.TempDir("", "helm-rest-workspace")
+	if err != nil {
+		return "", nil, err
+	}
+	dir := filepath.Join(tmp, path.Base(name))
+	checkedOut := map[string][sha256.Size]byte{}
+	err = s.walk(name, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			if file == name && os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		target := filepath.Join(dir, filepath.FromSlash(strings.TrimPrefix(file, name)))
+		if info.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		data, err := s.readFile(file)
+		if err != nil {
+			return err
+		}
+		checkedOut[file] = sha256.Sum256(data)
+		return ioutil.WriteFile(target, data, 0644)
+	})
+	if err == nil {
+		err = os.MkdirAll(dir, 0755)
+	}
+	if err != nil {
+		os.RemoveAll(tmp)
+		return "", nil, err
+	}
+
+	done := func(save bool) error {
+		defer os.RemoveAll(tmp)
+		if !save {
+			return nil
+		}
+		err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+			if err != nil || !info.Mode().IsRegular() {
+				return err
+			}
+			rel, err := filepath.Rel(dir, p)
+			if err != nil {
+				return err
+			}
+			file := path.Join(name, filepath.ToSlash(rel))
+			data, err := ioutil.ReadFile(p)
+			if err != nil {
+				return err
+			}
+			sum, ok := checkedOut[file]
+			delete(checkedOut, file)
+			if ok && sum == sha256.Sum256(data) {
+				return nil
+			}
+			return s.writeFile(file, data)
+		})
+		if err != nil {
+			return err
+		}
+		// what is left was deleted from the directory
+		for file := range checkedOut {
+			if err := s.remove(file); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		return nil
+	}
+	return dir, done, nil
+}
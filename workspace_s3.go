@@ -0,0 +1,352 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/pkg/errors"
+)
+
+// s3Store keeps the files of a workspace as objects in a S3 compatible bucket,
+// one object per file, keyed by its path below the prefix. Directories aren't
+// stored, they exist as long as there are files in them. Credentials are taken
+// from the environment, e.g. AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY.
+type s3Store struct {
+	client *s3.S3
+	bucket string
+	prefix string
+}
+
+func newS3Store(o *workspaceStorageOptions, root string) (*s3Store, error) {
+	if o.s3Bucket == "" {
+		return nil, errors.New("the s3 workspace storage needs a bucket")
+	}
+	config := aws.NewConfig().WithS3ForcePathStyle(o.s3PathStyle)
+	if o.s3Region != "" {
+		config = config.WithRegion(o.s3Region)
+	}
+	if o.s3Endpoint != "" {
+		config = config.WithEndpoint(o.s3Endpoint)
+	}
+	sess, err := session.NewSession(config)
+	if err != nil {
+		return nil, err
+	}
+	return &s3Store{
+		client: s3.New(sess),
+		bucket: o.s3Bucket,
+		prefix: path.Join(o.s3Prefix, root),
+	}, nil
+}
+
+func (s *s3Store) key(name string) string {
+	return path.Join(s.prefix, name)
+}
+
+// dirKey is the prefix shared by the keys of the files in a directory.
+func (s *s3Store) dirKey(name string) string {
+	return s.key(name) + "/"
+}
+
+func (s *s3Store) readFile(name string) ([]byte, error) {
+	out, err := s.client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(name)),
+	})
+	if err != nil {
+		return nil, s.pathError("open", name, err)
+	}
+	defer out.Body.Close()
+	return ioutil.ReadAll(out.Body)
+}
+
+func (s *s3Store) writeFile(name string, data []byte) error {
+	_, err := s.client.PutObject(&s3.PutObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(name)),
+		Body:   bytes.NewReader(data),
+	})
+	if err != nil {
+		return s.pathError("write", name, err)
+	}
+	return nil
+}
+
+// createFile puts the object on the condition that there is none yet. Stores
+// that ignore the condition still get the existence checked beforehand.
+func (s *s3Store) createFile(name string, data []byte) error {
+	_, err := s.client.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(name)),
+	})
+	if err == nil {
+		return &os.PathError{Op: "create", Path: name, Err: os.ErrExist}
+	}
+	if !isS3NotFound(err) {
+		return s.pathError("create", name, err)
+	}
+	req, _ := s.client.PutObjectRequest(&s3.PutObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(name)),
+		Body:   bytes.NewReader(data),
+	})
+	req.HTTPRequest.Header.Set("If-None-Match", "*")
+	if err := req.Send(); err != nil {
+		if reqErr, ok := err.(awserr.RequestFailure); ok && reqErr.StatusCode() == http.StatusPreconditionFailed {
+			return &os.PathError{Op: "create", Path: name, Err: os.ErrExist}
+		}
+		return s.pathError("create", name, err)
+	}
+	return nil
+}
+
+func (s *s3Store) stat(name string) (os.FileInfo, error) {
+	if name != "" {
+		out, err := s.client.HeadObject(&s3.HeadObjectInput{
+			Bucket: aws.String(s.bucket),
+			Key:    aws.String(s.key(name)),
+		})
+		if err == nil {
+			return &objectInfo{
+				name:    path.Base(name),
+				size:    aws.Int64Value(out.ContentLength),
+				modTime: aws.TimeValue(out.LastModified),
+			}, nil
+		}
+		if !isS3NotFound(err) {
+			return nil, s.pathError("stat", name, err)
+		}
+	}
+	out, err := s.client.ListObjectsV2(&s3.ListObjectsV2Input{
+		Bucket:  aws.String(s.bucket),
+		Prefix:  aws.String(s.dirKey(name)),
+		MaxKeys: aws.Int64(1),
+	})
+	if err != nil {
+		return nil, s.pathError("stat", name, err)
+	}
+	if len(out.Contents) == 0 && name != "" {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+	}
+	return &objectInfo{name: path.Base(name), dir: true}, nil
+}
+
+func (s *s3Store) readDir(name string) ([]os.FileInfo, error) {
+	var infos []os.FileInfo
+	err := s.client.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket:    aws.String(s.bucket),
+		Prefix:    aws.String(s.dirKey(name)),
+		Delimiter: aws.String("/"),
+	}, func(out *s3.ListObjectsV2Output, last bool) bool {
+		for _, p := range out.CommonPrefixes {
+			infos = append(infos, &objectInfo{
+				name: path.Base(aws.StringValue(p.Prefix)),
+				dir:  true,
+			})
+		}
+		for _, o := range out.Contents {
+			infos = append(infos, objectInfoOf(o))
+		}
+		return true
+	})
+	if err != nil {
+		return nil, s.pathError("open", name, err)
+	}
+	if len(infos) == 0 && name != "" {
+		if _, err := s.stat(name); err != nil {
+			return nil, err
+		}
+		return nil, &os.PathError{Op: "readdirent", Path: name, Err: errors.New("not a directory")}
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+	return infos, nil
+}
+
+func (s *s3Store) walk(name string, fn filepath.WalkFunc) error {
+	info, err := s.stat(name)
+	if err != nil {
+		return fn(name, nil, err)
+	}
+	if !info.IsDir() {
+		return fn(name, info, nil)
+	}
+
+	// list the whole tree at once and hand it out in the order of filepath.Walk
+	children := map[string][]os.FileInfo{}
+	seen := map[string]bool{name: true}
+	err = s.listTree(name, func(o *s3.Object) {
+		file := strings.TrimPrefix(aws.StringValue(o.Key), s.prefix+"/")
+		if file == "" || strings.HasSuffix(file, "/") {
+			// a marker of an empty directory
+			return
+		}
+		dir := parentDir(file)
+		children[dir] = append(children[dir], objectInfoOf(o))
+		// add the directories on the way up, once
+		for !seen[dir] {
+			seen[dir] = true
+			parent := parentDir(dir)
+			children[parent] = append(children[parent], &objectInfo{name: path.Base(dir), dir: true})
+			dir = parent
+		}
+	})
+	if err != nil {
+		return fn(name, info, err)
+	}
+
+	var walkDir func(dir string, info os.FileInfo) error
+	walkDir = func(dir string, info os.FileInfo) error {
+		if err := fn(dir, info, nil); err != nil {
+			return err
+		}
+		entries := children[dir]
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+		for _, entry := range entries {
+			file := path.Join(dir, entry.Name())
+			if !entry.IsDir() {
+				if err := fn(file, entry, nil); err != nil && err != filepath.SkipDir {
+					return err
+				}
+				continue
+			}
+			if err := walkDir(file, entry); err != nil && err != filepath.SkipDir {
+				return err
+			}
+		}
+		return nil
+	}
+	err = walkDir(name, info)
+	if err == filepath.SkipDir {
+		return nil
+	}
+	return err
+}
+
+// listTree passes all the objects below name to fn.
+func (s *s3Store) listTree(name string, fn func(*s3.Object)) error {
+	return s.client.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(s.dirKey(name)),
+	}, func(out *s3.ListObjectsV2Output, last bool) bool {
+		for _, o := range out.Contents {
+			fn(o)
+		}
+		return true
+	})
+}
+
+func (s *s3Store) remove(name string) error {
+	info, err := s.stat(name)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		// directories only exist while they have files
+		return &os.PathError{Op: "remove", Path: name, Err: errors.New("directory not empty")}
+	}
+	_, err = s.client.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(name)),
+	})
+	if err != nil {
+		return s.pathError("remove", name, err)
+	}
+	return nil
+}
+
+func (s *s3Store) removeAll(name string) error {
+	keys := []*s3.ObjectIdentifier{{Key: aws.String(s.key(name))}}
+	err := s.listTree(name, func(o *s3.Object) {
+		keys = append(keys, &s3.ObjectIdentifier{Key: o.Key})
+	})
+	if err != nil {
+		return s.pathError("remove", name, err)
+	}
+	// at most 1000 keys can be deleted with one request
+	for len(keys) > 0 {
+		n := len(keys)
+		if n > 1000 {
+			n = 1000
+		}
+		_, err := s.client.DeleteObjects(&s3.DeleteObjectsInput{
+			Bucket: aws.String(s.bucket),
+			Delete: &s3.Delete{Objects: keys[:n], Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			return s.pathError("remove", name, err)
+		}
+		keys = keys[n:]
+	}
+	return nil
+}
+
+func (s *s3Store) checkout(name string) (string, func(save bool) error, error) {
+	return checkoutCopy(s, name)
+}
+
+// pathError wraps an error of the object store like the errors of package os,
+// so that missing objects can be told apart with os.IsNotExist.
+func (s *s3Store) pathError(op string, name string, err error) error {
+	if isS3NotFound(err) {
+		err = os.ErrNotExist
+	}
+	return &os.PathError{Op: op, Path: name, Err: err}
+}
+
+func isS3NotFound(err error) bool {
+	if reqErr, ok := err.(awserr.RequestFailure); ok && reqErr.StatusCode() == http.StatusNotFound {
+		return true
+	}
+	if awsErr, ok := err.(awserr.Error); ok {
+		return awsErr.Code() == s3.ErrCodeNoSuchKey
+	}
+	return false
+}
+
+// parentDir is path.Dir, with "" for the root.
+func parentDir(name string) string {
+	if dir := path.Dir(name); dir != "." {
+		return dir
+	}
+	return ""
+}
+
+// objectInfo describes an object, or a directory made up by the keys of objects.
+type objectInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+func objectInfoOf(o *s3.Object) *objectInfo {
+	return &objectInfo{
+		name:    path.Base(aws.StringValue(o.Key)),
+		size:    aws.Int64Value(o.Size),
+		modTime: aws.TimeValue(o.LastModified),
+	}
+}
+
+func (i *objectInfo) Name() string       { return i.name }
+func (i *objectInfo) Size() int64        { return i.size }
+func (i *objectInfo) ModTime() time.Time { return i.modTime }
+func (i *objectInfo) IsDir() bool        { return i.dir }
+func (i *objectInfo) Sys() interface{}   { return nil }
+
+func (i *objectInfo) Mode() os.FileMode {
+	if i.dir {
+		return os.ModeDir | 0755
+	}
+	return 0644
+}
@@ -0,0 +1,466 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// fakeS3 is a stand-in for a S3 bucket with the calls s3Store makes. It lists
+// at most pageSize keys at once, so that paging is exercised.
+type fakeS3 struct {
+	mu       sync.Mutex
+	objects  map[string][]byte
+	pageSize int
+}
+
+type fakeS3Object struct {
+	Key          string
+	LastModified string
+	Size         int
+}
+
+type fakeS3ListResult struct {
+	XMLName               xml.Name       `xml:"ListBucketResult"`
+	Name                  string         `xml:"Name"`
+	Prefix                string         `xml:"Prefix"`
+	KeyCount              int            `xml:"KeyCount"`
+	IsTruncated           bool           `xml:"IsTruncated"`
+	NextContinuationToken string         `xml:"NextContinuationToken,omitempty"`
+	Contents              []fakeS3Object `xml:"Contents"`
+	CommonPrefixes        []struct {
+		Prefix string `xml:"Prefix"`
+	} `xml:"CommonPrefixes"`
+}
+
+type fakeS3Delete struct {
+	Objects []struct {
+		Key string `xml:"Key"`
+	} `xml:"Object"`
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	key := ""
+	if len(parts) == 2 {
+		key = parts[1]
+	}
+	query := r.URL.Query()
+	switch {
+	case r.Method == http.MethodGet && key == "" && query.Get("list-type") == "2":
+		f.list(w, query)
+	case r.Method == http.MethodPost && key == "" && query["delete"] != nil:
+		body, _ := ioutil.ReadAll(r.Body)
+		del := &fakeS3Delete{}
+		if err := xml.Unmarshal(body, del); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for _, o := range del.Objects {
+			delete(f.objects, o.Key)
+		}
+		fmt.Fprint(w, `<DeleteResult></DeleteResult>`)
+	case r.Method == http.MethodPut:
+		body, _ := ioutil.ReadAll(r.Body)
+		if _, ok := f.objects[key]; ok && r.Header.Get("If-None-Match") == "*" {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			fmt.Fprint(w, `<Error><Code>PreconditionFailed</Code><Message>At least one of the pre-conditions you specified did not hold</Message></Error>`)
+			return
+		}
+		f.objects[key] = body
+	case r.Method == http.MethodGet, r.Method == http.MethodHead:
+		data, ok := f.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			if r.Method == http.MethodGet {
+				fmt.Fprint(w, `<Error><Code>NoSuchKey</Code><Message>The specified key does not exist.</Message></Error>`)
+			}
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		if r.Method == http.MethodGet {
+			w.Write(data)
+		}
+	case r.Method == http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "not implemented", http.StatusNotImplemented)
+	}
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, query map[string][]string) {
+	get := func(name string) string {
+		if v := query[name]; len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+	prefix, delimiter, after := get("prefix"), get("delimiter"), get("continuation-token")
+	maxKeys := f.pageSize
+	if n, err := strconv.Atoi(get("max-keys")); err == nil && n < maxKeys {
+		maxKeys = n
+	}
+	keys := []string{}
+	for key := range f.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	result := &fakeS3ListResult{Prefix: prefix}
+	seenPrefixes := map[string]bool{}
+	for _, key := range keys {
+		if !strings.HasPrefix(key, prefix) || key <= after {
+			continue
+		}
+		if result.KeyCount == maxKeys {
+			result.IsTruncated = true
+			break
+		}
+		rest := strings.TrimPrefix(key, prefix)
+		if i := strings.Index(rest, delimiter); delimiter != "" && i >= 0 {
+			common := prefix + rest[:i+1]
+			if !seenPrefixes[common] {
+				seenPrefixes[common] = true
+				result.CommonPrefixes = append(result.CommonPrefixes, struct {
+					Prefix string `xml:"Prefix"`
+				}{common})
+				result.KeyCount++
+			}
+			// continue after all the keys of the common prefix, like S3
+			result.NextContinuationToken = common + "\uffff"
+			continue
+		}
+		result.Contents = append(result.Contents, fakeS3Object{
+			Key:          key,
+			LastModified: time.Now().UTC().Format(time.RFC3339),
+			Size:         len(f.objects[key]),
+		})
+		result.KeyCount++
+		result.NextContinuationToken = key
+	}
+	if !result.IsTruncated {
+		result.NextContinuationToken = ""
+	}
+	out, _ := xml.Marshal(result)
+	w.Write(out)
+}
+
+// newFakeS3Store returns a s3Store on a fakeS3.
+func newFakeS3Store(t *testing.T) (*s3Store, *fakeS3) {
+	t.Helper()
+	fake := &fakeS3{objects: map[string][]byte{}, pageSize: 2}
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+	sess, err := session.NewSession(aws.NewConfig().
+		WithEndpoint(srv.URL).
+		WithRegion("us-east-1").
+		WithS3ForcePathStyle(true).
+		WithCredentials(credentials.NewStaticCredentials("id", "secret", "")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &s3Store{client: s3.New(sess), bucket: "bucket", prefix: "prefix/charts"}, fake
+}
+
+// storesUnderTest returns the stores that must behave alike, each with the
+// same files.
+func storesUnderTest(t *testing.T) map[string]workspaceStore {
+	s3s, _ := newFakeS3Store(t)
+	stores := map[string]workspaceStore{
+		"local": &localStore{root: filepath.Join(t.TempDir(), "charts")},
+		"s3":    s3s,
+	}
+	files := []string{"a/Chart.yaml", "a/templates/x.yaml", "a/templates/sub/y.yaml", "a/values.yaml", "b.txt"}
+	for _, s := range stores {
+		for _, file := range files {
+			if err := s.writeFile(file, []byte("content of "+file)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return stores
+}
+
+func TestStoreStat(t *testing.T) {
+	tests := []struct {
+		name string
+		dir  bool
+		size int64
+		err  bool
+	}{
+		{name: "", dir: true},
+		{name: "a", dir: true},
+		{name: "a/templates", dir: true},
+		{name: "a/Chart.yaml", size: int64(len("content of a/Chart.yaml"))},
+		// a prefix of a key is no directory
+		{name: "a/temp", err: true},
+		{name: "a/Chart", err: true},
+		{name: "missing", err: true},
+	}
+	for kind, s := range storesUnderTest(t) {
+		for _, tt := range tests {
+			info, err := s.stat(tt.name)
+			if tt.err {
+				if !os.IsNotExist(err) {
+					t.Errorf("%s: stat(%q) = %v, want not exist", kind, tt.name, err)
+				}
+				continue
+			}
+			if err != nil {
+				t.Errorf("%s: stat(%q) = %v", kind, tt.name, err)
+				continue
+			}
+			if info.IsDir() != tt.dir || (!tt.dir && info.Size() != tt.size) {
+				t.Errorf("%s: stat(%q) = dir %v size %d, want dir %v size %d", kind, tt.name, info.IsDir(), info.Size(), tt.dir, tt.size)
+			}
+		}
+	}
+}
+
+func TestStoreReadDir(t *testing.T) {
+	tests := []struct {
+		name string
+		want []string
+	}{
+		{"", []string{"a/", "b.txt"}},
+		{"a", []string{"Chart.yaml", "templates/", "values.yaml"}},
+		{"a/templates", []string{"sub/", "x.yaml"}},
+	}
+	for kind, s := range storesUnderTest(t) {
+		for _, tt := range tests {
+			infos, err := s.readDir(tt.name)
+			if err != nil {
+				t.Errorf("%s: readDir(%q) = %v", kind, tt.name, err)
+				continue
+			}
+			got := []string{}
+			for _, info := range infos {
+				name := info.Name()
+				if info.IsDir() {
+					name += "/"
+				}
+				got = append(got, name)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("%s: readDir(%q) = %q, want %q", kind, tt.name, got, tt.want)
+			}
+		}
+		if _, err := s.readDir("missing"); !os.IsNotExist(err) {
+			t.Errorf("%s: readDir(missing) = %v, want not exist", kind, err)
+		}
+		if _, err := s.readDir("b.txt"); err == nil {
+			t.Errorf("%s: readDir of a file succeeded", kind)
+		}
+	}
+}
+
+func TestStoreWalk(t *testing.T) {
+	for kind, s := range storesUnderTest(t) {
+		got := []string{}
+		err := s.walk("a", func(name string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				name += "/"
+			}
+			got = append(got, name)
+			return nil
+		})
+		want := []string{"a/", "a/Chart.yaml", "a/templates/", "a/templates/sub/", "a/templates/sub/y.yaml", "a/templates/x.yaml", "a/values.yaml"}
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: walk = %q, %v, want %q", kind, got, err, want)
+		}
+
+		got = []string{}
+		err = s.walk("a", func(name string, info os.FileInfo, err error) error {
+			got = append(got, name)
+			if name == "a/templates" {
+				return filepath.SkipDir
+			}
+			return nil
+		})
+		want = []string{"a", "a/Chart.yaml", "a/templates", "a/values.yaml"}
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: walk skipping templates = %q, %v, want %q", kind, got, err, want)
+		}
+
+		var walkErr error
+		s.walk("missing", func(name string, info os.FileInfo, err error) error {
+			walkErr = err
+			return nil
+		})
+		if !os.IsNotExist(walkErr) {
+			t.Errorf("%s: walk(missing) passed %v, want not exist", kind, walkErr)
+		}
+	}
+}
+
+func TestStoreRemove(t *testing.T) {
+	for kind, s := range storesUnderTest(t) {
+		if err := s.remove("a"); err == nil {
+			t.Errorf("%s: remove of a directory with files succeeded", kind)
+		}
+		if err := s.remove("missing"); !os.IsNotExist(err) {
+			t.Errorf("%s: remove(missing) = %v, want not exist", kind, err)
+		}
+		if err := s.removeAll("a/templates"); err != nil {
+			t.Errorf("%s: removeAll = %v", kind, err)
+		}
+		if _, err := s.stat("a/templates"); !os.IsNotExist(err) {
+			t.Errorf("%s: stat after removeAll = %v, want not exist", kind, err)
+		}
+		if _, err := s.stat("a/templates/sub/y.yaml"); !os.IsNotExist(err) {
+			t.Errorf("%s: file left after removeAll: %v", kind, err)
+		}
+		if _, err := s.stat("a/Chart.yaml"); err != nil {
+			t.Errorf("%s: removeAll removed a sibling: %v", kind, err)
+		}
+		if err := s.removeAll("missing"); err != nil {
+			t.Errorf("%s: removeAll(missing) = %v", kind, err)
+		}
+		if err := s.removeAll("a"); err != nil {
+			t.Errorf("%s: removeAll = %v", kind, err)
+		}
+		infos, err := s.readDir("")
+		if err != nil || len(infos) != 1 || infos[0].Name() != "b.txt" {
+			t.Errorf("%s: root after removeAll = %v, %v", kind, infos, err)
+		}
+	}
+}
+
+func TestStoreCreateFile(t *testing.T) {
+	for kind, s := range storesUnderTest(t) {
+		if err := s.createFile("a/new.yaml", []byte("new")); err != nil {
+			t.Errorf("%s: createFile = %v", kind, err)
+		}
+		if data, _ := s.readFile("a/new.yaml"); string(data) != "new" {
+			t.Errorf("%s: created file = %q", kind, data)
+		}
+		if err := s.createFile("a/new.yaml", []byte("again")); !os.IsExist(err) {
+			t.Errorf("%s: createFile of an existing file = %v, want exists", kind, err)
+		}
+		if data, _ := s.readFile("a/new.yaml"); string(data) != "new" {
+			t.Errorf("%s: existing file overwritten with %q", kind, data)
+		}
+	}
+}
+
+func TestS3CreateFileConditional(t *testing.T) {
+	s, fake := newFakeS3Store(t)
+	// another replica puts the object between the check and the put
+	fake.objects["prefix/charts/race"] = []byte("other")
+	req, _ := s.client.PutObjectRequest(&s3.PutObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key("race")),
+		Body:   strings.NewReader("mine"),
+	})
+	req.HTTPRequest.Header.Set("If-None-Match", "*")
+	if err := req.Send(); err == nil {
+		t.Fatal("the stand-in ignores If-None-Match")
+	}
+	if err := s.createFile("race", []byte("mine")); !os.IsExist(err) {
+		t.Errorf("createFile = %v, want exists", err)
+	}
+	if string(fake.objects["prefix/charts/race"]) != "other" {
+		t.Error("object overwritten")
+	}
+}
+
+func TestRemoveAllManyObjects(t *testing.T) {
+	s, fake := newFakeS3Store(t)
+	fake.pageSize = 1000
+	for i := 0; i < 1500; i++ {
+		fake.objects[fmt.Sprintf("prefix/charts/many/f%04d", i)] = []byte("x")
+	}
+	fake.objects["prefix/charts/other"] = []byte("x")
+	if err := s.removeAll("many"); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.objects) != 1 {
+		t.Errorf("%d objects left, want 1", len(fake.objects))
+	}
+}
+
+func TestCheckoutCopy(t *testing.T) {
+	for kind, s := range storesUnderTest(t) {
+		dir, done, err := checkoutCopy(s, "a")
+		if err != nil {
+			t.Fatalf("%s: %v", kind, err)
+		}
+		data, err := ioutil.ReadFile(filepath.Join(dir, "templates", "sub", "y.yaml"))
+		if err != nil || string(data) != "content of a/templates/sub/y.yaml" {
+			t.Errorf("%s: checked out file = %q, %v", kind, data, err)
+		}
+		if filepath.Base(dir) != "a" {
+			t.Errorf("%s: checked out into %s, want a directory named a", kind, dir)
+		}
+		ioutil.WriteFile(filepath.Join(dir, "Chart.yaml"), []byte("changed"), 0644)
+		ioutil.WriteFile(filepath.Join(dir, "templates", "new.yaml"), []byte("new"), 0644)
+		os.Remove(filepath.Join(dir, "templates", "x.yaml"))
+
+		// without saving nothing changes
+		dir2, done2, err := checkoutCopy(s, "a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := done2(false); err != nil {
+			t.Error(err)
+		}
+		if _, err := os.Stat(dir2); !os.IsNotExist(err) {
+			t.Errorf("%s: checkout directory left behind", kind)
+		}
+		if data, _ := s.readFile("a/Chart.yaml"); string(data) != "content of a/Chart.yaml" {
+			t.Errorf("%s: unsaved change stored: %q", kind, data)
+		}
+
+		if err := done(true); err != nil {
+			t.Fatalf("%s: %v", kind, err)
+		}
+		if data, _ := s.readFile("a/Chart.yaml"); string(data) != "changed" {
+			t.Errorf("%s: changed file = %q", kind, data)
+		}
+		if data, _ := s.readFile("a/templates/new.yaml"); string(data) != "new" {
+			t.Errorf("%s: added file = %q", kind, data)
+		}
+		if _, err := s.stat("a/templates/x.yaml"); !os.IsNotExist(err) {
+			t.Errorf("%s: deleted file still there: %v", kind, err)
+		}
+		if data, _ := s.readFile("b.txt"); string(data) != "content of b.txt" {
+			t.Errorf("%s: file outside of the checkout changed: %q", kind, data)
+		}
+
+		// a checkout of a missing tree starts empty and creates it
+		dir, done, err = checkoutCopy(s, "c")
+		if err != nil {
+			t.Fatalf("%s: %v", kind, err)
+		}
+		ioutil.WriteFile(filepath.Join(dir, "Chart.yaml"), []byte("c"), 0644)
+		if err := done(true); err != nil {
+			t.Fatal(err)
+		}
+		if data, _ := s.readFile("c/Chart.yaml"); string(data) != "c" {
+			t.Errorf("%s: file of new tree = %q", kind, data)
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+// tempWorkspaces puts all workspaces on local stores in a temporary directory
+// for the duration of the test.
+func tempWorkspaces(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	workspaces := map[string]*workspace{
+		"charts":        chartWorkspace,
+		"chart-package": packageWorkspace,
+		"chart-history": historyWorkspace,
+		"chart-meta":    metaWorkspace,
+		"starters":      starterWorkspace,
+		"keyring":       keyringWorkspace,
+	}
+	for name, w := range workspaces {
+		w, store := w, w.store
+		w.store = &localStore{root: filepath.Join(dir, name)}
+		t.Cleanup(func() { w.store = store })
+	}
+	return dir
+}
+
+// chartArchive packs files into a gzipped tar archive, as helm package does.
+func chartArchive(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(zw)
+	for name, content := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestValidName(t *testing.T) {
+	tests := []struct {
+		name string
+		ok   bool
+	}{
+		{"mychart", true},
+		{"mychart-0.1.0.tgz", true},
+		{"..foo", true},
+		{"%2e%2e", true},
+		{"", false},
+		{".", false},
+		{"..", false},
+		{"../etc", false},
+		{"a/b", false},
+		{"/etc", false},
+		{`..\etc`, false},
+		{`a\b`, false},
+		{"a\x00b", false},
+	}
+	for _, tt := range tests {
+		err := validName(tt.name)
+		if tt.ok && err != nil {
+			t.Errorf("validName(%q) = %v, want nil", tt.name, err)
+		}
+		if !tt.ok && !errors.Is(err, errInvalidPath) {
+			t.Errorf("validName(%q) = %v, want errInvalidPath", tt.name, err)
+		}
+	}
+}
+
+func TestCleanPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+		err  error
+	}{
+		{"", "", nil},
+		{"templates/deployment.yaml", "templates/deployment.yaml", nil},
+		{"./templates//a.yaml", "templates/a.yaml", nil},
+		{"templates/../values.yaml", "", errPathOutsideWorkspace},
+		{"..", "", errPathOutsideWorkspace},
+		{"../other/Chart.yaml", "", errPathOutsideWorkspace},
+		{"a/../../b", "", errPathOutsideWorkspace},
+		{"/etc/passwd", "", errPathOutsideWorkspace},
+		{`..\..\etc\passwd`, "", errInvalidPath},
+		{`templates\a.yaml`, "", errInvalidPath},
+		{"a\x00b", "", errInvalidPath},
+		// encoded segments are no traversal, they name a file
+		{"%2e%2e/%2e%2e/etc/passwd", "%2e%2e/%2e%2e/etc/passwd", nil},
+		{"..%2fetc", "..%2fetc", nil},
+	}
+	for _, tt := range tests {
+		got, err := cleanPath(tt.path)
+		if tt.err != nil {
+			if !errors.Is(err, tt.err) {
+				t.Errorf("cleanPath(%q) = %q, %v, want %v", tt.path, got, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("cleanPath(%q) = %q, %v, want %q", tt.path, got, err, tt.want)
+		}
+	}
+}
+
+func TestLocalStorePath(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "charts")
+	s := &localStore{root: root}
+	if err := os.MkdirAll(filepath.Join(root, "mychart", "templates"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	outside := t.TempDir()
+	if err := os.Symlink(outside, filepath.Join(root, "mychart", "lnk")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("/etc", filepath.Join(root, "mychart", "etc")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(root, "mychart", "templates"), filepath.Join(root, "mychart", "inside")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		err  error
+	}{
+		{"", nil},
+		{"mychart", nil},
+		{"mychart/templates/new.yaml", nil},
+		{"mychart/new/dir/file.yaml", nil},
+		{"mychart/inside/a.yaml", nil},
+		{"mychart/lnk", errPathOutsideWorkspace},
+		{"mychart/lnk/file.yaml", errPathOutsideWorkspace},
+		{"mychart/lnk/new/file.yaml", errPathOutsideWorkspace},
+		{"mychart/etc/passwd", errPathOutsideWorkspace},
+	}
+	for _, tt := range tests {
+		_, err := s.path(tt.name)
+		if tt.err == nil && err != nil {
+			t.Errorf("path(%q) = %v, want nil", tt.name, err)
+		}
+		if tt.err != nil && !errors.Is(err, tt.err) {
+			t.Errorf("path(%q) = %v, want %v", tt.name, err, tt.err)
+		}
+	}
+
+	if err := s.writeFile("mychart/lnk/evil.yaml", []byte("x")); !errors.Is(err, errPathOutsideWorkspace) {
+		t.Errorf("writeFile through symlink = %v, want errPathOutsideWorkspace", err)
+	}
+	if _, err := os.Stat(filepath.Join(outside, "evil.yaml")); !os.IsNotExist(err) {
+		t.Errorf("file written outside of the workspace: %v", err)
+	}
+}
+
+func TestWorkspaceHostilePaths(t *testing.T) {
+	tempWorkspaces(t)
+	if err := packageWorkspace.writeFile([]byte("x"), "mychart", "mychart-0.1.0.tgz"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		elem []string
+		err  error
+	}{
+		{[]string{"mychart", "mychart-0.1.0.tgz"}, nil},
+		{[]string{"mychart", "../../outside.tgz"}, errPathOutsideWorkspace},
+		{[]string{"..", "charts", "mychart"}, errPathOutsideWorkspace},
+		{[]string{"/etc", "passwd"}, errPathOutsideWorkspace},
+		{[]string{"mychart", `..\..\outside.tgz`}, errInvalidPath},
+	}
+	for _, tt := range tests {
+		_, err := packageWorkspace.stat(tt.elem...)
+		if tt.err == nil && err != nil {
+			t.Errorf("stat(%q) = %v, want nil", tt.elem, err)
+		}
+		if tt.err != nil && !errors.Is(err, tt.err) {
+			t.Errorf("stat(%q) = %v, want %v", tt.elem, err, tt.err)
+		}
+	}
+
+	if err := packageWorkspace.writeFile([]byte("x"), ""); !errors.Is(err, errInvalidPath) {
+		t.Errorf("writeFile of the root = %v, want errInvalidPath", err)
+	}
+	if err := packageWorkspace.removeAll(""); err == nil {
+		t.Error("removeAll of the root succeeded")
+	}
+	if _, _, err := packageWorkspace.checkout(""); !errors.Is(err, errInvalidPath) {
+		t.Errorf("checkout of the root = %v, want errInvalidPath", err)
+	}
+}
+
+func TestUploadArchiveHostileNames(t *testing.T) {
+	tempWorkspaces(t)
+	chartYAML := "apiVersion: v2\nname: %s\nversion: 0.1.0\n"
+	valid := chartArchive(t, map[string]string{
+		"mychart/Chart.yaml": fmt.Sprintf(chartYAML, "mychart"),
+	})
+	for _, name := range []string{"..", "../evil", `..\evil`, "a/b"} {
+		for _, target := range []string{"chart", "package"} {
+			if _, err := uploadArchive(valid, target, name, false); !errors.Is(err, errInvalidPath) {
+				t.Errorf("uploadArchive(%s, %q) = %v, want errInvalidPath", target, name, err)
+			}
+		}
+	}
+
+	// the chart name of the archive becomes the workspace name
+	evil := chartArchive(t, map[string]string{
+		"mychart/Chart.yaml": fmt.Sprintf(chartYAML, ".."),
+	})
+	if _, err := uploadArchive(evil, "package", "", false); err == nil {
+		t.Error("uploadArchive of a chart named .. succeeded")
+	}
+
+	upload, err := uploadArchive(valid, "package", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if upload.Package != "mychart-0.1.0.tgz" {
+		t.Errorf("package = %q", upload.Package)
+	}
+	if _, err := packageWorkspace.stat("mychart", "mychart-0.1.0.tgz"); err != nil {
+		t.Error(err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(packageWorkspace.store.(*localStore).root, "mychart", "mychart-0.1.0.tgz"))
+	if err != nil || !bytes.Equal(data, valid) {
+		t.Errorf("stored package differs: %v", err)
+	}
+}